@@ -0,0 +1,312 @@
+package analytics
+
+import (
+	"errors"
+	"time"
+
+	"rickshaw-app/internal/config"
+	"rickshaw-app/internal/ridestate"
+
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+const (
+	Hour = "hour"
+	Day  = "day"
+
+	// MaxBuckets bounds how many periods a series may span.
+	MaxBuckets = 1000
+)
+
+var (
+	ErrInvalidInterval = errors.New("interval must be hour or day")
+	ErrInvalidRange    = errors.New("from must be before to")
+	ErrRangeTooLarge   = errors.New("range has too many periods for the interval")
+)
+
+// Filter selects rides by when they were requested and, optionally, the
+// zone they were requested in.
+type Filter struct {
+	From time.Time // inclusive
+	To   time.Time // exclusive
+	Zone string    // empty means every zone
+}
+
+// Revenue is in minor units and counts only what has been recorded as
+// driver earnings, i.e. completed rides and cancellation fees.
+type Revenue struct {
+	Gross      int64  `json:"gross"`
+	Commission int64  `json:"commission"`
+	Tips       int64  `json:"tips"`
+	Currency   string `json:"currency"`
+}
+
+type Summary struct {
+	From             time.Time `json:"from"`
+	To               time.Time `json:"to"`
+	Zone             string    `json:"zone,omitempty"`
+	Rides            int64     `json:"rides"`
+	Completed        int64     `json:"completed"`
+	Cancelled        int64     `json:"cancelled"`
+	CompletionRate   float64   `json:"completion_rate"`
+	CancellationRate float64   `json:"cancellation_rate"`
+	AvgWaitSeconds   float64   `json:"avg_wait_seconds"`
+	AvgFare          float64   `json:"avg_fare"`
+	AvgDistance      float64   `json:"avg_distance"`
+	ActiveDrivers    int64     `json:"active_drivers"`
+	Revenue          Revenue   `json:"revenue"`
+}
+
+// Bucket is one period of a series. Rides counts the rides requested in
+// the period by their current status.
+type Bucket struct {
+	PeriodStart    time.Time        `json:"period_start"`
+	Rides          map[string]int64 `json:"rides"`
+	Total          int64            `json:"total"`
+	AvgWaitSeconds float64          `json:"avg_wait_seconds"`
+	ActiveDrivers  int64            `json:"active_drivers"`
+	Revenue        Revenue          `json:"revenue"`
+}
+
+// Service computes operational metrics with SQL aggregates over rides,
+// ride histories and driver earnings. Series periods are hours and days in
+// the operating timezone.
+type Service struct {
+	db       *gorm.DB
+	currency string
+	loc      *time.Location
+}
+
+func NewService(db *gorm.DB, cfg *config.Config) *Service {
+	return &Service{db: db, currency: cfg.Currency, loc: cfg.Timezone}
+}
+
+// period selects the start of the hour or day column falls in, in the
+// operating timezone. Timestamps are stored in UTC.
+func (s *Service) period(column, interval string) clause.Expr {
+	return gorm.Expr("date_trunc(?, "+column+" AT TIME ZONE 'UTC', ?) AS period", interval, s.loc.String())
+}
+
+// rides restricts a query joined to rides to f, timing rows by column.
+func (f Filter) rides(column string) func(*gorm.DB) *gorm.DB {
+	return func(db *gorm.DB) *gorm.DB {
+		db = db.Where(column+" >= ? AND "+column+" < ?", f.From, f.To)
+		if f.Zone != "" {
+			db = db.Where("rides.zone = ?", f.Zone)
+		}
+		return db
+	}
+}
+
+// Summary returns the metrics for the whole of f. Completion and
+// cancellation rates are shares of the rides that have finished either
+// way, so rides still in progress don't count against them. Wait time is
+// measured from the request to the first acceptance in the ride history.
+func (s *Service) Summary(f Filter) (*Summary, error) {
+	if !f.From.Before(f.To) {
+		return nil, ErrInvalidRange
+	}
+	completed, cancelled := string(ridestate.Completed), string(ridestate.Cancelled)
+
+	var totals struct {
+		Rides       int64
+		Completed   int64
+		Cancelled   int64
+		AvgFare     float64
+		AvgDistance float64
+	}
+	if err := s.db.Table("rides").
+		Select(`COUNT(*) AS rides,
+			COUNT(*) FILTER (WHERE status = ?) AS completed,
+			COUNT(*) FILTER (WHERE status = ?) AS cancelled,
+			COALESCE(AVG(fare) FILTER (WHERE status = ?), 0) AS avg_fare,
+			COALESCE(AVG(distance) FILTER (WHERE status = ?), 0) AS avg_distance`,
+			completed, cancelled, completed, completed).
+		Scopes(f.rides("rides.created_at")).
+		Scan(&totals).Error; err != nil {
+		return nil, err
+	}
+
+	sum := &Summary{
+		From:        f.From,
+		To:          f.To,
+		Zone:        f.Zone,
+		Rides:       totals.Rides,
+		Completed:   totals.Completed,
+		Cancelled:   totals.Cancelled,
+		AvgFare:     totals.AvgFare,
+		AvgDistance: totals.AvgDistance,
+	}
+	if finished := sum.Completed + sum.Cancelled; finished > 0 {
+		sum.CompletionRate = float64(sum.Completed) / float64(finished)
+		sum.CancellationRate = float64(sum.Cancelled) / float64(finished)
+	}
+
+	if err := s.db.Table("(?) AS w", s.waits(f, "")).
+		Select("COALESCE(AVG(EXTRACT(EPOCH FROM accepted_at - requested_at)), 0)").
+		Scan(&sum.AvgWaitSeconds).Error; err != nil {
+		return nil, err
+	}
+
+	if err := s.db.Table("rides").
+		Select("COUNT(DISTINCT driver_id)").
+		Scopes(f.rides("rides.accepted_at")).
+		Scan(&sum.ActiveDrivers).Error; err != nil {
+		return nil, err
+	}
+
+	if err := s.revenue(f).Scan(&sum.Revenue).Error; err != nil {
+		return nil, err
+	}
+	sum.Revenue.Currency = s.currency
+	return sum, nil
+}
+
+// Series returns the metrics for each hour or day of f, oldest first,
+// including periods with no activity. Active drivers are those who
+// accepted a ride in the period.
+func (s *Service) Series(f Filter, interval string) ([]Bucket, error) {
+	periods, err := periods(f, interval, s.loc)
+	if err != nil {
+		return nil, err
+	}
+	buckets := make([]Bucket, len(periods))
+	index := make(map[int64]*Bucket, len(periods))
+	for i, p := range periods {
+		buckets[i] = Bucket{PeriodStart: p, Rides: map[string]int64{}, Revenue: Revenue{Currency: s.currency}}
+		index[p.Unix()] = &buckets[i]
+	}
+	bucket := func(t time.Time) *Bucket {
+		return index[t.Unix()]
+	}
+
+	var counts []struct {
+		Period time.Time
+		Status string
+		Rides  int64
+	}
+	if err := s.db.Table("rides").
+		Select("?, status, COUNT(*) AS rides", s.period("rides.created_at", interval)).
+		Scopes(f.rides("rides.created_at")).
+		Group("period, status").
+		Scan(&counts).Error; err != nil {
+		return nil, err
+	}
+	for _, c := range counts {
+		if b := bucket(c.Period); b != nil {
+			b.Rides[c.Status] = c.Rides
+			b.Total += c.Rides
+		}
+	}
+
+	var waits []struct {
+		Period  time.Time
+		Seconds float64
+	}
+	if err := s.db.Table("(?) AS w", s.waits(f, interval)).
+		Select("period, COALESCE(AVG(EXTRACT(EPOCH FROM accepted_at - requested_at)), 0) AS seconds").
+		Group("period").
+		Scan(&waits).Error; err != nil {
+		return nil, err
+	}
+	for _, w := range waits {
+		if b := bucket(w.Period); b != nil {
+			b.AvgWaitSeconds = w.Seconds
+		}
+	}
+
+	var drivers []struct {
+		Period  time.Time
+		Drivers int64
+	}
+	if err := s.db.Table("rides").
+		Select("?, COUNT(DISTINCT driver_id) AS drivers", s.period("rides.accepted_at", interval)).
+		Scopes(f.rides("rides.accepted_at")).
+		Group("period").
+		Scan(&drivers).Error; err != nil {
+		return nil, err
+	}
+	for _, d := range drivers {
+		if b := bucket(d.Period); b != nil {
+			b.ActiveDrivers = d.Drivers
+		}
+	}
+
+	var revenue []struct {
+		Period time.Time
+		Revenue
+	}
+	if err := s.revenue(f).
+		Select("?, "+revenueColumns, s.period("e.earned_at", interval)).
+		Group("period").
+		Scan(&revenue).Error; err != nil {
+		return nil, err
+	}
+	for _, r := range revenue {
+		if b := bucket(r.Period); b != nil {
+			b.Revenue.Gross, b.Revenue.Commission, b.Revenue.Tips = r.Gross, r.Commission, r.Tips
+		}
+	}
+
+	return buckets, nil
+}
+
+// waits selects the request and first acceptance time of each ride in f,
+// with its period when interval is set.
+func (s *Service) waits(f Filter, interval string) *gorm.DB {
+	columns := `MIN(h.created_at) FILTER (WHERE h.status = ?) AS requested_at,
+		MIN(h.created_at) FILTER (WHERE h.status = ?) AS accepted_at`
+	args := []interface{}{string(ridestate.Requested), string(ridestate.Accepted)}
+	if interval != "" {
+		columns = "?, " + columns
+		args = append([]interface{}{s.period("rides.created_at", interval)}, args...)
+	}
+
+	return s.db.Table("ride_histories h").
+		Select(columns, args...).
+		Joins("JOIN rides ON rides.id = h.ride_id").
+		Scopes(f.rides("rides.created_at")).
+		Group("rides.id")
+}
+
+const revenueColumns = `COALESCE(SUM(e.fare), 0) AS gross,
+	COALESCE(SUM(e.commission), 0) AS commission,
+	COALESCE(SUM(e.tip), 0) AS tips`
+
+// revenue selects the earnings recorded in f, by when they were earned.
+func (s *Service) revenue(f Filter) *gorm.DB {
+	return s.db.Table("driver_earnings e").
+		Select(revenueColumns).
+		Joins("JOIN rides ON rides.id = e.ride_id").
+		Scopes(f.rides("e.earned_at"))
+}
+
+// periods lists the start of every hour or day in loc overlapping f.
+func periods(f Filter, interval string, loc *time.Location) ([]time.Time, error) {
+	if !f.From.Before(f.To) {
+		return nil, ErrInvalidRange
+	}
+
+	var step func(time.Time) time.Time
+	start := f.From.In(loc)
+	switch interval {
+	case Hour:
+		start = time.Date(start.Year(), start.Month(), start.Day(), start.Hour(), 0, 0, 0, loc)
+		step = func(t time.Time) time.Time { return t.Add(time.Hour) }
+	case Day:
+		start = time.Date(start.Year(), start.Month(), start.Day(), 0, 0, 0, 0, loc)
+		step = func(t time.Time) time.Time { return t.AddDate(0, 0, 1) }
+	default:
+		return nil, ErrInvalidInterval
+	}
+
+	var out []time.Time
+	for t := start; t.Before(f.To); t = step(t) {
+		if len(out) == MaxBuckets {
+			return nil, ErrRangeTooLarge
+		}
+		out = append(out, t)
+	}
+	return out, nil
+}
@@ -0,0 +1,90 @@
+package analytics
+
+import (
+	"testing"
+	"time"
+)
+
+func TestPeriods(t *testing.T) {
+	dhaka, err := time.LoadLocation("Asia/Dhaka")
+	if err != nil {
+		t.Fatal(err)
+	}
+	kathmandu, err := time.LoadLocation("Asia/Kathmandu")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name     string
+		from, to time.Time
+		interval string
+		loc      *time.Location
+		want     []time.Time
+		wantErr  error
+	}{
+		{
+			// 20:00 UTC on the 1st is already 02:00 on the 2nd in Dhaka.
+			name:     "days start at local midnight",
+			from:     time.Date(2026, 3, 1, 20, 0, 0, 0, time.UTC),
+			to:       time.Date(2026, 3, 3, 18, 0, 0, 0, time.UTC),
+			interval: Day,
+			loc:      dhaka,
+			want: []time.Time{
+				time.Date(2026, 3, 2, 0, 0, 0, 0, dhaka),
+				time.Date(2026, 3, 3, 0, 0, 0, 0, dhaka),
+			},
+		},
+		{
+			name:     "hours follow a non-whole-hour offset",
+			from:     time.Date(2026, 3, 1, 0, 30, 0, 0, time.UTC),
+			to:       time.Date(2026, 3, 1, 2, 0, 0, 0, time.UTC),
+			interval: Hour,
+			loc:      kathmandu,
+			want: []time.Time{
+				time.Date(2026, 3, 1, 6, 0, 0, 0, kathmandu),
+				time.Date(2026, 3, 1, 7, 0, 0, 0, kathmandu),
+			},
+		},
+		{
+			name:     "empty range",
+			from:     time.Date(2026, 3, 1, 0, 0, 0, 0, time.UTC),
+			to:       time.Date(2026, 3, 1, 0, 0, 0, 0, time.UTC),
+			interval: Day,
+			loc:      dhaka,
+			wantErr:  ErrInvalidRange,
+		},
+		{
+			name:     "unknown interval",
+			from:     time.Date(2026, 3, 1, 0, 0, 0, 0, time.UTC),
+			to:       time.Date(2026, 3, 2, 0, 0, 0, 0, time.UTC),
+			interval: "week",
+			loc:      dhaka,
+			wantErr:  ErrInvalidInterval,
+		},
+		{
+			name:     "too many periods",
+			from:     time.Date(2026, 1, 1, 0, 0, 0, 0, time.UTC),
+			to:       time.Date(2026, 3, 1, 0, 0, 0, 0, time.UTC),
+			interval: Hour,
+			loc:      dhaka,
+			wantErr:  ErrRangeTooLarge,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := periods(Filter{From: tt.from, To: tt.to}, tt.interval, tt.loc)
+			if err != tt.wantErr {
+				t.Fatalf("err = %v, want %v", err, tt.wantErr)
+			}
+			if len(got) != len(tt.want) {
+				t.Fatalf("got %d periods %v, want %v", len(got), got, tt.want)
+			}
+			for i := range got {
+				if !got[i].Equal(tt.want[i]) {
+					t.Errorf("period %d = %v, want %v", i, got[i], tt.want[i])
+				}
+			}
+		})
+	}
+}
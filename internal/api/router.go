@@ -1,11 +1,14 @@
 package api
 
 import (
+	"context"
 	"net/http"
 
 	"rickshaw-app/internal/config"
+	"rickshaw-app/internal/dispatch"
 	"rickshaw-app/internal/handlers"
 	"rickshaw-app/internal/middleware"
+	"rickshaw-app/internal/session"
 
 	"github.com/go-chi/chi/v5"
 	chimw "github.com/go-chi/chi/v5/middleware"
@@ -14,7 +17,9 @@ import (
 	"gorm.io/gorm"
 )
 
-func NewRouter(db *gorm.DB, rdb *redis.Client, cfg *config.Config) http.Handler {
+// NewRouter builds the API. Background work started by requests, such as
+// dispatching rides, stops when ctx is cancelled.
+func NewRouter(ctx context.Context, db *gorm.DB, rdb *redis.Client, cfg *config.Config) http.Handler {
 	r := chi.NewRouter()
 
 	r.Use(chimw.Logger)
@@ -31,8 +36,12 @@ func NewRouter(db *gorm.DB, rdb *redis.Client, cfg *config.Config) http.Handler
 
 	authHandler := handlers.NewAuthHandler(db, rdb, cfg)
 	driverHandler := handlers.NewDriverHandler(db, rdb, cfg)
-	rideHandler := handlers.NewRideHandler(db, rdb, cfg)
-	adminHandler := handlers.NewAdminHandler(db)
+	dispatcher := dispatch.NewDispatcher(ctx, db, rdb, cfg)
+	rideHandler := handlers.NewRideHandler(db, rdb, cfg, dispatcher)
+	zoneHandler := handlers.NewZoneHandler(db)
+	paymentHandler := handlers.NewPaymentHandler(db, rdb, cfg)
+	earningsHandler := handlers.NewEarningsHandler(db, rdb, cfg)
+	adminHandler := handlers.NewAdminHandler(db, rdb, cfg)
 
 	r.Get("/health", func(w http.ResponseWriter, r *http.Request) {
 		w.Header().Set("Content-Type", "application/json")
@@ -43,34 +52,60 @@ func NewRouter(db *gorm.DB, rdb *redis.Client, cfg *config.Config) http.Handler
 	r.Route("/api/v1", func(r chi.Router) {
 		r.Post("/auth/register", authHandler.Register)
 		r.Post("/auth/login", authHandler.Login)
+		r.Post("/auth/refresh", authHandler.Refresh)
+		r.Post("/auth/otp/request", authHandler.RequestOTP)
+		r.Post("/auth/otp/verify", authHandler.VerifyOTP)
+		r.Get("/service-area", zoneHandler.ServiceArea)
 
 		r.Group(func(r chi.Router) {
-			r.Use(middleware.AuthMiddleware(cfg.JWTSecret))
+			r.Use(middleware.AuthMiddleware(cfg.JWTSecret, session.NewStore(rdb)))
 
 			r.Post("/auth/logout", authHandler.Logout)
 			r.Get("/auth/me", authHandler.Me)
+			r.Get("/auth/sessions", authHandler.ListSessions)
+			r.Delete("/auth/sessions", authHandler.RevokeAllSessions)
+			r.Delete("/auth/sessions/{id}", authHandler.RevokeSession)
 
 			r.Post("/driver/profile", driverHandler.CreateProfile)
 			r.Get("/driver/profile", driverHandler.GetProfile)
 			r.Patch("/driver/location", driverHandler.UpdateLocation)
+			r.Post("/driver/location/batch", driverHandler.UpdateLocationBatch)
 			r.Patch("/driver/availability", driverHandler.UpdateAvailability)
+			r.Get("/driver/offer", rideHandler.GetOffer)
+			r.Get("/driver/earnings", earningsHandler.GetEarnings)
+			r.Get("/driver/payouts", earningsHandler.ListPayouts)
+			r.Get("/driver/payouts/{id}/statement", earningsHandler.GetPayoutStatement)
 
 			r.Post("/rides", rideHandler.CreateRide)
 			r.Post("/fares", rideHandler.CreateFare)
 			r.Get("/rides", rideHandler.GetRides)
 			r.Get("/rides/{id}", rideHandler.GetRide)
+			r.Get("/rides/{id}/events", rideHandler.StreamRide)
+			r.Get("/rides/{id}/route", rideHandler.GetRoute)
 			r.Post("/rides/{id}/accept", rideHandler.AcceptRide)
+			r.Post("/rides/{id}/offer/accept", rideHandler.AcceptOffer)
+			r.Post("/rides/{id}/offer/decline", rideHandler.DeclineOffer)
+			r.Post("/rides/{id}/arrive", rideHandler.ArriveRide)
+			r.Post("/rides/{id}/pin/reset", rideHandler.ResetStartPIN)
 			r.Post("/rides/{id}/start", rideHandler.StartRide)
 			r.Post("/rides/{id}/complete", rideHandler.CompleteRide)
+			r.Get("/rides/cancellation-reasons", rideHandler.CancellationReasons)
 			r.Post("/rides/{id}/cancel", rideHandler.CancelRide)
 			r.Post("/rides/{id}/rate", rideHandler.RateRide)
+			r.Get("/rides/{id}/payment", paymentHandler.GetPayment)
+			r.Post("/rides/{id}/payment/retry", paymentHandler.RetryPayment)
+			r.Post("/rides/{id}/tip", paymentHandler.TipRide)
+
+			r.Get("/wallet", paymentHandler.GetWallet)
+			r.Post("/wallet/topups", paymentHandler.TopUp)
+			r.Get("/wallet/transactions", paymentHandler.GetWalletTransactions)
 
 			r.Get("/drivers/nearby", driverHandler.GetNearbyDrivers)
 		})
 	})
 
 	r.Route("/admin", func(ar chi.Router) {
-		handlers.RegisterAdminRoutes(ar, adminHandler)
+		handlers.RegisterAdminRoutes(ar, adminHandler, rideHandler)
 	})
 
 	return r
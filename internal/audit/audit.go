@@ -0,0 +1,337 @@
+package audit
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"log"
+	"net"
+	"net/http"
+	"reflect"
+	"time"
+
+	"rickshaw-app/internal/models"
+
+	"github.com/go-chi/chi/v5"
+	chimw "github.com/go-chi/chi/v5/middleware"
+	"gorm.io/gorm"
+)
+
+const (
+	DefaultLimit = 100
+	MaxLimit     = 500
+)
+
+type contextKey struct{}
+
+// request is what Middleware keeps for one admin request: the change the
+// handler reports and the transaction it makes it in.
+type request struct {
+	r     *http.Request
+	actor string
+	db    *gorm.DB
+	tx    *gorm.DB
+
+	action     string
+	entityType string
+	entityID   string
+	before     json.RawMessage
+	after      json.RawMessage
+
+	afterCommit []func()
+	done        bool
+	row         *models.AuditLog
+}
+
+// Record describes the change the current admin request made to an entity.
+// before is nil for creations and after is nil for deletions. Values are
+// serialised immediately, so callers may keep mutating them. It is a no-op
+// outside Middleware.
+func Record(ctx context.Context, action, entityType, entityID string, before, after any) {
+	req, ok := ctx.Value(contextKey{}).(*request)
+	if !ok {
+		return
+	}
+	req.action = action
+	req.entityType = entityType
+	req.entityID = entityID
+	req.before = Snapshot(before)
+	req.after = Snapshot(after)
+}
+
+// Snapshot serialises v as it is now, for use as Record's before value when
+// the original will be overwritten by a reload.
+func Snapshot(v any) json.RawMessage {
+	if v == nil {
+		return nil
+	}
+	if raw, ok := v.(json.RawMessage); ok {
+		return raw
+	}
+	raw, err := json.Marshal(v)
+	if err != nil {
+		return nil
+	}
+	return raw
+}
+
+// DB returns the transaction Middleware runs the request in, so that the
+// handler's changes are committed together with their audit row. Outside
+// Middleware, or once the request has committed, it returns db.
+func DB(ctx context.Context, db *gorm.DB) *gorm.DB {
+	if req, ok := ctx.Value(contextKey{}).(*request); ok && !req.done {
+		return req.tx
+	}
+	return db
+}
+
+// AfterCommit runs fn once the request's transaction has committed, and
+// not at all if it is rolled back. Redis updates, events and payment
+// calls that follow a change belong here. Outside Middleware fn runs at
+// once.
+func AfterCommit(ctx context.Context, fn func()) {
+	if req, ok := ctx.Value(contextKey{}).(*request); ok && !req.done {
+		req.afterCommit = append(req.afterCommit, fn)
+		return
+	}
+	fn()
+}
+
+// Commit writes the audit row and commits the request's transaction
+// before the handler returns, for a change that has to be durable before
+// the handler calls out to a payment provider. The row is logged with
+// status 200 whatever the handler answers afterwards. It is a no-op
+// outside Middleware.
+func Commit(ctx context.Context) error {
+	req, ok := ctx.Value(contextKey{}).(*request)
+	if !ok || req.done {
+		return nil
+	}
+	return req.commit(http.StatusOK)
+}
+
+// commit writes the audit row in the request's transaction and commits
+// both, then runs the AfterCommit functions. A request that failed has its
+// changes rolled back, but the attempt is still logged.
+func (req *request) commit(status int) error {
+	action := req.action
+	if action == "" {
+		action = req.r.Method + " " + chi.RouteContext(req.r.Context()).RoutePattern()
+	}
+	row := &models.AuditLog{
+		Actor:      req.actor,
+		Action:     action,
+		EntityType: req.entityType,
+		EntityID:   req.entityID,
+		Before:     models.JSON(req.before),
+		After:      models.JSON(req.after),
+		Changes:    models.JSON(diff(req.before, req.after)),
+		Method:     req.r.Method,
+		Path:       req.r.URL.Path,
+		Status:     status,
+		RequestID:  chimw.GetReqID(req.r.Context()),
+		IP:         clientIP(req.r),
+	}
+
+	if req.done || status >= http.StatusBadRequest {
+		req.rollback()
+		if err := req.db.Create(row).Error; err != nil {
+			return err
+		}
+		req.row = row
+		return nil
+	}
+
+	req.done = true
+	if err := req.tx.Create(row).Error; err != nil {
+		req.tx.Rollback()
+		return err
+	}
+	if err := req.tx.Commit().Error; err != nil {
+		return err
+	}
+	req.row = row
+	for _, fn := range req.afterCommit {
+		fn()
+	}
+	req.afterCommit = nil
+	return nil
+}
+
+// rollback undoes the request's changes, if it hasn't committed.
+func (req *request) rollback() {
+	if req.done {
+		return
+	}
+	req.done = true
+	req.afterCommit = nil
+	req.tx.Rollback()
+}
+
+// Middleware writes an audit log row for every request that can change
+// state, i.e. anything but GET, HEAD and OPTIONS. actor names whoever made
+// the request; requests it can't name are refused rather than logged
+// anonymously. Handlers add the entity and its before and after state with
+// Record; requests that don't are still logged with their route.
+//
+// The request runs in a transaction, which handlers reach through DB. It
+// is committed with the audit row once the handler has answered, or rolled
+// back if the handler answered with an error. The handler's response is
+// held back until then, and replaced with a 500 if the row can't be
+// written, so no change is made or reported without a record of who made
+// it.
+func Middleware(db *gorm.DB, actor func(*http.Request) string) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			switch r.Method {
+			case http.MethodGet, http.MethodHead, http.MethodOptions:
+				next.ServeHTTP(w, r)
+				return
+			}
+
+			actorID := actor(r)
+			if actorID == "" {
+				http.Error(w, "unauthorized", http.StatusUnauthorized)
+				return
+			}
+
+			tx := db.Begin()
+			if tx.Error != nil {
+				log.Printf("audit: starting transaction for %s %s: %v", r.Method, r.URL.Path, tx.Error)
+				http.Error(w, "failed to record audit log", http.StatusInternalServerError)
+				return
+			}
+			req := &request{r: r, actor: actorID, db: db, tx: tx}
+			defer req.rollback()
+
+			buf := &bufferedResponse{header: http.Header{}}
+			next.ServeHTTP(buf, r.WithContext(context.WithValue(r.Context(), contextKey{}, req)))
+
+			status := buf.status
+			if status == 0 {
+				status = http.StatusOK
+			}
+			if req.row == nil {
+				if err := req.commit(status); err != nil {
+					log.Printf("audit: recording %s %s by %s: %v", r.Method, r.URL.Path, actorID, err)
+					http.Error(w, "failed to record audit log", http.StatusInternalServerError)
+					return
+				}
+			}
+
+			for k, v := range buf.header {
+				w.Header()[k] = v
+			}
+			w.WriteHeader(status)
+			w.Write(buf.body.Bytes())
+		})
+	}
+}
+
+// bufferedResponse holds a handler's response so Middleware can decide
+// whether to send it.
+type bufferedResponse struct {
+	header http.Header
+	status int
+	body   bytes.Buffer
+}
+
+func (b *bufferedResponse) Header() http.Header { return b.header }
+
+func (b *bufferedResponse) WriteHeader(status int) {
+	if b.status == 0 {
+		b.status = status
+	}
+}
+
+func (b *bufferedResponse) Write(p []byte) (int, error) {
+	if b.status == 0 {
+		b.status = http.StatusOK
+	}
+	return b.body.Write(p)
+}
+
+// diff returns the top-level fields that differ between two JSON objects
+// as {"field": {"from": ..., "to": ...}}, or nil if either side is not an
+// object.
+func diff(before, after json.RawMessage) json.RawMessage {
+	var b, a map[string]any
+	if len(before) > 0 && json.Unmarshal(before, &b) != nil {
+		return nil
+	}
+	if len(after) > 0 && json.Unmarshal(after, &a) != nil {
+		return nil
+	}
+	if b == nil && a == nil {
+		return nil
+	}
+
+	type change struct {
+		From any `json:"from"`
+		To   any `json:"to"`
+	}
+	changes := map[string]change{}
+	for k, v := range b {
+		if !reflect.DeepEqual(v, a[k]) {
+			changes[k] = change{From: v, To: a[k]}
+		}
+	}
+	for k, v := range a {
+		if _, ok := b[k]; !ok {
+			changes[k] = change{To: v}
+		}
+	}
+	if len(changes) == 0 {
+		return nil
+	}
+	raw, _ := json.Marshal(changes)
+	return raw
+}
+
+func clientIP(r *http.Request) string {
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		return r.RemoteAddr
+	}
+	return host
+}
+
+type Filter struct {
+	Actor      string
+	Action     string
+	EntityType string
+	EntityID   string
+	From       time.Time // inclusive; zero means unbounded
+	To         time.Time // exclusive; zero means unbounded
+	Limit      int
+}
+
+// Search returns matching entries, newest first.
+func Search(db *gorm.DB, f Filter) ([]models.AuditLog, error) {
+	query := db.Order("created_at DESC")
+	if f.Actor != "" {
+		query = query.Where("actor = ?", f.Actor)
+	}
+	if f.Action != "" {
+		query = query.Where("action = ?", f.Action)
+	}
+	if f.EntityType != "" {
+		query = query.Where("entity_type = ?", f.EntityType)
+	}
+	if f.EntityID != "" {
+		query = query.Where("entity_id = ?", f.EntityID)
+	}
+	if !f.From.IsZero() {
+		query = query.Where("created_at >= ?", f.From)
+	}
+	if !f.To.IsZero() {
+		query = query.Where("created_at < ?", f.To)
+	}
+	if f.Limit <= 0 || f.Limit > MaxLimit {
+		f.Limit = DefaultLimit
+	}
+
+	logs := []models.AuditLog{}
+	err := query.Limit(f.Limit).Find(&logs).Error
+	return logs, err
+}
@@ -0,0 +1,102 @@
+package audit
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"testing"
+	"time"
+
+	"rickshaw-app/internal/database"
+	"rickshaw-app/internal/models"
+
+	"github.com/go-chi/chi/v5"
+	"gorm.io/gorm"
+)
+
+func TestAfterCommitOutsideMiddleware(t *testing.T) {
+	ran := false
+	AfterCommit(context.Background(), func() { ran = true })
+	if !ran {
+		t.Fatal("AfterCommit outside Middleware did not run fn at once")
+	}
+	if err := Commit(context.Background()); err != nil {
+		t.Fatalf("Commit outside Middleware: %v", err)
+	}
+}
+
+// TestMiddlewareTransaction checks that a handler's changes are committed
+// with their audit row when it succeeds and rolled back, but still logged,
+// when it fails. It needs a migrated database in TEST_DATABASE_URL.
+func TestMiddlewareTransaction(t *testing.T) {
+	dsn := os.Getenv("TEST_DATABASE_URL")
+	if dsn == "" {
+		t.Skip("TEST_DATABASE_URL not set")
+	}
+	db, err := database.Connect(dsn)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name      string
+		status    int
+		committed bool
+	}{
+		{"success", http.StatusCreated, true},
+		{"failure", http.StatusConflict, false},
+	}
+	for i, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			key := fmt.Sprintf("audit-test-%d-%d", time.Now().UnixNano(), i)
+			t.Cleanup(func() { db.Where("key = ?", key).Delete(&models.ServiceZone{}) })
+
+			var afterCommit bool
+			r := chi.NewRouter()
+			r.Use(Middleware(db, func(*http.Request) string { return "tester" }))
+			r.Post("/zones", func(w http.ResponseWriter, r *http.Request) {
+				zone := models.ServiceZone{
+					Key:      key,
+					Name:     key,
+					Geometry: models.Geometry{Type: "Polygon", Coordinates: []byte("[]")},
+				}
+				if err := DB(r.Context(), db).Create(&zone).Error; err != nil {
+					http.Error(w, err.Error(), http.StatusInternalServerError)
+					return
+				}
+				AfterCommit(r.Context(), func() { afterCommit = true })
+				Record(r.Context(), "zone.create", "zone", zone.ID, nil, zone)
+				w.WriteHeader(tt.status)
+			})
+
+			rec := httptest.NewRecorder()
+			r.ServeHTTP(rec, httptest.NewRequest(http.MethodPost, "/zones", nil))
+			if rec.Code != tt.status {
+				t.Fatalf("status = %d, want %d", rec.Code, tt.status)
+			}
+
+			var zone models.ServiceZone
+			err := db.Where("key = ?", key).First(&zone).Error
+			if tt.committed && err != nil {
+				t.Fatalf("zone not committed: %v", err)
+			}
+			if !tt.committed && !errors.Is(err, gorm.ErrRecordNotFound) {
+				t.Fatalf("zone not rolled back: %v", err)
+			}
+			if afterCommit != tt.committed {
+				t.Errorf("AfterCommit ran = %v, want %v", afterCommit, tt.committed)
+			}
+
+			var logs int64
+			db.Model(&models.AuditLog{}).
+				Where("action = ? AND after->>'key' = ? AND status = ?", "zone.create", key, tt.status).
+				Count(&logs)
+			if logs != 1 {
+				t.Errorf("found %d audit rows, want 1", logs)
+			}
+		})
+	}
+}
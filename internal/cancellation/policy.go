@@ -0,0 +1,206 @@
+package cancellation
+
+import (
+	"errors"
+	"math"
+	"time"
+
+	"rickshaw-app/internal/config"
+	"rickshaw-app/internal/geo"
+	"rickshaw-app/internal/models"
+	"rickshaw-app/internal/ridestate"
+
+	"gorm.io/gorm"
+)
+
+// Reason codes. Riders, drivers and admins each have their own set;
+// "other" is available to all.
+const (
+	ReasonChangedPlans    = "changed_plans"
+	ReasonDriverLate      = "driver_late"
+	ReasonWrongPickup     = "wrong_pickup"
+	ReasonFoundOtherRide  = "found_other_ride"
+	ReasonRiderNoShow     = "rider_no_show"
+	ReasonVehicleIssue    = "vehicle_issue"
+	ReasonUnsafePickup    = "unsafe_pickup"
+	ReasonRiderRequested  = "rider_requested"
+	ReasonDriverBreakdown = "driver_breakdown"
+	ReasonSafetyConcern   = "safety_concern"
+	ReasonDuplicateRide   = "duplicate_ride"
+	ReasonOther           = "other"
+)
+
+// statsWindow is how far back a driver's cancellation rate looks.
+const statsWindow = 30 * 24 * time.Hour
+
+var (
+	ErrNotArrived     = errors.New("driver has not arrived at the pickup")
+	ErrNoShowTooEarly = errors.New("rider can only be marked a no-show after the waiting timeout")
+)
+
+var reasons = map[ridestate.Actor][]string{
+	ridestate.Rider:  {ReasonChangedPlans, ReasonDriverLate, ReasonWrongPickup, ReasonFoundOtherRide, ReasonOther},
+	ridestate.Driver: {ReasonRiderNoShow, ReasonVehicleIssue, ReasonUnsafePickup, ReasonRiderRequested, ReasonOther},
+	ridestate.Admin:  {ReasonDriverBreakdown, ReasonSafetyConcern, ReasonDuplicateRide, ReasonOther},
+}
+
+// Reasons returns the reason codes actor may cancel with.
+func Reasons(actor ridestate.Actor) []string {
+	return reasons[actor]
+}
+
+func ValidReason(actor ridestate.Actor, reason string) bool {
+	for _, r := range reasons[actor] {
+		if r == reason {
+			return true
+		}
+	}
+	return false
+}
+
+type Policy struct {
+	db                *gorm.DB
+	freeWindow        time.Duration
+	fee               float64
+	noShowFee         float64
+	arrivalRadiusKm   float64
+	noShowTimeout     time.Duration
+	flagRateThreshold float64
+	flagMinRides      int
+	pickupSpeedKmh    float64
+	pickupLateGrace   time.Duration
+}
+
+func NewPolicy(db *gorm.DB, cfg *config.Config) *Policy {
+	return &Policy{
+		db:                db,
+		freeWindow:        cfg.CancelFreeWindow,
+		fee:               cfg.CancelFee,
+		noShowFee:         cfg.NoShowFee,
+		arrivalRadiusKm:   cfg.ArrivalRadiusKm,
+		noShowTimeout:     cfg.NoShowTimeout,
+		flagRateThreshold: cfg.DriverCancelRateThreshold,
+		flagMinRides:      cfg.DriverCancelMinRides,
+		pickupSpeedKmh:    cfg.PickupSpeedKmh,
+		pickupLateGrace:   cfg.PickupLateGrace,
+	}
+}
+
+// NearPickup reports whether the driver's last known position is close
+// enough to the pickup to mark the ride arrived. Drivers with no position
+// yet are given the benefit of the doubt.
+func (p *Policy) NearPickup(ride *models.Ride, driver *models.Driver) bool {
+	if driver.CurrentLat == 0 && driver.CurrentLng == 0 {
+		return true
+	}
+	return geo.Distance(driver.CurrentLat, driver.CurrentLng, ride.PickupLat, ride.PickupLng) <= p.arrivalRadiusKm
+}
+
+// PickupETA estimates when the driver will reach the ride's pickup if they
+// set off at now. It returns nil for drivers with no position yet.
+func (p *Policy) PickupETA(ride *models.Ride, driver *models.Driver, now time.Time) *time.Time {
+	if (driver.CurrentLat == 0 && driver.CurrentLng == 0) || p.pickupSpeedKmh <= 0 {
+		return nil
+	}
+	km := geo.Distance(driver.CurrentLat, driver.CurrentLng, ride.PickupLat, ride.PickupLng)
+	eta := now.Add(time.Duration(km / p.pickupSpeedKmh * float64(time.Hour)))
+	return &eta
+}
+
+// driverLate reports whether a driver who has not arrived is to blame for
+// the rider cancelling: the rider says they are late, or they are more
+// than pickupLateGrace past their pickup ETA.
+func (p *Policy) driverLate(ride *models.Ride, reason string, now time.Time) bool {
+	if ride.ArrivedAt != nil {
+		return false
+	}
+	if reason == ReasonDriverLate {
+		return true
+	}
+	return ride.PickupETA != nil && now.After(ride.PickupETA.Add(p.pickupLateGrace))
+}
+
+// Fee returns what the rider is charged when actor cancels the ride for
+// reason. Riders cancel for free before a driver is assigned, within the
+// free window after acceptance, and when the driver is late to the pickup;
+// once the driver has arrived they pay the no-show fee, which compensates
+// the driver, and once the trip has started they pay for the part of it
+// they took. Drivers may only claim a rider no-show after waiting
+// noShowTimeout at the pickup.
+func (p *Policy) Fee(ride *models.Ride, actor ridestate.Actor, reason string, now time.Time) (float64, error) {
+	switch actor {
+	case ridestate.Rider:
+		if ride.DriverID == nil || ride.AcceptedAt == nil {
+			return 0, nil
+		}
+		if ridestate.Status(ride.Status) == ridestate.Started && ride.StartedAt != nil {
+			return p.partialFare(ride, now), nil
+		}
+		if ride.ArrivedAt != nil {
+			return p.noShowFee, nil
+		}
+		if p.driverLate(ride, reason, now) || now.Sub(*ride.AcceptedAt) <= p.freeWindow {
+			return 0, nil
+		}
+		return p.fee, nil
+	case ridestate.Driver:
+		if reason != ReasonRiderNoShow {
+			return 0, nil
+		}
+		if ridestate.Status(ride.Status) != ridestate.Arrived || ride.ArrivedAt == nil {
+			return 0, ErrNotArrived
+		}
+		if now.Sub(*ride.ArrivedAt) < p.noShowTimeout {
+			return 0, ErrNoShowTooEarly
+		}
+		return p.noShowFee, nil
+	default:
+		return 0, nil
+	}
+}
+
+// partialFare bills a trip the rider abandoned part way: the booked fare in
+// proportion to how much of the estimated duration has elapsed, at least
+// the cancellation fee and at most the full fare.
+func (p *Policy) partialFare(ride *models.Ride, now time.Time) float64 {
+	estimated := ride.Duration
+	if ride.EstimatedDuration != nil {
+		estimated = *ride.EstimatedDuration
+	}
+	share := 1.0
+	if estimated > 0 {
+		share = math.Min(1, now.Sub(*ride.StartedAt).Minutes()/float64(estimated))
+	}
+	fee := math.Max(p.fee, math.Round(ride.Fare*share*100)/100)
+	return math.Min(fee, ride.Fare)
+}
+
+// UpdateDriverStats recomputes the share of recently accepted rides the
+// driver cancelled and flags them for admins above the threshold.
+func (p *Policy) UpdateDriverStats(tx *gorm.DB, driverID string) error {
+	since := time.Now().Add(-statsWindow)
+
+	var accepted, cancelled int64
+	if err := tx.Model(&models.Ride{}).
+		Where("driver_id = ? AND accepted_at > ?", driverID, since).
+		Count(&accepted).Error; err != nil {
+		return err
+	}
+	if err := tx.Model(&models.Ride{}).
+		Where("driver_id = ? AND accepted_at > ? AND status = ? AND cancelled_by = ?",
+			driverID, since, string(ridestate.Cancelled), string(ridestate.Driver)).
+		Count(&cancelled).Error; err != nil {
+		return err
+	}
+
+	rate := 0.0
+	if accepted > 0 {
+		rate = float64(cancelled) / float64(accepted)
+	}
+	flagged := accepted >= int64(p.flagMinRides) && rate > p.flagRateThreshold
+
+	return tx.Model(&models.Driver{}).Where("id = ?", driverID).Updates(map[string]interface{}{
+		"cancellation_rate":    rate,
+		"cancellation_flagged": flagged,
+	}).Error
+}
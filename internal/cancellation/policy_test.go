@@ -0,0 +1,204 @@
+package cancellation
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"rickshaw-app/internal/models"
+	"rickshaw-app/internal/ridestate"
+)
+
+func testPolicy() *Policy {
+	return &Policy{
+		freeWindow:      2 * time.Minute,
+		fee:             30,
+		noShowFee:       50,
+		noShowTimeout:   5 * time.Minute,
+		pickupSpeedKmh:  20,
+		pickupLateGrace: 5 * time.Minute,
+	}
+}
+
+func TestFee(t *testing.T) {
+	now := time.Date(2026, 3, 1, 12, 0, 0, 0, time.UTC)
+	ago := func(d time.Duration) *time.Time {
+		at := now.Add(-d)
+		return &at
+	}
+	driverID := "driver"
+	estimated := 20
+
+	tests := []struct {
+		name    string
+		ride    models.Ride
+		actor   ridestate.Actor
+		reason  string
+		want    float64
+		wantErr error
+	}{
+		{
+			name:   "rider before a driver is assigned",
+			ride:   models.Ride{Status: string(ridestate.Requested)},
+			actor:  ridestate.Rider,
+			reason: ReasonChangedPlans,
+			want:   0,
+		},
+		{
+			name:   "rider within the free window",
+			ride:   models.Ride{Status: string(ridestate.Accepted), DriverID: &driverID, AcceptedAt: ago(time.Minute)},
+			actor:  ridestate.Rider,
+			reason: ReasonChangedPlans,
+			want:   0,
+		},
+		{
+			name:   "rider after the free window",
+			ride:   models.Ride{Status: string(ridestate.Accepted), DriverID: &driverID, AcceptedAt: ago(3 * time.Minute)},
+			actor:  ridestate.Rider,
+			reason: ReasonChangedPlans,
+			want:   30,
+		},
+		{
+			name:   "rider says the driver is late",
+			ride:   models.Ride{Status: string(ridestate.Accepted), DriverID: &driverID, AcceptedAt: ago(3 * time.Minute)},
+			actor:  ridestate.Rider,
+			reason: ReasonDriverLate,
+			want:   0,
+		},
+		{
+			name: "rider before the driver is due",
+			ride: models.Ride{Status: string(ridestate.Accepted), DriverID: &driverID,
+				AcceptedAt: ago(10 * time.Minute), PickupETA: ago(2 * time.Minute)},
+			actor:  ridestate.Rider,
+			reason: ReasonChangedPlans,
+			want:   30,
+		},
+		{
+			name: "rider after the driver failed to show",
+			ride: models.Ride{Status: string(ridestate.Accepted), DriverID: &driverID,
+				AcceptedAt: ago(20 * time.Minute), PickupETA: ago(10 * time.Minute)},
+			actor:  ridestate.Rider,
+			reason: ReasonFoundOtherRide,
+			want:   0,
+		},
+		{
+			name: "rider after the driver arrived",
+			ride: models.Ride{Status: string(ridestate.Arrived), DriverID: &driverID,
+				AcceptedAt: ago(10 * time.Minute), ArrivedAt: ago(time.Minute)},
+			actor:  ridestate.Rider,
+			reason: ReasonChangedPlans,
+			want:   50,
+		},
+		{
+			name: "rider says late once the driver arrived",
+			ride: models.Ride{Status: string(ridestate.Arrived), DriverID: &driverID,
+				AcceptedAt: ago(10 * time.Minute), ArrivedAt: ago(time.Minute)},
+			actor:  ridestate.Rider,
+			reason: ReasonDriverLate,
+			want:   50,
+		},
+		{
+			name: "rider part way through the trip",
+			ride: models.Ride{Status: string(ridestate.Started), DriverID: &driverID, Fare: 200,
+				EstimatedDuration: &estimated, AcceptedAt: ago(20 * time.Minute), StartedAt: ago(10 * time.Minute)},
+			actor:  ridestate.Rider,
+			reason: ReasonOther,
+			want:   100,
+		},
+		{
+			name: "rider just after the trip started",
+			ride: models.Ride{Status: string(ridestate.Started), DriverID: &driverID, Fare: 200,
+				EstimatedDuration: &estimated, AcceptedAt: ago(20 * time.Minute), StartedAt: ago(time.Minute)},
+			actor:  ridestate.Rider,
+			reason: ReasonOther,
+			want:   30,
+		},
+		{
+			name: "rider past the estimated duration",
+			ride: models.Ride{Status: string(ridestate.Started), DriverID: &driverID, Fare: 200,
+				EstimatedDuration: &estimated, AcceptedAt: ago(time.Hour), StartedAt: ago(40 * time.Minute)},
+			actor:  ridestate.Rider,
+			reason: ReasonOther,
+			want:   200,
+		},
+		{
+			name: "rider on a trip cheaper than the fee",
+			ride: models.Ride{Status: string(ridestate.Started), DriverID: &driverID, Fare: 20,
+				EstimatedDuration: &estimated, AcceptedAt: ago(20 * time.Minute), StartedAt: ago(time.Minute)},
+			actor:  ridestate.Rider,
+			reason: ReasonOther,
+			want:   20,
+		},
+		{
+			name:   "driver for a vehicle issue",
+			ride:   models.Ride{Status: string(ridestate.Accepted), DriverID: &driverID, AcceptedAt: ago(10 * time.Minute)},
+			actor:  ridestate.Driver,
+			reason: ReasonVehicleIssue,
+			want:   0,
+		},
+		{
+			name:    "driver claims no-show before arriving",
+			ride:    models.Ride{Status: string(ridestate.Accepted), DriverID: &driverID, AcceptedAt: ago(10 * time.Minute)},
+			actor:   ridestate.Driver,
+			reason:  ReasonRiderNoShow,
+			wantErr: ErrNotArrived,
+		},
+		{
+			name: "driver claims no-show too early",
+			ride: models.Ride{Status: string(ridestate.Arrived), DriverID: &driverID,
+				AcceptedAt: ago(10 * time.Minute), ArrivedAt: ago(2 * time.Minute)},
+			actor:   ridestate.Driver,
+			reason:  ReasonRiderNoShow,
+			wantErr: ErrNoShowTooEarly,
+		},
+		{
+			name: "driver claims no-show after waiting",
+			ride: models.Ride{Status: string(ridestate.Arrived), DriverID: &driverID,
+				AcceptedAt: ago(15 * time.Minute), ArrivedAt: ago(6 * time.Minute)},
+			actor:  ridestate.Driver,
+			reason: ReasonRiderNoShow,
+			want:   50,
+		},
+		{
+			name: "admin",
+			ride: models.Ride{Status: string(ridestate.Arrived), DriverID: &driverID,
+				AcceptedAt: ago(15 * time.Minute), ArrivedAt: ago(6 * time.Minute)},
+			actor:  ridestate.Admin,
+			reason: ReasonSafetyConcern,
+			want:   0,
+		},
+	}
+
+	p := testPolicy()
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := p.Fee(&tt.ride, tt.actor, tt.reason, now)
+			if !errors.Is(err, tt.wantErr) {
+				t.Fatalf("err = %v, want %v", err, tt.wantErr)
+			}
+			if got != tt.want {
+				t.Errorf("fee = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestPickupETA(t *testing.T) {
+	now := time.Date(2026, 3, 1, 12, 0, 0, 0, time.UTC)
+	ride := &models.Ride{PickupLat: 23.8103, PickupLng: 90.4125}
+	p := testPolicy()
+
+	if eta := p.PickupETA(ride, &models.Driver{}, now); eta != nil {
+		t.Errorf("driver with no position got ETA %v", eta)
+	}
+
+	// About 5 km north of the pickup, so 15 minutes at 20 km/h.
+	driver := &models.Driver{CurrentLat: 23.8553, CurrentLng: 90.4125}
+	eta := p.PickupETA(ride, driver, now)
+	if eta == nil {
+		t.Fatal("no ETA for a positioned driver")
+	}
+	if got := eta.Sub(now); got < 14*time.Minute || got > 16*time.Minute {
+		t.Errorf("ETA is %v away, want about 15m", got)
+	}
+}
@@ -1,7 +1,11 @@
 package config
 
 import (
+	"log"
 	"os"
+	"strconv"
+	"time"
+	_ "time/tzdata" // so TIMEZONE works on hosts without a zoneinfo database
 )
 
 type Config struct {
@@ -9,6 +13,44 @@ type Config struct {
 	RedisURL    string
 	ServerAddr  string
 	JWTSecret   string
+
+	AccessTokenTTL  time.Duration
+	RefreshTokenTTL time.Duration
+
+	SMSDriver   string
+	SMSFilePath string
+
+	FareQuoteTTL time.Duration
+	Currency     string
+	// Timezone is where the service operates; tariff time-of-day windows
+	// are in its local time.
+	Timezone *time.Location
+
+	PlatformCommissionRate float64
+
+	CancelFreeWindow          time.Duration
+	CancelFee                 float64
+	NoShowFee                 float64
+	ArrivalRadiusKm           float64
+	DriverCancelRateThreshold float64
+	DriverCancelMinRides      int
+	PickupSpeedKmh            float64
+	PickupLateGrace           time.Duration
+
+	WaitGracePeriod     time.Duration
+	WaitChargePerMinute float64
+	NoShowTimeout       time.Duration
+
+	SurgeInterval      time.Duration
+	SurgeMaxMultiplier float64
+	SurgeCellSizeDeg   float64
+
+	DispatchOfferTimeout time.Duration
+	DispatchRadiusKm     float64
+
+	LocationWriteInterval time.Duration
+	LocationMaxBatch      int
+	LocationMaxAccuracyM  float64
 }
 
 func Load() *Config {
@@ -17,6 +59,42 @@ func Load() *Config {
 		RedisURL:    getEnv("REDIS_URL", "localhost:6379"),
 		ServerAddr:  getEnv("SERVER_ADDR", "0.0.0.0:8080"),
 		JWTSecret:   getEnv("JWT_SECRET", "your-super-secret-key-change-in-production"),
+
+		AccessTokenTTL:  getEnvDuration("ACCESS_TOKEN_TTL", 15*time.Minute),
+		RefreshTokenTTL: getEnvDuration("REFRESH_TOKEN_TTL", 30*24*time.Hour),
+
+		SMSDriver:   getEnv("SMS_DRIVER", "log"),
+		SMSFilePath: getEnv("SMS_FILE_PATH", "sms_outbox.log"),
+
+		FareQuoteTTL: getEnvDuration("FARE_QUOTE_TTL", 5*time.Minute),
+		Currency:     getEnv("CURRENCY", "BDT"),
+		Timezone:     getEnvLocation("TIMEZONE", "Asia/Dhaka"),
+
+		PlatformCommissionRate: getEnvFloat("PLATFORM_COMMISSION_RATE", 0.2),
+
+		CancelFreeWindow:          getEnvDuration("CANCEL_FREE_WINDOW", 2*time.Minute),
+		CancelFee:                 getEnvFloat("CANCEL_FEE", 30),
+		NoShowFee:                 getEnvFloat("NO_SHOW_FEE", 50),
+		ArrivalRadiusKm:           getEnvFloat("ARRIVAL_RADIUS_KM", 0.1),
+		DriverCancelRateThreshold: getEnvFloat("DRIVER_CANCEL_RATE_THRESHOLD", 0.2),
+		DriverCancelMinRides:      getEnvInt("DRIVER_CANCEL_MIN_RIDES", 10),
+		PickupSpeedKmh:            getEnvFloat("PICKUP_SPEED_KMH", 20),
+		PickupLateGrace:           getEnvDuration("PICKUP_LATE_GRACE", 5*time.Minute),
+
+		WaitGracePeriod:     getEnvDuration("WAIT_GRACE_PERIOD", 3*time.Minute),
+		WaitChargePerMinute: getEnvFloat("WAIT_CHARGE_PER_MINUTE", 2),
+		NoShowTimeout:       getEnvDuration("NO_SHOW_TIMEOUT", 5*time.Minute),
+
+		SurgeInterval:      getEnvDuration("SURGE_INTERVAL", time.Minute),
+		SurgeMaxMultiplier: getEnvFloat("SURGE_MAX_MULTIPLIER", 2),
+		SurgeCellSizeDeg:   getEnvFloat("SURGE_CELL_SIZE_DEG", 0.01),
+
+		DispatchOfferTimeout: getEnvDuration("DISPATCH_OFFER_TIMEOUT", 20*time.Second),
+		DispatchRadiusKm:     getEnvPositiveFloat("DISPATCH_RADIUS_KM", 5),
+
+		LocationWriteInterval: getEnvDuration("LOCATION_WRITE_INTERVAL", 30*time.Second),
+		LocationMaxBatch:      getEnvInt("LOCATION_MAX_BATCH", 100),
+		LocationMaxAccuracyM:  getEnvFloat("LOCATION_MAX_ACCURACY_M", 50),
 	}
 }
 
@@ -26,3 +104,49 @@ func getEnv(key, fallback string) string {
 	}
 	return fallback
 }
+
+func getEnvDuration(key string, fallback time.Duration) time.Duration {
+	if value := os.Getenv(key); value != "" {
+		if d, err := time.ParseDuration(value); err == nil {
+			return d
+		}
+	}
+	return fallback
+}
+
+func getEnvInt(key string, fallback int) int {
+	if value := os.Getenv(key); value != "" {
+		if i, err := strconv.Atoi(value); err == nil {
+			return i
+		}
+	}
+	return fallback
+}
+
+func getEnvFloat(key string, fallback float64) float64 {
+	if value := os.Getenv(key); value != "" {
+		if f, err := strconv.ParseFloat(value, 64); err == nil {
+			return f
+		}
+	}
+	return fallback
+}
+
+// getEnvPositiveFloat is getEnvFloat for settings that are divided by, so a
+// zero or negative value stops the server at startup.
+func getEnvPositiveFloat(key string, fallback float64) float64 {
+	f := getEnvFloat(key, fallback)
+	if f <= 0 {
+		log.Fatalf("config: %s must be positive, got %v", key, f)
+	}
+	return f
+}
+
+func getEnvLocation(key, fallback string) *time.Location {
+	name := getEnv(key, fallback)
+	loc, err := time.LoadLocation(name)
+	if err != nil {
+		log.Fatalf("config: %s: %v", key, err)
+	}
+	return loc
+}
@@ -0,0 +1,329 @@
+package dispatch
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"sort"
+	"strings"
+	"time"
+
+	"rickshaw-app/internal/config"
+	"rickshaw-app/internal/geo"
+	"rickshaw-app/internal/models"
+	"rickshaw-app/internal/ridestate"
+
+	"github.com/redis/go-redis/v9"
+	"gorm.io/gorm"
+)
+
+const (
+	maxCandidates = 10
+
+	responseAccepted  = "accepted"
+	responseDeclined  = "declined"
+	responseWithdrawn = "withdrawn"
+)
+
+var ErrNoOffer = errors.New("no pending offer for this ride")
+
+// Dispatcher offers requested rides to nearby drivers one at a time. Offer
+// state lives in Redis so any API instance can serve the accept/decline call.
+type Dispatcher struct {
+	ctx       context.Context
+	db        *gorm.DB
+	rdb       *redis.Client
+	locations *geo.Index
+	offerTTL  time.Duration
+	radiusKm  float64
+}
+
+// NewDispatcher returns a dispatcher whose background runs stop when ctx
+// is cancelled.
+func NewDispatcher(ctx context.Context, db *gorm.DB, rdb *redis.Client, cfg *config.Config) *Dispatcher {
+	return &Dispatcher{
+		ctx:       ctx,
+		db:        db,
+		rdb:       rdb,
+		locations: geo.NewIndex(rdb),
+		offerTTL:  cfg.DispatchOfferTimeout,
+		radiusKm:  cfg.DispatchRadiusKm,
+	}
+}
+
+type Offer struct {
+	RideID    string    `json:"ride_id"`
+	ExpiresAt time.Time `json:"expires_at"`
+}
+
+func driverOfferKey(driverID string) string {
+	return "dispatch:driver:" + driverID
+}
+
+func responsesKey(rideID string) string {
+	return "dispatch:ride:" + rideID + ":responses"
+}
+
+// rideOfferKey holds the driver the ride is currently offered to.
+func rideOfferKey(rideID string) string {
+	return "dispatch:ride:" + rideID + ":offer"
+}
+
+// withdrawScript deletes a driver's offer only if it is still for this
+// ride, so an expired offer can't take a newer one with it.
+var withdrawScript = redis.NewScript(`
+if redis.call('GET', KEYS[1]) == ARGV[1] then
+	return redis.call('DEL', KEYS[1])
+end
+return 0
+`)
+
+// Dispatch starts offering the ride in the background.
+func (d *Dispatcher) Dispatch(rideID string) {
+	go d.run(d.ctx, rideID)
+}
+
+// CurrentOffer returns the ride currently offered to the driver, if any.
+func (d *Dispatcher) CurrentOffer(ctx context.Context, driverID string) (*Offer, error) {
+	key := driverOfferKey(driverID)
+	rideID, err := d.rdb.Get(ctx, key).Result()
+	if err == redis.Nil {
+		return nil, ErrNoOffer
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	ttl, err := d.rdb.PTTL(ctx, key).Result()
+	if err != nil {
+		return nil, err
+	}
+
+	return &Offer{RideID: rideID, ExpiresAt: time.Now().Add(ttl)}, nil
+}
+
+// Respond records the driver's answer to an offer. It returns ErrNoOffer if
+// the offer has expired or was made to someone else.
+func (d *Dispatcher) Respond(ctx context.Context, driverID, rideID string, accepted bool) error {
+	// Only take the offer down if it is still this ride's, so an answer
+	// racing the expiry can't remove the driver's next offer.
+	withdrawn, err := withdrawScript.Run(ctx, d.rdb, []string{driverOfferKey(driverID)}, rideID).Int()
+	if err != nil {
+		return err
+	}
+	if withdrawn == 0 {
+		return ErrNoOffer
+	}
+
+	response := responseDeclined
+	if accepted {
+		response = responseAccepted
+	}
+
+	pipe := d.rdb.TxPipeline()
+	pipe.RPush(ctx, responsesKey(rideID), driverID+":"+response)
+	pipe.Expire(ctx, responsesKey(rideID), d.offerTTL)
+	_, err = pipe.Exec(ctx)
+	return err
+}
+
+// Withdraw takes back the ride's outstanding offer and stops dispatching
+// it, for a ride a driver has accepted without being offered it.
+func (d *Dispatcher) Withdraw(ctx context.Context, rideID string) error {
+	driverID, err := d.rdb.Get(ctx, rideOfferKey(rideID)).Result()
+	if err == redis.Nil {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+
+	withdrawn, err := withdrawScript.Run(ctx, d.rdb, []string{driverOfferKey(driverID)}, rideID).Int()
+	if err != nil || withdrawn == 0 {
+		return err
+	}
+
+	pipe := d.rdb.TxPipeline()
+	pipe.RPush(ctx, responsesKey(rideID), driverID+":"+responseWithdrawn)
+	pipe.Expire(ctx, responsesKey(rideID), d.offerTTL)
+	_, err = pipe.Exec(ctx)
+	return err
+}
+
+func (d *Dispatcher) run(ctx context.Context, rideID string) {
+	defer d.rdb.Del(context.WithoutCancel(ctx), responsesKey(rideID), rideOfferKey(rideID))
+
+	var ride models.Ride
+	if err := d.db.WithContext(ctx).First(&ride, "id = ?", rideID).Error; err != nil {
+		log.Printf("dispatch: ride %s not found: %v", rideID, err)
+		return
+	}
+
+	candidates, err := d.rankCandidates(ctx, &ride)
+	if err != nil {
+		log.Printf("dispatch: failed to rank drivers for ride %s: %v", rideID, err)
+		return
+	}
+
+	for _, c := range candidates {
+		if !d.stillRequested(ctx, rideID) {
+			return
+		}
+
+		// A driver only holds one offer at a time.
+		ok, err := d.rdb.SetNX(ctx, driverOfferKey(c.DriverID), rideID, d.offerTTL).Result()
+		if err != nil || !ok {
+			continue
+		}
+		if err := d.rdb.Set(ctx, rideOfferKey(rideID), c.DriverID, d.offerTTL).Err(); err != nil {
+			log.Printf("dispatch: failed to record offer of ride %s to driver %s: %v", rideID, c.DriverID, err)
+		}
+
+		d.logHistory(ctx, rideID, ridestate.Offer, fmt.Sprintf("offered to driver %s (%.2f km, score %.2f)", c.DriverID, c.Distance, c.Score))
+
+		response := d.awaitResponse(ctx, rideID, c.DriverID)
+		if ctx.Err() != nil {
+			// Shutting down; the offer lapses on its own TTL.
+			return
+		}
+		switch response {
+		case responseAccepted, responseWithdrawn:
+			return
+		case responseDeclined:
+			d.logHistory(ctx, rideID, ridestate.DeclineOffer, fmt.Sprintf("declined by driver %s", c.DriverID))
+		default:
+			if err := withdrawScript.Run(ctx, d.rdb, []string{driverOfferKey(c.DriverID)}, rideID).Err(); err != nil {
+				log.Printf("dispatch: failed to withdraw offer of ride %s to driver %s: %v", rideID, c.DriverID, err)
+			}
+			d.logHistory(ctx, rideID, ridestate.ExpireOffer, fmt.Sprintf("driver %s did not respond", c.DriverID))
+		}
+	}
+
+	if d.stillRequested(ctx, rideID) {
+		d.logHistory(ctx, rideID, ridestate.Unassign, fmt.Sprintf("no driver accepted after %d offers", len(candidates)))
+	}
+}
+
+// awaitResponse blocks until the driver answers or the offer expires. An
+// empty string means the offer timed out.
+func (d *Dispatcher) awaitResponse(ctx context.Context, rideID, driverID string) string {
+	deadline := time.Now().Add(d.offerTTL)
+	for {
+		remaining := time.Until(deadline)
+		if remaining <= 0 {
+			return ""
+		}
+
+		result, err := d.rdb.BLPop(ctx, remaining, responsesKey(rideID)).Result()
+		if err != nil {
+			return ""
+		}
+
+		// result is [key, value]; ignore late answers to earlier offers.
+		from, response, ok := strings.Cut(result[1], ":")
+		if ok && from == driverID {
+			return response
+		}
+	}
+}
+
+func (d *Dispatcher) stillRequested(ctx context.Context, rideID string) bool {
+	var ride models.Ride
+	if err := d.db.WithContext(ctx).Select("status", "driver_id").First(&ride, "id = ?", rideID).Error; err != nil {
+		return false
+	}
+	return ridestate.Status(ride.Status) == ridestate.Requested && ride.DriverID == nil
+}
+
+type candidate struct {
+	DriverID string
+	Distance float64
+	Score    float64
+}
+
+// rankCandidates scores available drivers near the pickup by distance,
+// rating and how long they have been idle since their last trip.
+func (d *Dispatcher) rankCandidates(ctx context.Context, ride *models.Ride) ([]candidate, error) {
+	nearby, err := d.locations.Search(ctx, ride.PickupLat, ride.PickupLng, d.radiusKm, maxCandidates, "asc")
+	if err != nil {
+		return nil, err
+	}
+	if len(nearby) == 0 {
+		return nil, nil
+	}
+
+	ids := make([]string, 0, len(nearby))
+	for _, n := range nearby {
+		ids = append(ids, n.DriverID)
+	}
+
+	var drivers []models.Driver
+	if err := d.db.Where("id IN ? AND is_available = ?", ids, true).Find(&drivers).Error; err != nil {
+		return nil, err
+	}
+	now := time.Now()
+	byID := make(map[string]models.Driver, len(drivers))
+	for _, driver := range drivers {
+		if !driver.Blocked(now) {
+			byID[driver.ID] = driver
+		}
+	}
+
+	var lastTrips []struct {
+		DriverID string
+		LastAt   time.Time
+	}
+	if err := d.db.Model(&models.Ride{}).
+		Select("driver_id, MAX(COALESCE(completed_at, updated_at)) AS last_at").
+		Where("driver_id IN ?", ids).
+		Group("driver_id").
+		Scan(&lastTrips).Error; err != nil {
+		return nil, err
+	}
+	lastTripAt := make(map[string]time.Time, len(lastTrips))
+	for _, t := range lastTrips {
+		lastTripAt[t.DriverID] = t.LastAt
+	}
+
+	const idleCap = 30 * time.Minute
+
+	candidates := make([]candidate, 0, len(drivers))
+	for _, n := range nearby {
+		driver, ok := byID[n.DriverID]
+		if !ok {
+			continue
+		}
+
+		idle := idleCap
+		if at, ok := lastTripAt[driver.ID]; ok && now.Sub(at) < idleCap {
+			idle = now.Sub(at)
+		}
+
+		score := 0.5*(1-n.Distance/d.radiusKm) +
+			0.3*(driver.Rating/5) +
+			0.2*(float64(idle)/float64(idleCap))
+
+		candidates = append(candidates, candidate{DriverID: driver.ID, Distance: n.Distance, Score: score})
+	}
+
+	sort.SliceStable(candidates, func(i, j int) bool {
+		return candidates[i].Score > candidates[j].Score
+	})
+	return candidates, nil
+}
+
+// logHistory records a dispatch event in the ride's history. The ride is
+// still requested while it is being dispatched.
+func (d *Dispatcher) logHistory(ctx context.Context, rideID string, event ridestate.Event, note string) {
+	entry := &models.RideHistory{
+		RideID: rideID,
+		Status: string(ridestate.Requested),
+		Event:  string(event),
+		Actor:  string(ridestate.System),
+		Note:   note,
+	}
+	if err := d.db.WithContext(ctx).Create(entry).Error; err != nil {
+		log.Printf("dispatch: failed to record %s for ride %s: %v", event, rideID, err)
+	}
+}
@@ -0,0 +1,324 @@
+package earnings
+
+import (
+	"encoding/csv"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"strconv"
+	"time"
+
+	"rickshaw-app/internal/config"
+	"rickshaw-app/internal/models"
+	"rickshaw-app/internal/payments"
+	"rickshaw-app/internal/ridestate"
+
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+var (
+	ErrAlreadyTipped = errors.New("ride already tipped")
+	ErrNotPending    = errors.New("payout is not pending")
+	ErrPaidOut       = errors.New("ride earnings have already been paid out")
+)
+
+// Bucket aggregates a driver's earnings over one day or week.
+type Bucket struct {
+	PeriodStart   time.Time `json:"period_start"`
+	Rides         int       `json:"rides"`
+	Gross         int64     `json:"gross"`
+	Commission    int64     `json:"commission"`
+	Tips          int64     `json:"tips"`
+	Net           int64     `json:"net"`
+	CashCollected int64     `json:"cash_collected"`
+}
+
+type Service struct {
+	db       *gorm.DB
+	rate     float64
+	currency string
+}
+
+func NewService(db *gorm.DB, cfg *config.Config) *Service {
+	return &Service{db: db, rate: cfg.PlatformCommissionRate, currency: cfg.Currency}
+}
+
+// Record books the driver's share of a completed ride, or of the fee
+// charged when a ride is cancelled late. Recording the same ride twice is a
+// no-op.
+func (s *Service) Record(tx *gorm.DB, ride *models.Ride) error {
+	if ride.DriverID == nil {
+		return fmt.Errorf("ride %s has no driver", ride.ID)
+	}
+
+	fare := payments.AmountDue(ride)
+	cancelled := ride.Status == string(ridestate.Cancelled)
+	commission := int64(math.Round(float64(fare) * s.rate))
+	earning := models.DriverEarning{
+		RideID:         ride.ID,
+		DriverID:       *ride.DriverID,
+		Fare:           fare,
+		CommissionRate: s.rate,
+		Commission:     commission,
+		Net:            fare - commission,
+		Currency:       s.currency,
+		EarnedAt:       time.Now(),
+	}
+	if ride.PaymentMethod == payments.MethodCash && !cancelled {
+		earning.CashCollected = fare
+	}
+
+	res := tx.Clauses(clause.OnConflict{DoNothing: true}).Create(&earning)
+	if res.Error != nil || res.RowsAffected == 0 {
+		return res.Error
+	}
+
+	description := fmt.Sprintf("fare for ride %s", ride.ID)
+	if cancelled {
+		description = fmt.Sprintf("cancellation fee for ride %s", ride.ID)
+	}
+	return payments.Post(tx, payments.Entry{
+		Currency:    s.currency,
+		Description: description,
+		RideID:      &ride.ID,
+	},
+		payments.Posting{Account: payments.RiderAccount(ride.RiderID), Amount: fare},
+		payments.Posting{Account: payments.DriverEarningsAccount(earning.DriverID), Amount: -earning.Net},
+		payments.Posting{Account: payments.CommissionAccount, Amount: -commission},
+	)
+}
+
+// Adjust books a change of delta to the fare of a ride already recorded,
+// at the commission rate it was recorded with. Cash collected is left as
+// it was, so a cash fare cut comes out of the driver's next payout. Rides
+// that have been paid out can no longer be adjusted.
+func (s *Service) Adjust(tx *gorm.DB, ride *models.Ride, delta int64) error {
+	var earning models.DriverEarning
+	if err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).First(&earning, "ride_id = ?", ride.ID).Error; err != nil {
+		return err
+	}
+	if earning.PayoutID != nil {
+		return ErrPaidOut
+	}
+
+	commission := int64(math.Round(float64(delta) * earning.CommissionRate))
+	net := delta - commission
+	if err := tx.Model(&earning).Updates(map[string]interface{}{
+		"fare":       gorm.Expr("fare + ?", delta),
+		"commission": gorm.Expr("commission + ?", commission),
+		"net":        gorm.Expr("net + ?", net),
+	}).Error; err != nil {
+		return err
+	}
+
+	return payments.Post(tx, payments.Entry{
+		Currency:    earning.Currency,
+		Description: fmt.Sprintf("fare adjustment for ride %s", ride.ID),
+		RideID:      &ride.ID,
+	},
+		payments.Posting{Account: payments.RiderAccount(ride.RiderID), Amount: delta},
+		payments.Posting{Account: payments.DriverEarningsAccount(earning.DriverID), Amount: -net},
+		payments.Posting{Account: payments.CommissionAccount, Amount: -commission},
+	)
+}
+
+// ReserveTip claims the ride's one tip for amount while it is charged, so
+// concurrent tips can't both be charged. Reserving the amount already
+// reserved succeeds, letting a charge whose outcome was unknown be retried.
+func (s *Service) ReserveTip(rideID string, amount int64) error {
+	res := s.db.Model(&models.DriverEarning{}).
+		Where("ride_id = ? AND tip = 0 AND pending_tip IN ?", rideID, []int64{0, amount}).
+		Update("pending_tip", amount)
+	if res.Error != nil {
+		return res.Error
+	}
+	if res.RowsAffected == 0 {
+		return ErrAlreadyTipped
+	}
+	return nil
+}
+
+// ReleaseTip gives up a reservation whose charge was refused.
+func (s *Service) ReleaseTip(rideID string) error {
+	return s.db.Model(&models.DriverEarning{}).
+		Where("ride_id = ? AND tip = 0", rideID).
+		Update("pending_tip", 0).Error
+}
+
+// AddTip passes a reserved tip on to the driver in full, in the
+// transaction that records its charge. It reports false if the tip has
+// already been added.
+func (s *Service) AddTip(tx *gorm.DB, ride *models.Ride, amount int64) (bool, error) {
+	cash := int64(0)
+	if ride.PaymentMethod == payments.MethodCash {
+		cash = amount
+	}
+
+	res := tx.Model(&models.DriverEarning{}).
+		Where("ride_id = ? AND tip = 0 AND pending_tip = ?", ride.ID, amount).
+		Updates(map[string]interface{}{
+			"tip":            amount,
+			"pending_tip":    0,
+			"net":            gorm.Expr("net + ?", amount),
+			"cash_collected": gorm.Expr("cash_collected + ?", cash),
+		})
+	if res.Error != nil || res.RowsAffected == 0 {
+		return false, res.Error
+	}
+
+	return true, payments.Post(tx, payments.Entry{
+		Currency:    s.currency,
+		Description: fmt.Sprintf("tip for ride %s", ride.ID),
+		RideID:      &ride.ID,
+	},
+		payments.Posting{Account: payments.RiderAccount(ride.RiderID), Amount: amount},
+		payments.Posting{Account: payments.DriverEarningsAccount(*ride.DriverID), Amount: -amount},
+	)
+}
+
+// Summary aggregates a driver's earnings in [from, to) by "day" or "week".
+func (s *Service) Summary(driverID, period string, from, to time.Time) ([]Bucket, error) {
+	if period != "day" && period != "week" {
+		return nil, fmt.Errorf("invalid period %q", period)
+	}
+
+	buckets := []Bucket{}
+	err := s.db.Model(&models.DriverEarning{}).
+		Select(`date_trunc(?, earned_at) AS period_start, COUNT(*) AS rides,
+			SUM(fare) AS gross, SUM(commission) AS commission, SUM(tip) AS tips,
+			SUM(net) AS net, SUM(cash_collected) AS cash_collected`, period).
+		Where("driver_id = ? AND earned_at >= ? AND earned_at < ?", driverID, from, to).
+		Group("1").
+		Order("1").
+		Scan(&buckets).Error
+	return buckets, err
+}
+
+// CreatePayouts groups every unpaid earning in [from, to) into one pending
+// payout per driver. Earnings already in a payout are never paid again.
+func (s *Service) CreatePayouts(db *gorm.DB, from, to time.Time) ([]models.Payout, error) {
+	var created []models.Payout
+	err := db.Transaction(func(tx *gorm.DB) error {
+		var rows []models.DriverEarning
+		if err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).
+			Where("payout_id IS NULL AND earned_at >= ? AND earned_at < ?", from, to).
+			Order("driver_id, earned_at").
+			Find(&rows).Error; err != nil {
+			return err
+		}
+
+		byDriver := map[string]*models.Payout{}
+		var order []string
+		for _, e := range rows {
+			p, ok := byDriver[e.DriverID]
+			if !ok {
+				p = &models.Payout{
+					DriverID:    e.DriverID,
+					PeriodStart: from,
+					PeriodEnd:   to,
+					Currency:    s.currency,
+					Status:      "pending",
+				}
+				byDriver[e.DriverID] = p
+				order = append(order, e.DriverID)
+			}
+			p.Rides++
+			p.Gross += e.Fare
+			p.Commission += e.Commission
+			p.Tips += e.Tip
+			p.Net += e.Net
+			p.CashCollected += e.CashCollected
+		}
+
+		for _, driverID := range order {
+			p := byDriver[driverID]
+			p.Amount = p.Net - p.CashCollected
+			if err := tx.Create(p).Error; err != nil {
+				return err
+			}
+			if err := tx.Model(&models.DriverEarning{}).
+				Where("driver_id = ? AND payout_id IS NULL AND earned_at >= ? AND earned_at < ?", driverID, from, to).
+				Update("payout_id", p.ID).Error; err != nil {
+				return err
+			}
+			created = append(created, *p)
+		}
+		return nil
+	})
+	return created, err
+}
+
+// MarkPaid records that a pending payout has been sent and clears the
+// driver's earnings and cash balances in the ledger.
+func (s *Service) MarkPaid(db *gorm.DB, id string) (*models.Payout, error) {
+	var payout models.Payout
+	err := db.Transaction(func(tx *gorm.DB) error {
+		now := time.Now()
+		res := tx.Model(&models.Payout{}).
+			Where("id = ? AND status = ?", id, "pending").
+			Updates(map[string]interface{}{"status": "paid", "paid_at": now})
+		if res.Error != nil {
+			return res.Error
+		}
+		if res.RowsAffected == 0 {
+			return ErrNotPending
+		}
+		if err := tx.First(&payout, "id = ?", id).Error; err != nil {
+			return err
+		}
+
+		return payments.Post(tx, payments.Entry{
+			Currency:    payout.Currency,
+			Description: fmt.Sprintf("payout %s", payout.ID),
+		},
+			payments.Posting{Account: payments.DriverEarningsAccount(payout.DriverID), Amount: payout.Net},
+			payments.Posting{Account: payments.DriverCashAccount(payout.DriverID), Amount: -payout.CashCollected},
+			payments.Posting{Account: payments.PayoutAccount, Amount: -payout.Amount},
+		)
+	})
+	if err != nil {
+		return nil, err
+	}
+	return &payout, nil
+}
+
+// WriteStatement writes the payout's rides and totals as CSV. Amounts are
+// in major units.
+func (s *Service) WriteStatement(w io.Writer, payout *models.Payout) error {
+	var rows []models.DriverEarning
+	if err := s.db.Where("payout_id = ?", payout.ID).Order("earned_at").Find(&rows).Error; err != nil {
+		return err
+	}
+
+	out := csv.NewWriter(w)
+	out.Write([]string{"ride_id", "earned_at", "fare", "commission", "tip", "net", "cash_collected"})
+	for _, e := range rows {
+		out.Write([]string{
+			e.RideID,
+			e.EarnedAt.Format(time.RFC3339),
+			major(e.Fare),
+			major(e.Commission),
+			major(e.Tip),
+			major(e.Net),
+			major(e.CashCollected),
+		})
+	}
+	out.Write([]string{
+		"total",
+		payout.PeriodStart.Format("2006-01-02") + " to " + payout.PeriodEnd.Format("2006-01-02"),
+		major(payout.Gross),
+		major(payout.Commission),
+		major(payout.Tips),
+		major(payout.Net),
+		major(payout.CashCollected),
+	})
+	out.Write([]string{"payout", payout.Currency, "", "", "", major(payout.Amount), ""})
+	out.Flush()
+	return out.Error()
+}
+
+func major(minor int64) string {
+	return strconv.FormatFloat(float64(minor)/100, 'f', 2, 64)
+}
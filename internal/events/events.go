@@ -0,0 +1,63 @@
+package events
+
+import (
+	"context"
+	"encoding/json"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+)
+
+const (
+	TypeStatus   = "status"
+	TypeLocation = "location"
+)
+
+// RideEvent is pushed to everyone watching a ride.
+type RideEvent struct {
+	Type   string    `json:"type"`
+	RideID string    `json:"ride_id"`
+	Status string    `json:"status,omitempty"`
+	Lat    float64   `json:"lat,omitempty"`
+	Lng    float64   `json:"lng,omitempty"`
+	At     time.Time `json:"at"`
+}
+
+// Broker fans ride events out over Redis pub/sub so a subscriber connected
+// to one API instance sees events published by any other.
+type Broker struct {
+	rdb *redis.Client
+}
+
+func NewBroker(rdb *redis.Client) *Broker {
+	return &Broker{rdb: rdb}
+}
+
+func rideChannel(rideID string) string {
+	return "ride:" + rideID + ":events"
+}
+
+func (b *Broker) Publish(ctx context.Context, event RideEvent) error {
+	if event.At.IsZero() {
+		event.At = time.Now()
+	}
+	payload, err := json.Marshal(event)
+	if err != nil {
+		return err
+	}
+	return b.rdb.Publish(ctx, rideChannel(event.RideID), payload).Err()
+}
+
+func (b *Broker) PublishStatus(ctx context.Context, rideID, status string) error {
+	return b.Publish(ctx, RideEvent{Type: TypeStatus, RideID: rideID, Status: status})
+}
+
+func (b *Broker) PublishLocation(ctx context.Context, rideID string, lat, lng float64) error {
+	return b.Publish(ctx, RideEvent{Type: TypeLocation, RideID: rideID, Lat: lat, Lng: lng})
+}
+
+// Subscribe returns a subscription to a ride's events. The caller must
+// Close it.
+func (b *Broker) Subscribe(ctx context.Context, rideID string) *redis.PubSub {
+	return b.rdb.Subscribe(ctx, rideChannel(rideID))
+}
@@ -0,0 +1,202 @@
+package fare
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"time"
+
+	"rickshaw-app/internal/config"
+	"rickshaw-app/internal/models"
+
+	"gorm.io/gorm"
+)
+
+const DefaultVehicleClass = "standard"
+
+var ErrNoTariff = errors.New("no tariff configured for this vehicle class")
+
+type Input struct {
+	Distance     float64 // in km
+	Duration     int     // in minutes
+	VehicleClass string
+	Zone         string
+	At           time.Time
+	Surge        float64 // 0 or 1 means no surge
+}
+
+// Engine prices trips from the tariffs table.
+type Engine struct {
+	db  *gorm.DB
+	loc *time.Location
+}
+
+func NewEngine(db *gorm.DB, cfg *config.Config) *Engine {
+	return &Engine{db: db, loc: cfg.Timezone}
+}
+
+// Tariff returns the tariff in force at in.At for the vehicle class,
+// preferring one specific to in.Zone over the catch-all.
+func (e *Engine) Tariff(in Input) (*models.Tariff, error) {
+	var tariffs []models.Tariff
+	if err := e.db.
+		Where("vehicle_class = ? AND zone IN ? AND effective_from <= ?", in.VehicleClass, []string{in.Zone, ""}, in.At).
+		Find(&tariffs).Error; err != nil {
+		return nil, err
+	}
+	tariff := selectTariff(tariffs, in)
+	if tariff == nil {
+		return nil, ErrNoTariff
+	}
+	if err := e.db.Where("tariff_id = ?", tariff.ID).Find(&tariff.Multipliers).Error; err != nil {
+		return nil, err
+	}
+	return tariff, nil
+}
+
+// selectTariff picks the tariff for in from candidates: one of its vehicle
+// class in force at in.At, specific to in.Zone if there is one, otherwise
+// the catch-all, and the most recent of those.
+func selectTariff(candidates []models.Tariff, in Input) *models.Tariff {
+	var best *models.Tariff
+	for i := range candidates {
+		t := &candidates[i]
+		if t.VehicleClass != in.VehicleClass || t.EffectiveFrom.After(in.At) {
+			continue
+		}
+		if t.Zone != "" && t.Zone != in.Zone {
+			continue
+		}
+		switch {
+		case best == nil:
+			best = t
+		case t.Zone != best.Zone:
+			if t.Zone != "" {
+				best = t
+			}
+		case t.EffectiveFrom.After(best.EffectiveFrom):
+			best = t
+		}
+	}
+	return best
+}
+
+func (e *Engine) Quote(in Input) (*models.FareBreakdown, error) {
+	if in.VehicleClass == "" {
+		in.VehicleClass = DefaultVehicleClass
+	}
+	if in.At.IsZero() {
+		in.At = time.Now()
+	}
+	in.At = in.At.In(e.loc)
+
+	tariff, err := e.Tariff(in)
+	if err != nil {
+		return nil, err
+	}
+	return Calculate(tariff, in), nil
+}
+
+// Calculate prices a trip against a specific tariff. The time-of-day and
+// surge multipliers apply to the metered fare only, not the booking fee.
+// Time-of-day windows are matched against the clock in in.At's location,
+// which Quote and Reprice set to the operating timezone.
+func Calculate(tariff *models.Tariff, in Input) *models.FareBreakdown {
+	b := &models.FareBreakdown{TariffID: tariff.ID, SurgeMultiplier: 1}
+
+	add := func(code, label string, amount float64) {
+		amount = round(amount)
+		if amount == 0 {
+			return
+		}
+		b.Items = append(b.Items, models.FareLineItem{Code: code, Label: label, Amount: amount})
+		b.Total = round(b.Total + amount)
+	}
+
+	add("base", "Base fare", tariff.BaseFare)
+	add("distance", fmt.Sprintf("%.2f km × %.2f", in.Distance, tariff.PerKm), in.Distance*tariff.PerKm)
+	add("time", fmt.Sprintf("%d min × %.2f", in.Duration, tariff.PerMinute), float64(in.Duration)*tariff.PerMinute)
+
+	if m := activeMultiplier(tariff.Multipliers, in.At); m != nil && m.Multiplier != 1 {
+		add("time_of_day", fmt.Sprintf("%s ×%.2f", m.Name, m.Multiplier), b.Total*(m.Multiplier-1))
+	}
+
+	if in.Surge > 1 {
+		b.SurgeMultiplier = in.Surge
+		add("surge", fmt.Sprintf("Surge ×%.1f", in.Surge), b.Total*(in.Surge-1))
+	}
+
+	add("booking_fee", "Booking fee", tariff.BookingFee)
+
+	if b.Total < tariff.MinimumFare {
+		add("minimum_fare", "Minimum fare adjustment", tariff.MinimumFare-b.Total)
+	}
+
+	return b
+}
+
+// activeMultiplier returns the largest multiplier whose window covers at.
+func activeMultiplier(multipliers []models.TariffMultiplier, at time.Time) *models.TariffMultiplier {
+	hour := at.Hour()
+
+	var best *models.TariffMultiplier
+	for i := range multipliers {
+		m := &multipliers[i]
+		in := hour >= m.StartHour && hour < m.EndHour
+		if m.StartHour > m.EndHour {
+			in = hour >= m.StartHour || hour < m.EndHour
+		}
+		if in && (best == nil || m.Multiplier > best.Multiplier) {
+			best = m
+		}
+	}
+	return best
+}
+
+func round(v float64) float64 {
+	return math.Round(v*100) / 100
+}
+
+// AddItem appends a charge to an existing breakdown and updates its total.
+func AddItem(b *models.FareBreakdown, code, label string, amount float64) {
+	amount = round(amount)
+	if amount == 0 {
+		return
+	}
+	b.Items = append(b.Items, models.FareLineItem{Code: code, Label: label, Amount: amount})
+	b.Total = round(b.Total + amount)
+}
+
+// WaitingCharge bills every started minute a driver waited at the pickup
+// beyond the grace period.
+func WaitingCharge(waited, grace time.Duration, perMinute float64) (minutes int, amount float64) {
+	if waited <= grace {
+		return 0, 0
+	}
+	minutes = int(math.Ceil((waited - grace).Minutes()))
+	return minutes, round(float64(minutes) * perMinute)
+}
+
+// Reprice prices a trip again against the tariff and surge of an earlier
+// breakdown, so a ride is billed on actuals at the rates it was booked at.
+// If that tariff no longer exists the current one is used.
+func (e *Engine) Reprice(booked *models.FareBreakdown, in Input) (*models.FareBreakdown, error) {
+	if booked == nil {
+		return e.Quote(in)
+	}
+	in.Surge = booked.SurgeMultiplier
+
+	var tariff models.Tariff
+	err := e.db.Preload("Multipliers").First(&tariff, "id = ?", booked.TariffID).Error
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return e.Quote(in)
+	}
+	if err != nil {
+		return nil, err
+	}
+	if in.At.IsZero() {
+		in.At = time.Now()
+	}
+	in.At = in.At.In(e.loc)
+	return Calculate(&tariff, in), nil
+}
@@ -0,0 +1,159 @@
+package fare
+
+import (
+	"testing"
+	"time"
+
+	"rickshaw-app/internal/models"
+)
+
+func TestCalculate(t *testing.T) {
+	dhaka := time.FixedZone("Asia/Dhaka", 6*60*60)
+	tariff := &models.Tariff{
+		ID:          "standard",
+		BaseFare:    20,
+		PerKm:       10,
+		PerMinute:   1,
+		MinimumFare: 60,
+		BookingFee:  5,
+		Multipliers: []models.TariffMultiplier{
+			{Name: "Night", StartHour: 22, EndHour: 6, Multiplier: 1.5},
+			{Name: "Rush hour", StartHour: 8, EndHour: 10, Multiplier: 1.2},
+			{Name: "Late rush", StartHour: 9, EndHour: 11, Multiplier: 1.3},
+		},
+	}
+	noon := time.Date(2026, 3, 1, 12, 0, 0, 0, dhaka)
+
+	tests := []struct {
+		name      string
+		in        Input
+		wantTotal float64
+		wantItems []string
+	}{
+		{
+			name:      "metered fare plus booking fee",
+			in:        Input{Distance: 5, Duration: 10, At: noon},
+			wantTotal: 20 + 50 + 10 + 5,
+			wantItems: []string{"base", "distance", "time", "booking_fee"},
+		},
+		{
+			name:      "short trip topped up to the minimum",
+			in:        Input{Distance: 1, Duration: 2, At: noon},
+			wantTotal: 60,
+			wantItems: []string{"base", "distance", "time", "booking_fee", "minimum_fare"},
+		},
+		{
+			name:      "night window before midnight",
+			in:        Input{Distance: 5, Duration: 10, At: time.Date(2026, 3, 1, 23, 0, 0, 0, dhaka)},
+			wantTotal: 80*1.5 + 5,
+			wantItems: []string{"base", "distance", "time", "time_of_day", "booking_fee"},
+		},
+		{
+			name:      "night window after midnight",
+			in:        Input{Distance: 5, Duration: 10, At: time.Date(2026, 3, 1, 5, 59, 0, 0, dhaka)},
+			wantTotal: 80*1.5 + 5,
+			wantItems: []string{"base", "distance", "time", "time_of_day", "booking_fee"},
+		},
+		{
+			name:      "night window ends on the hour",
+			in:        Input{Distance: 5, Duration: 10, At: time.Date(2026, 3, 1, 6, 0, 0, 0, dhaka)},
+			wantTotal: 85,
+			wantItems: []string{"base", "distance", "time", "booking_fee"},
+		},
+		{
+			name:      "overlapping windows take the largest",
+			in:        Input{Distance: 5, Duration: 10, At: time.Date(2026, 3, 1, 9, 30, 0, 0, dhaka)},
+			wantTotal: 80*1.3 + 5,
+			wantItems: []string{"base", "distance", "time", "time_of_day", "booking_fee"},
+		},
+		{
+			// 17:00 UTC is 23:00 in Dhaka.
+			name:      "window matched in the time's own zone",
+			in:        Input{Distance: 5, Duration: 10, At: time.Date(2026, 3, 1, 17, 0, 0, 0, time.UTC).In(dhaka)},
+			wantTotal: 80*1.5 + 5,
+			wantItems: []string{"base", "distance", "time", "time_of_day", "booking_fee"},
+		},
+		{
+			name:      "surge after time of day, not on the booking fee",
+			in:        Input{Distance: 5, Duration: 10, At: time.Date(2026, 3, 1, 23, 0, 0, 0, dhaka), Surge: 2},
+			wantTotal: 80*1.5*2 + 5,
+			wantItems: []string{"base", "distance", "time", "time_of_day", "surge", "booking_fee"},
+		},
+		{
+			name:      "surge of one is no surge",
+			in:        Input{Distance: 5, Duration: 10, At: noon, Surge: 1},
+			wantTotal: 85,
+			wantItems: []string{"base", "distance", "time", "booking_fee"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			b := Calculate(tariff, tt.in)
+			if b.Total != tt.wantTotal {
+				t.Errorf("total = %v, want %v", b.Total, tt.wantTotal)
+			}
+			var codes []string
+			var sum float64
+			for _, item := range b.Items {
+				codes = append(codes, item.Code)
+				sum += item.Amount
+			}
+			if len(codes) != len(tt.wantItems) {
+				t.Fatalf("items = %v, want %v", codes, tt.wantItems)
+			}
+			for i := range codes {
+				if codes[i] != tt.wantItems[i] {
+					t.Fatalf("items = %v, want %v", codes, tt.wantItems)
+				}
+			}
+			if round(sum) != b.Total {
+				t.Errorf("items sum to %v, total is %v", sum, b.Total)
+			}
+		})
+	}
+}
+
+func TestSelectTariff(t *testing.T) {
+	jan := time.Date(2026, 1, 1, 0, 0, 0, 0, time.UTC)
+	feb := time.Date(2026, 2, 1, 0, 0, 0, 0, time.UTC)
+	mar := time.Date(2026, 3, 1, 0, 0, 0, 0, time.UTC)
+	apr := time.Date(2026, 4, 1, 0, 0, 0, 0, time.UTC)
+
+	tariffs := []models.Tariff{
+		{ID: "std-jan", VehicleClass: "standard", EffectiveFrom: jan},
+		{ID: "std-feb", VehicleClass: "standard", EffectiveFrom: feb},
+		{ID: "std-apr", VehicleClass: "standard", EffectiveFrom: apr},
+		{ID: "std-gulshan-jan", VehicleClass: "standard", Zone: "gulshan", EffectiveFrom: jan},
+		{ID: "std-airport-apr", VehicleClass: "standard", Zone: "airport", EffectiveFrom: apr},
+		{ID: "xl-jan", VehicleClass: "xl", EffectiveFrom: jan},
+	}
+
+	tests := []struct {
+		name string
+		in   Input
+		want string
+	}{
+		{"latest catch-all in force", Input{VehicleClass: "standard", At: mar}, "std-feb"},
+		{"newer tariff once in force", Input{VehicleClass: "standard", At: apr}, "std-apr"},
+		{"zone tariff over a newer catch-all", Input{VehicleClass: "standard", Zone: "gulshan", At: apr}, "std-gulshan-jan"},
+		{"zone tariff not yet in force", Input{VehicleClass: "standard", Zone: "airport", At: mar}, "std-feb"},
+		{"zone tariff once in force", Input{VehicleClass: "standard", Zone: "airport", At: apr}, "std-airport-apr"},
+		{"other zone's tariff ignored", Input{VehicleClass: "standard", Zone: "banani", At: mar}, "std-feb"},
+		{"vehicle class", Input{VehicleClass: "xl", Zone: "gulshan", At: mar}, "xl-jan"},
+		{"nothing in force yet", Input{VehicleClass: "standard", At: jan.Add(-time.Second)}, ""},
+		{"unknown vehicle class", Input{VehicleClass: "bike", At: mar}, ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := selectTariff(tariffs, tt.in)
+			var id string
+			if got != nil {
+				id = got.ID
+			}
+			if id != tt.want {
+				t.Errorf("selected %q, want %q", id, tt.want)
+			}
+		})
+	}
+}
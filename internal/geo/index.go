@@ -0,0 +1,157 @@
+package geo
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"strings"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+)
+
+// DriversKey is the Redis GEO set holding the live position of every
+// driver that is currently available for rides.
+const DriversKey = "drivers:locations"
+
+type Index struct {
+	rdb *redis.Client
+}
+
+func NewIndex(rdb *redis.Client) *Index {
+	return &Index{rdb: rdb}
+}
+
+type NearbyDriver struct {
+	DriverID string
+	Lat      float64
+	Lng      float64
+	Distance float64 // in km
+}
+
+func (i *Index) Add(ctx context.Context, driverID string, lat, lng float64) error {
+	return i.rdb.GeoAdd(ctx, DriversKey, &redis.GeoLocation{
+		Name:      driverID,
+		Longitude: lng,
+		Latitude:  lat,
+	}).Err()
+}
+
+func (i *Index) Remove(ctx context.Context, driverID string) error {
+	return i.rdb.ZRem(ctx, DriversKey, driverID).Err()
+}
+
+// Search returns up to limit drivers within radiusKm of the given point.
+// sort is "asc" or "desc" by distance; anything else leaves Redis order.
+func (i *Index) Search(ctx context.Context, lat, lng, radiusKm float64, limit int, sort string) ([]NearbyDriver, error) {
+	locations, err := i.rdb.GeoSearchLocation(ctx, DriversKey, &redis.GeoSearchLocationQuery{
+		GeoSearchQuery: redis.GeoSearchQuery{
+			Longitude:  lng,
+			Latitude:   lat,
+			Radius:     radiusKm,
+			RadiusUnit: "km",
+			Sort:       strings.ToUpper(sort),
+			Count:      limit,
+		},
+		WithCoord: true,
+		WithDist:  true,
+	}).Result()
+	if err != nil {
+		return nil, err
+	}
+
+	nearby := make([]NearbyDriver, 0, len(locations))
+	for _, loc := range locations {
+		nearby = append(nearby, NearbyDriver{
+			DriverID: loc.Name,
+			Lat:      loc.Latitude,
+			Lng:      loc.Longitude,
+			Distance: loc.Dist,
+		})
+	}
+	return nearby, nil
+}
+
+// All returns the position of every driver in the index.
+func (i *Index) All(ctx context.Context) ([]NearbyDriver, error) {
+	ids, err := i.rdb.ZRange(ctx, DriversKey, 0, -1).Result()
+	if err != nil || len(ids) == 0 {
+		return nil, err
+	}
+
+	positions, err := i.rdb.GeoPos(ctx, DriversKey, ids...).Result()
+	if err != nil {
+		return nil, err
+	}
+
+	drivers := make([]NearbyDriver, 0, len(ids))
+	for n, pos := range positions {
+		if pos == nil {
+			continue
+		}
+		drivers = append(drivers, NearbyDriver{DriverID: ids[n], Lat: pos.Latitude, Lng: pos.Longitude})
+	}
+	return drivers, nil
+}
+
+// livePositionTTL bounds how long a driver's last reported position is
+// trusted over the one persisted in Postgres.
+const livePositionTTL = time.Hour
+
+// Position is a driver's last reported location, kept in Redis so hot
+// readers never wait on the throttled Postgres write.
+type Position struct {
+	Lat float64   `json:"lat"`
+	Lng float64   `json:"lng"`
+	At  time.Time `json:"at"`
+}
+
+// positionKey holds a hash of the position's JSON and its time in unix
+// microseconds, which setPositionScript compares.
+func positionKey(driverID string) string {
+	return "driver:" + driverID + ":live"
+}
+
+// setPositionScript writes a position only if it is newer than the stored
+// one, in one step so concurrent updates can't overwrite a newer position
+// with an older one.
+var setPositionScript = redis.NewScript(`
+local current = redis.call('HGET', KEYS[1], 'at')
+if current and tonumber(current) >= tonumber(ARGV[1]) then
+	return 0
+end
+redis.call('HSET', KEYS[1], 'at', ARGV[1], 'position', ARGV[2])
+redis.call('PEXPIRE', KEYS[1], ARGV[3])
+return 1
+`)
+
+// SetPosition stores p as the driver's live position unless a newer one is
+// already stored, and reports whether it did.
+func (i *Index) SetPosition(ctx context.Context, driverID string, p Position) (bool, error) {
+	payload, err := json.Marshal(p)
+	if err != nil {
+		return false, err
+	}
+	stored, err := setPositionScript.Run(ctx, i.rdb, []string{positionKey(driverID)},
+		p.At.UnixMicro(), payload, livePositionTTL.Milliseconds()).Int()
+	if err != nil {
+		return false, err
+	}
+	return stored == 1, nil
+}
+
+// Position returns the driver's live position, or nil if none is known.
+func (i *Index) Position(ctx context.Context, driverID string) (*Position, error) {
+	raw, err := i.rdb.HGet(ctx, positionKey(driverID), "position").Bytes()
+	if errors.Is(err, redis.Nil) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	var p Position
+	if err := json.Unmarshal(raw, &p); err != nil {
+		return nil, nil
+	}
+	return &p, nil
+}
@@ -1,12 +1,26 @@
 package handlers
 
 import (
+	"context"
 	"encoding/json"
+	"errors"
 	"net/http"
+	"strconv"
+	"strings"
+	"time"
 
+	"rickshaw-app/internal/analytics"
+	"rickshaw-app/internal/audit"
+	"rickshaw-app/internal/config"
+	"rickshaw-app/internal/earnings"
+	"rickshaw-app/internal/fare"
+	"rickshaw-app/internal/geo"
 	"rickshaw-app/internal/models"
+	"rickshaw-app/internal/surge"
+	"rickshaw-app/internal/zones"
 
 	"github.com/go-chi/chi/v5"
+	"github.com/redis/go-redis/v9"
 	"gorm.io/gorm"
 )
 
@@ -23,25 +37,71 @@ func adminBasicAuth(next http.Handler) http.Handler {
 	})
 }
 
+// adminActor names the admin making the request in the audit log.
+func adminActor(r *http.Request) string {
+	username, _, _ := r.BasicAuth()
+	return username
+}
+
 type AdminHandler struct {
-	db *gorm.DB
+	db        *gorm.DB
+	surge     *surge.Engine
+	earnings  *earnings.Service
+	locations *geo.Index
+	analytics *analytics.Service
+	loc       *time.Location
 }
 
-func NewAdminHandler(db *gorm.DB) *AdminHandler {
-	return &AdminHandler{db: db}
+func NewAdminHandler(db *gorm.DB, rdb *redis.Client, cfg *config.Config) *AdminHandler {
+	return &AdminHandler{
+		db:        db,
+		surge:     surge.NewEngine(db, rdb, cfg),
+		earnings:  earnings.NewService(db, cfg),
+		locations: geo.NewIndex(rdb),
+		analytics: analytics.NewService(db, cfg),
+		loc:       cfg.Timezone,
+	}
 }
 
-// RegisterAdminRoutes wires the admin endpoints under /admin.
-func RegisterAdminRoutes(r chi.Router, handler *AdminHandler) {
+// RegisterAdminRoutes wires the admin endpoints under /admin. Ride
+// interventions are served by the ride handler, which owns the lifecycle.
+func RegisterAdminRoutes(r chi.Router, handler *AdminHandler, rides *RideHandler) {
 	r.Group(func(r chi.Router) {
 		r.Use(adminBasicAuth)
+		r.Use(audit.Middleware(handler.db, adminActor))
 
 		r.Get("/", handler.AdminPage)
 		r.Get("/api/rides", handler.ListRides)
+		r.Post("/api/rides/{id}/reassign", rides.AdminReassignRide)
+		r.Post("/api/rides/{id}/cancel", rides.AdminCancelRide)
+		r.Post("/api/rides/{id}/complete", rides.AdminCompleteRide)
+		r.Post("/api/rides/{id}/fare", rides.AdminAdjustFare)
 		r.Get("/api/drivers", handler.ListDrivers)
+		r.Get("/api/drivers/flagged", handler.ListFlaggedDrivers)
+		r.Put("/api/drivers/{id}", handler.UpdateDriver)
+		r.Post("/api/drivers/{id}/suspend", handler.SuspendDriver)
+		r.Post("/api/drivers/{id}/ban", handler.BanDriver)
+		r.Post("/api/drivers/{id}/reactivate", handler.ReactivateDriver)
+		r.Post("/api/drivers/{id}/offline", handler.ForceDriverOffline)
 		r.Get("/api/ratings", handler.ListRatings)
 		r.Get("/api/users", handler.ListUsers)
 		r.Get("/api/ride-history", handler.ListRideHistory)
+		r.Get("/api/tariffs", handler.ListTariffs)
+		r.Post("/api/tariffs", handler.CreateTariff)
+		r.Get("/api/zones", handler.ListZones)
+		r.Post("/api/zones", handler.CreateZone)
+		r.Put("/api/zones/{id}", handler.UpdateZone)
+		r.Get("/api/surge", handler.GetSurge)
+		r.Put("/api/surge", handler.SetSurgeEnabled)
+		r.Put("/api/surge/cells/{cell}", handler.OverrideSurge)
+		r.Delete("/api/surge/cells/{cell}", handler.ClearSurgeOverride)
+		r.Get("/api/payouts", handler.ListPayouts)
+		r.Post("/api/payouts", handler.CreatePayouts)
+		r.Post("/api/payouts/{id}/paid", handler.MarkPayoutPaid)
+		r.Get("/api/payouts/{id}/statement", handler.PayoutStatement)
+		r.Get("/api/audit", handler.ListAuditLog)
+		r.Get("/api/analytics/summary", handler.AnalyticsSummary)
+		r.Get("/api/analytics/series", handler.AnalyticsSeries)
 	})
 }
 
@@ -212,6 +272,7 @@ func (h *AdminHandler) AdminPage(w http.ResponseWriter, r *http.Request) {
     
     .status-requested { background: #fef3c7; color: #92400e; }
     .status-accepted { background: #dbeafe; color: #1e40af; }
+    .status-arrived { background: #e0e7ff; color: #3730a3; }
     .status-started { background: #d1fae5; color: #065f46; }
     .status-completed { background: #dcfce7; color: #166534; }
     .status-cancelled { background: #fee2e2; color: #991b1b; }
@@ -237,6 +298,56 @@ func (h *AdminHandler) AdminPage(w http.ResponseWriter, r *http.Request) {
       opacity: 0.5;
     }
     
+    .filters {
+      display: flex;
+      gap: 8px;
+      flex-wrap: wrap;
+      margin-bottom: 16px;
+    }
+    
+    .filters input, .filters select, .filters button {
+      padding: 8px 12px;
+      border: 1px solid #e5e7eb;
+      border-radius: 6px;
+      font-size: 14px;
+    }
+    
+    .filters button {
+      background: #667eea;
+      color: white;
+      border: none;
+      cursor: pointer;
+    }
+    
+    .row-action {
+      padding: 4px 8px;
+      margin: 2px 0;
+      border: 1px solid #e5e7eb;
+      border-radius: 4px;
+      background: white;
+      cursor: pointer;
+      font-size: 12px;
+      white-space: nowrap;
+    }
+    
+    .load-more {
+      display: block;
+      margin: 16px auto 0;
+      padding: 8px 24px;
+      background: white;
+      color: #667eea;
+      border: 1px solid #667eea;
+      border-radius: 6px;
+      cursor: pointer;
+      font-size: 14px;
+    }
+    
+    .changes {
+      font-family: monospace;
+      font-size: 12px;
+      white-space: pre-wrap;
+    }
+    
     .stats-grid {
       display: grid;
       grid-template-columns: repeat(auto-fit, minmax(200px, 1fr));
@@ -289,31 +400,201 @@ func (h *AdminHandler) AdminPage(w http.ResponseWriter, r *http.Request) {
       <button class="tab" data-tab="users">Users</button>
       <button class="tab" data-tab="ratings">Ratings</button>
       <button class="tab" data-tab="history">Ride History</button>
+      <button class="tab" data-tab="audit">Audit Log</button>
+      <button class="tab" data-tab="analytics">Analytics</button>
     </div>
     
     <div class="content-section active" id="rides-section">
       <h2>Recent Rides</h2>
+      <form class="filters" id="rides-filters">
+        <input name="q" placeholder="Rider name/phone, vehicle" />
+        <select name="status">
+          <option value="">Any status</option>
+          <option>requested</option>
+          <option>accepted</option>
+          <option>arrived</option>
+          <option>started</option>
+          <option>completed</option>
+          <option>cancelled</option>
+        </select>
+        <input name="rider_id" placeholder="Rider ID" />
+        <input name="driver_id" placeholder="Driver ID" />
+        <input name="zone" placeholder="Zone" />
+        <input name="from" type="date" title="From" />
+        <input name="to" type="date" title="To" />
+        <select name="sort">
+          <option value="created_at">Newest</option>
+          <option value="fare">Fare</option>
+          <option value="distance">Distance</option>
+        </select>
+        <select name="order">
+          <option value="desc">Desc</option>
+          <option value="asc">Asc</option>
+        </select>
+        <button type="submit">Search</button>
+      </form>
       <div id="rides-content" class="loading">Loading...</div>
+      <div id="rides-more"></div>
     </div>
     
     <div class="content-section" id="drivers-section">
       <h2>Active Drivers</h2>
+      <form class="filters" id="drivers-filters">
+        <input name="q" placeholder="Name, phone, vehicle" />
+        <select name="available">
+          <option value="">Any availability</option>
+          <option value="true">Available</option>
+          <option value="false">Unavailable</option>
+        </select>
+        <select name="status">
+          <option value="">Any status</option>
+          <option>active</option>
+          <option>suspended</option>
+          <option>banned</option>
+        </select>
+        <select name="flagged">
+          <option value="">Any flag status</option>
+          <option value="true">Flagged</option>
+        </select>
+        <input name="min_rating" type="number" step="0.1" min="1" max="5" placeholder="Min rating" />
+        <input name="max_rating" type="number" step="0.1" min="1" max="5" placeholder="Max rating" />
+        <select name="sort">
+          <option value="created_at">Newest</option>
+          <option value="rating">Rating</option>
+          <option value="total_rides">Total rides</option>
+          <option value="cancellation_rate">Cancel rate</option>
+        </select>
+        <select name="order">
+          <option value="desc">Desc</option>
+          <option value="asc">Asc</option>
+        </select>
+        <button type="submit">Search</button>
+      </form>
       <div id="drivers-content" class="loading">Loading...</div>
+      <div id="drivers-more"></div>
     </div>
     
     <div class="content-section" id="users-section">
       <h2>Registered Users</h2>
+      <form class="filters" id="users-filters">
+        <input name="q" placeholder="Name or phone" />
+        <select name="user_type">
+          <option value="">Any type</option>
+          <option>rider</option>
+          <option>driver</option>
+        </select>
+        <input name="from" type="date" title="Joined from" />
+        <input name="to" type="date" title="Joined to" />
+        <select name="sort">
+          <option value="created_at">Newest</option>
+          <option value="name">Name</option>
+        </select>
+        <select name="order">
+          <option value="desc">Desc</option>
+          <option value="asc">Asc</option>
+        </select>
+        <button type="submit">Search</button>
+      </form>
       <div id="users-content" class="loading">Loading...</div>
+      <div id="users-more"></div>
     </div>
     
     <div class="content-section" id="ratings-section">
       <h2>Recent Ratings</h2>
+      <form class="filters" id="ratings-filters">
+        <input name="driver_id" placeholder="Driver ID" />
+        <input name="rider_id" placeholder="Rider ID" />
+        <input name="min_rating" type="number" min="1" max="5" placeholder="Min rating" />
+        <input name="max_rating" type="number" min="1" max="5" placeholder="Max rating" />
+        <input name="from" type="date" title="From" />
+        <input name="to" type="date" title="To" />
+        <select name="sort">
+          <option value="created_at">Newest</option>
+          <option value="rating">Rating</option>
+        </select>
+        <select name="order">
+          <option value="desc">Desc</option>
+          <option value="asc">Asc</option>
+        </select>
+        <button type="submit">Search</button>
+      </form>
       <div id="ratings-content" class="loading">Loading...</div>
+      <div id="ratings-more"></div>
     </div>
     
     <div class="content-section" id="history-section">
       <h2>Ride Status History</h2>
+      <form class="filters" id="history-filters">
+        <input name="ride_id" placeholder="Ride ID" />
+        <select name="status">
+          <option value="">Any status</option>
+          <option>requested</option>
+          <option>accepted</option>
+          <option>arrived</option>
+          <option>started</option>
+          <option>completed</option>
+          <option>cancelled</option>
+        </select>
+        <select name="event">
+          <option value="">Any event</option>
+          <option>request</option>
+          <option>accept</option>
+          <option>arrive</option>
+          <option>start</option>
+          <option>complete</option>
+          <option>cancel</option>
+          <option>reassign</option>
+          <option>adjust_fare</option>
+          <option>offer</option>
+          <option>decline_offer</option>
+          <option>expire_offer</option>
+          <option>unassign</option>
+        </select>
+        <select name="actor">
+          <option value="">Any actor</option>
+          <option>rider</option>
+          <option>driver</option>
+          <option>admin</option>
+          <option>system</option>
+        </select>
+        <input name="from" type="date" title="From" />
+        <input name="to" type="date" title="To" />
+        <select name="order">
+          <option value="desc">Newest first</option>
+          <option value="asc">Oldest first</option>
+        </select>
+        <button type="submit">Search</button>
+      </form>
       <div id="history-content" class="loading">Loading...</div>
+      <div id="history-more"></div>
+    </div>
+    
+    <div class="content-section" id="audit-section">
+      <h2>Audit Log</h2>
+      <form class="filters" id="audit-filters">
+        <input name="actor" placeholder="Actor" />
+        <input name="entity_type" placeholder="Entity type" />
+        <input name="entity_id" placeholder="Entity ID" />
+        <input name="from" type="date" title="From" />
+        <input name="to" type="date" title="To" />
+        <button type="submit">Search</button>
+      </form>
+      <div id="audit-content" class="loading">Loading...</div>
+    </div>
+    
+    <div class="content-section" id="analytics-section">
+      <h2>Analytics</h2>
+      <form class="filters" id="analytics-filters">
+        <input name="from" type="date" title="From" />
+        <input name="to" type="date" title="To" />
+        <input name="zone" placeholder="Zone" />
+        <select name="interval">
+          <option value="day">Per day</option>
+          <option value="hour">Per hour</option>
+        </select>
+        <button type="submit">Apply</button>
+      </form>
+      <div id="analytics-content" class="loading">Loading...</div>
     </div>
   </div>
   
@@ -323,7 +604,19 @@ func (h *AdminHandler) AdminPage(w http.ResponseWriter, r *http.Request) {
       drivers: [],
       users: [],
       ratings: [],
-      history: []
+      history: [],
+      audit: [],
+      analytics: []
+    };
+    
+    // Paged lists: the endpoint each tab reads and the cursor of its next
+    // page, if any.
+    const lists = {
+      rides: { url: '/admin/api/rides', next: '', pages: 0, render: () => renderRides() },
+      drivers: { url: '/admin/api/drivers', next: '', pages: 0, render: () => renderDrivers() },
+      users: { url: '/admin/api/users', next: '', pages: 0, render: () => renderUsers() },
+      ratings: { url: '/admin/api/ratings', next: '', pages: 0, render: () => renderRatings() },
+      history: { url: '/admin/api/ride-history', next: '', pages: 0, render: () => renderHistory() }
     };
     
     // Tab switching
@@ -337,63 +630,161 @@ func (h *AdminHandler) AdminPage(w http.ResponseWriter, r *http.Request) {
       });
     });
     
-    // Fetch data
+    function filterParams(name) {
+      const params = new URLSearchParams();
+      new FormData(document.getElementById(name + '-filters')).forEach((v, k) => {
+        if (v) params.set(k, v);
+      });
+      return params;
+    }
+    
+    // loadList fetches the first page of a list, or the next one if more
+    // is true, and re-renders it.
+    async function loadList(name, more = false) {
+      const list = lists[name];
+      const params = filterParams(name);
+      if (more) params.set('cursor', list.next);
+      
+      const res = await fetch(list.url + '?' + params);
+      if (!res.ok) throw new Error(await res.text());
+      const page = await res.json();
+      
+      state[name] = more ? state[name].concat(page.items) : page.items;
+      list.next = page.next_cursor || '';
+      list.pages = more ? list.pages + 1 : 1;
+      list.render();
+      
+      document.getElementById(name + '-more').innerHTML = list.next ?
+        ` + "`<button class=\"load-more\" onclick=\"loadMore('${name}')\">Load more</button>`" + ` : '';
+    }
+    
+    function loadMore(name) {
+      loadList(name, true).catch(err => showListError(name, err));
+    }
+    
+    function showListError(name, err) {
+      console.error('Failed to load ' + name + ':', err);
+      document.getElementById(name + '-content').innerHTML = '<div class="error">' + escapeHTML(err.message) + '</div>';
+    }
+    
+    Object.keys(lists).forEach(name => {
+      document.getElementById(name + '-filters').addEventListener('submit', e => {
+        e.preventDefault();
+        loadList(name).catch(err => showListError(name, err));
+      });
+    });
+    
+    // Fetch data. Lists the admin has paged further into are left alone
+    // so a refresh doesn't throw their place away.
     async function fetchData() {
+      await Promise.all(Object.keys(lists)
+        .filter(name => lists[name].pages <= 1)
+        .map(name => loadList(name).catch(err => showListError(name, err)))
+        .concat(fetchAnalytics()));
+    }
+    
+    // fetchAnalytics loads the summary cards and the per-period table for
+    // the range and zone in the analytics filters, the last 7 days by
+    // default.
+    async function fetchAnalytics() {
+      const params = filterParams('analytics');
+      const get = async url => {
+        const res = await fetch(url + '?' + params);
+        if (!res.ok) throw new Error(await res.text());
+        return res.json();
+      };
       try {
-        const [rides, drivers, users, ratings, history] = await Promise.all([
-          fetch('/admin/api/rides').then(r => r.json()),
-          fetch('/admin/api/drivers').then(r => r.json()),
-          fetch('/admin/api/users').then(r => r.json()),
-          fetch('/admin/api/ratings').then(r => r.json()),
-          fetch('/admin/api/ride-history').then(r => r.json())
+        const [summary, series] = await Promise.all([
+          get('/admin/api/analytics/summary'),
+          get('/admin/api/analytics/series')
         ]);
-        
-        state.rides = rides;
-        state.drivers = drivers;
-        state.users = users;
-        state.ratings = ratings;
-        state.history = history;
-        
-        renderStats();
-        renderRides();
-        renderDrivers();
-        renderUsers();
-        renderRatings();
-        renderHistory();
+        renderStats(summary);
+        state.analytics = series;
+        renderAnalytics();
       } catch (err) {
-        console.error('Failed to fetch data:', err);
-        document.querySelectorAll('[id$="-content"]').forEach(el => {
-          el.innerHTML = '<div class="error">Failed to load data. Please refresh the page.</div>';
-        });
+        showListError('analytics', err);
       }
     }
     
-    function renderStats() {
-      const totalRides = state.rides.length;
-      const activeDrivers = state.drivers.filter(d => d.is_available).length;
-      const completedRides = state.rides.filter(r => r.status === 'completed').length;
-      const avgRating = state.ratings.length ? 
-        (state.ratings.reduce((sum, r) => sum + r.rating, 0) / state.ratings.length).toFixed(1) : 'N/A';
-      
-      document.getElementById('stats').innerHTML = ` + "`" + `
-        <div class="stat-card">
-          <div class="stat-label">Total Rides</div>
-          <div class="stat-value">${totalRides}</div>
-        </div>
-        <div class="stat-card">
-          <div class="stat-label">Active Drivers</div>
-          <div class="stat-value">${activeDrivers}</div>
-        </div>
-        <div class="stat-card">
-          <div class="stat-label">Completed Rides</div>
-          <div class="stat-value">${completedRides}</div>
-        </div>
+    function formatWait(seconds) {
+      if (!seconds) return '-';
+      const m = Math.floor(seconds / 60);
+      const s = Math.round(seconds % 60);
+      return m ? ` + "`${m}m ${s}s`" + ` : ` + "`${s}s`" + `;
+    }
+    
+    function formatMoney(minor, currency) {
+      return (minor / 100).toFixed(2) + ' ' + currency;
+    }
+    
+    function formatPercent(rate) {
+      return (rate * 100).toFixed(0) + '%';
+    }
+    
+    function renderStats(s) {
+      const cards = [
+        ['Rides', s.rides],
+        ['Completion Rate', formatPercent(s.completion_rate)],
+        ['Cancellation Rate', formatPercent(s.cancellation_rate)],
+        ['Avg Wait to Accept', formatWait(s.avg_wait_seconds)],
+        ['Avg Fare', s.avg_fare.toFixed(2)],
+        ['Avg Distance', s.avg_distance.toFixed(1) + ' km'],
+        ['Active Drivers', s.active_drivers],
+        ['Revenue', formatMoney(s.revenue.gross, s.revenue.currency)],
+        ['Commission', formatMoney(s.revenue.commission, s.revenue.currency)]
+      ];
+      document.getElementById('stats').innerHTML = cards.map(([label, value]) => ` + "`" + `
         <div class="stat-card">
-          <div class="stat-label">Avg Rating</div>
-          <div class="stat-value">${avgRating} ★</div>
+          <div class="stat-label">${label}</div>
+          <div class="stat-value">${value}</div>
         </div>
-      ` + "`" + `;
+      ` + "`" + `).join('');
+    }
+    
+    function renderAnalytics() {
+      const hourly = new FormData(document.getElementById('analytics-filters')).get('interval') === 'hour';
+      const rows = state.analytics.map(b => ({
+        period_start: b.period_start,
+        total: b.total,
+        completed: b.rides.completed || 0,
+        cancelled: b.rides.cancelled || 0,
+        active_drivers: b.active_drivers,
+        avg_wait_seconds: b.avg_wait_seconds,
+        gross: b.revenue.gross,
+        commission: b.revenue.commission,
+        currency: b.revenue.currency
+      }));
+      renderTable('analytics-content', [
+        { key: 'period_start', label: hourly ? 'Hour' : 'Day' },
+        { key: 'total', label: 'Rides' },
+        { key: 'completed', label: 'Completed' },
+        { key: 'cancelled', label: 'Cancelled' },
+        { key: 'active_drivers', label: 'Active Drivers' },
+        { key: 'avg_wait_seconds', label: 'Avg Wait' },
+        { key: 'gross', label: 'Revenue' },
+        { key: 'commission', label: 'Commission' }
+      ], rows, {
+        // Days start at midnight in the operating timezone, which is the
+        // date period_start is written with.
+        period_start: v => hourly ? formatDate(v) : new Date(v.slice(0, 10)).toLocaleDateString('en-US', { timeZone: 'UTC', month: 'short', day: 'numeric' }),
+        avg_wait_seconds: formatWait,
+        gross: (v, row) => formatMoney(v, row.currency),
+        commission: (v, row) => formatMoney(v, row.currency)
+      });
+    }
+    
+    document.getElementById('analytics-filters').addEventListener('submit', e => {
+      e.preventDefault();
+      fetchAnalytics();
+    });
+    
+    // html marks markup a formatter built itself, with any data in it
+    // already escaped. renderTable escapes every other cell.
+    class HTML {
+      constructor(markup) { this.markup = markup; }
+      toString() { return this.markup; }
     }
+    const html = markup => new HTML(markup);
     
     function renderTable(containerId, columns, data, formatters = {}) {
       const container = document.getElementById(containerId);
@@ -415,7 +806,8 @@ func (h *AdminHandler) AdminPage(w http.ResponseWriter, r *http.Request) {
         const cells = columns.map(c => {
           let value = row[c.key];
           if (formatters[c.key]) value = formatters[c.key](value, row);
-          return ` + "`<td>${value ?? ''}</td>`" + `;
+          const cell = value instanceof HTML ? value : escapeHTML(value ?? '');
+          return ` + "`<td>${cell}</td>`" + `;
         }).join('');
         return ` + "`<tr>${cells}</tr>`" + `;
       }).join('');
@@ -431,15 +823,16 @@ func (h *AdminHandler) AdminPage(w http.ResponseWriter, r *http.Request) {
     }
     
     function formatStatus(status) {
-      return ` + "`<span class=\"badge status-${status}\">${status}</span>`" + `;
+      status = escapeHTML(status);
+      return html(` + "`<span class=\"badge status-${status}\">${status}</span>`" + `);
     }
     
     function formatBool(val) {
-      return ` + "`<span class=\"bool-${val}\">${val ? '✓ Yes' : '✗ No'}</span>`" + `;
+      return html(` + "`<span class=\"bool-${!!val}\">${val ? '✓ Yes' : '✗ No'}</span>`" + `);
     }
     
     function formatRating(val) {
-      return ` + "`<span class=\"rating-stars\">${val} ★</span>`" + `;
+      return html(` + "`<span class=\"rating-stars\">${escapeHTML(val)} ★</span>`" + `);
     }
     
     function formatDate(val) {
@@ -462,7 +855,8 @@ func (h *AdminHandler) AdminPage(w http.ResponseWriter, r *http.Request) {
         { key: 'status', label: 'Status' },
         { key: 'fare', label: 'Fare' },
         { key: 'distance', label: 'Distance' },
-        { key: 'created_at', label: 'Created' }
+        { key: 'created_at', label: 'Created' },
+        { key: 'actions', label: 'Actions' }
       ], state.rides, {
         id: v => truncate(v, 8),
         rider_id: v => truncate(v, 8),
@@ -470,10 +864,60 @@ func (h *AdminHandler) AdminPage(w http.ResponseWriter, r *http.Request) {
         status: formatStatus,
         fare: v => v ? ` + "`${v.toFixed(2)}`" + ` : '-',
         distance: v => v ? ` + "`${v.toFixed(1)} km`" + ` : '-',
-        created_at: formatDate
+        created_at: formatDate,
+        actions: (_, ride) => rideActions(ride)
       });
     }
     
+    function rideActions(ride) {
+      const button = (action, label) =>
+        '<button class="row-action" onclick="rideAction(\'' + escapeHTML(ride.id) + '\', \'' + action + '\')">' + label + '</button>';
+      const buttons = [];
+      if (ride.status === 'accepted' || ride.status === 'arrived') buttons.push(button('reassign', 'Reassign'));
+      if (ride.status === 'started') buttons.push(button('complete', 'Complete'));
+      if (ride.status !== 'completed' && ride.status !== 'cancelled') buttons.push(button('cancel', 'Cancel'));
+      if (ride.status === 'completed') buttons.push(button('fare', 'Adjust fare'));
+      return html(buttons.join(' '));
+    }
+    
+    async function rideAction(id, action) {
+      const ride = state.rides.find(r => r.id === id);
+      const body = {};
+      
+      if (action === 'reassign') {
+        body.driver_id = prompt('ID of the driver to reassign this ride to');
+        if (!body.driver_id) return;
+        body.reason = prompt('Reason for reassigning');
+        if (!body.reason) return;
+      } else if (action === 'complete') {
+        body.reason = prompt('Reason for completing this ride on the driver\'s behalf');
+        if (!body.reason) return;
+      } else if (action === 'cancel') {
+        body.reason = prompt('Cancellation reason (driver_breakdown, safety_concern, duplicate_ride or other)', 'other');
+        if (!body.reason) return;
+        body.note = prompt('Note');
+        if (!body.note) return;
+      } else if (action === 'fare') {
+        const value = prompt('New fare', ride.fare.toFixed(2));
+        if (value === null) return;
+        body.fare = parseFloat(value);
+        body.note = prompt('Note explaining the adjustment');
+        if (!body.note) return;
+      }
+      
+      const res = await fetch('/admin/api/rides/' + id + '/' + action, {
+        method: 'POST',
+        headers: { 'Content-Type': 'application/json' },
+        body: JSON.stringify(body)
+      });
+      if (!res.ok) {
+        alert(await res.text());
+        return;
+      }
+      loadList('rides').catch(err => showListError('rides', err));
+      fetchAudit();
+    }
+    
     function renderDrivers() {
       renderTable('drivers-content', [
         { key: 'id', label: 'ID' },
@@ -481,13 +925,86 @@ func (h *AdminHandler) AdminPage(w http.ResponseWriter, r *http.Request) {
         { key: 'vehicle_number', label: 'Vehicle' },
         { key: 'is_available', label: 'Available' },
         { key: 'rating', label: 'Rating' },
-        { key: 'total_rides', label: 'Total Rides' }
+        { key: 'total_rides', label: 'Total Rides' },
+        { key: 'cancellation_rate', label: 'Cancel Rate' },
+        { key: 'cancellation_flagged', label: 'Flagged' },
+        { key: 'status', label: 'Status' },
+        { key: 'actions', label: 'Actions' }
       ], state.drivers, {
         id: v => truncate(v, 8),
         user_id: v => truncate(v, 8),
         is_available: formatBool,
-        rating: formatRating
+        rating: formatRating,
+        cancellation_rate: v => ` + "`${(v * 100).toFixed(0)}%`" + `,
+        cancellation_flagged: v => v ? html('<span class="badge status-cancelled">flagged</span>') : '',
+        status: formatDriverStatus,
+        actions: (_, d) => driverActions(d)
+      });
+    }
+    
+    function formatDriverStatus(status, d) {
+      if (status === 'active') return html('<span class="badge status-completed">active</span>');
+      let title = d.status_reason || '';
+      if (d.suspended_until) title += ' (until ' + formatDate(d.suspended_until) + ')';
+      return html('<span class="badge status-cancelled" title="' + escapeHTML(title) + '">' + escapeHTML(status) + '</span>');
+    }
+    
+    function driverActions(d) {
+      const button = (action, label) =>
+        '<button class="row-action" onclick="driverAction(\'' + escapeHTML(d.id) + '\', \'' + action + '\')">' + label + '</button>';
+      const buttons = [button('edit', 'Edit')];
+      if (d.is_available) buttons.push(button('offline', 'Force offline'));
+      if (d.status === 'active') {
+        buttons.push(button('suspend', 'Suspend'), button('ban', 'Ban'));
+      } else {
+        buttons.push(button('reactivate', 'Reactivate'));
+        if (d.status !== 'banned') buttons.push(button('ban', 'Ban'));
+      }
+      return html(buttons.join(' '));
+    }
+    
+    async function driverAction(id, action) {
+      const driver = state.drivers.find(d => d.id === id);
+      let method = 'POST';
+      let url = '/admin/api/drivers/' + id + '/' + action;
+      const body = {};
+      
+      if (action === 'edit') {
+        method = 'PUT';
+        url = '/admin/api/drivers/' + id;
+        for (const [key, label] of [['vehicle_number', 'Vehicle number'], ['vehicle_model', 'Vehicle model'], ['license_number', 'Licence number']]) {
+          const value = prompt(label, driver[key] || '');
+          if (value === null) return;
+          body[key] = value;
+        }
+      } else if (action === 'suspend' || action === 'ban') {
+        const reason = prompt('Reason for ' + (action === 'ban' ? 'banning' : 'suspending') + ' this driver');
+        if (!reason) return;
+        body.reason = reason;
+        if (action === 'suspend') {
+          const until = prompt('Suspended until (YYYY-MM-DD), or leave blank until reactivated');
+          if (until === null) return;
+          if (until) body.until = new Date(until + 'T00:00:00').toISOString();
+        }
+      } else if (!confirm(action === 'offline' ? 'Force this driver offline?' : 'Reactivate this driver?')) {
+        return;
+      }
+      
+      const res = await fetch(url, {
+        method,
+        headers: { 'Content-Type': 'application/json' },
+        body: JSON.stringify(body)
       });
+      if (!res.ok) {
+        alert(await res.text());
+        return;
+      }
+      loadList('drivers').catch(err => showListError('drivers', err));
+      fetchAudit();
+    }
+    
+    function escapeHTML(str) {
+      return String(str).replace(/[&<>"']/g, c => ({ '&': '&amp;', '<': '&lt;', '>': '&gt;', '"': '&quot;', "'": '&#39;' })[c]);
     }
     
     function renderUsers() {
@@ -499,7 +1016,7 @@ func (h *AdminHandler) AdminPage(w http.ResponseWriter, r *http.Request) {
         { key: 'created_at', label: 'Joined' }
       ], state.users, {
         id: v => truncate(v, 8),
-        user_type: v => ` + "`<span class=\"badge\">${v}</span>`" + `,
+        user_type: v => html(` + "`<span class=\"badge\">${escapeHTML(v)}</span>`" + `),
         created_at: formatDate
       });
     }
@@ -527,6 +1044,9 @@ func (h *AdminHandler) AdminPage(w http.ResponseWriter, r *http.Request) {
         { key: 'id', label: 'ID' },
         { key: 'ride_id', label: 'Ride' },
         { key: 'status', label: 'Status' },
+        { key: 'event', label: 'Event' },
+        { key: 'actor', label: 'Actor' },
+        { key: 'reason', label: 'Reason' },
         { key: 'note', label: 'Note' },
         { key: 'created_at', label: 'Timestamp' }
       ], state.history, {
@@ -538,8 +1058,54 @@ func (h *AdminHandler) AdminPage(w http.ResponseWriter, r *http.Request) {
       });
     }
     
+    async function fetchAudit() {
+      const params = new URLSearchParams();
+      new FormData(document.getElementById('audit-filters')).forEach((v, k) => {
+        if (v) params.set(k, v);
+      });
+      try {
+        state.audit = await fetch('/admin/api/audit?' + params).then(r => r.json());
+        renderAudit();
+      } catch (err) {
+        console.error('Failed to fetch audit log:', err);
+        document.getElementById('audit-content').innerHTML = '<div class="error">Failed to load the audit log.</div>';
+      }
+    }
+    
+    function formatChanges(changes) {
+      if (!changes) return '';
+      return html('<span class="changes">' + escapeHTML(Object.entries(changes)
+        .map(([k, c]) => ` + "`${k}: ${JSON.stringify(c.from)} → ${JSON.stringify(c.to)}`" + `)
+        .join('\n')) + '</span>');
+    }
+    
+    function renderAudit() {
+      renderTable('audit-content', [
+        { key: 'created_at', label: 'Time' },
+        { key: 'actor', label: 'Actor' },
+        { key: 'action', label: 'Action' },
+        { key: 'entity_type', label: 'Entity' },
+        { key: 'entity_id', label: 'Entity ID' },
+        { key: 'changes', label: 'Changes' },
+        { key: 'status', label: 'Status' },
+        { key: 'ip', label: 'IP' },
+        { key: 'request_id', label: 'Request ID' }
+      ], state.audit, {
+        created_at: formatDate,
+        entity_id: v => truncate(v, 12),
+        changes: formatChanges,
+        request_id: v => truncate(v, 20)
+      });
+    }
+    
+    document.getElementById('audit-filters').addEventListener('submit', e => {
+      e.preventDefault();
+      fetchAudit();
+    });
+    
     // Initialize
     fetchData();
+    fetchAudit();
     
     // Auto-refresh every 30 seconds
     setInterval(fetchData, 30000);
@@ -550,49 +1116,661 @@ func (h *AdminHandler) AdminPage(w http.ResponseWriter, r *http.Request) {
 	w.Write([]byte(page))
 }
 
-func (h *AdminHandler) ListRides(w http.ResponseWriter, r *http.Request) {
-	var rides []models.Ride
-	if err := h.db.Order("created_at DESC").Find(&rides).Error; err != nil {
-		http.Error(w, "failed to fetch rides", http.StatusInternalServerError)
-		return
+// Sort keys each admin list accepts, mapped to the SQL they order by.
+// Nullable columns are coalesced so the keyset comparison stays total.
+var (
+	rideSorts = map[string]string{
+		"created_at": "created_at",
+		"fare":       "COALESCE(fare, 0)",
+		"distance":   "COALESCE(distance, 0)",
+	}
+	driverSorts = map[string]string{
+		"created_at":        "created_at",
+		"rating":            "COALESCE(rating, 0)",
+		"total_rides":       "COALESCE(total_rides, 0)",
+		"cancellation_rate": "cancellation_rate",
+	}
+	userSorts = map[string]string{
+		"created_at": "created_at",
+		"name":       "name",
 	}
-	writeJSON(w, rides)
+	ratingSorts = map[string]string{
+		"created_at": "created_at",
+		"rating":     "rating",
+	}
+	historySorts = map[string]string{
+		"created_at": "created_at",
+	}
+)
+
+// ListRides pages through rides. Filters: status (comma-separated),
+// driver_id, rider_id, zone, from, to and q, which matches the rider's name
+// or phone and the driver's vehicle number.
+func (h *AdminHandler) ListRides(w http.ResponseWriter, r *http.Request) {
+	f := newListFilter(w, r, h.db.Model(&models.Ride{}))
+	f.in("status", "status")
+	f.equal("driver_id", "driver_id")
+	f.equal("rider_id", "rider_id")
+	f.equal("zone", "zone")
+	f.dateRange("created_at", h.loc)
+	f.search(
+		"(SELECT name FROM users WHERE users.id = rides.rider_id)",
+		"(SELECT phone FROM users WHERE users.id = rides.rider_id)",
+		"(SELECT vehicle_number FROM drivers WHERE drivers.id = rides.driver_id)",
+	)
+	listPage[models.Ride](w, r, f, rideSorts, "rides")
 }
 
+// ListDrivers pages through drivers. Filters: status, available, flagged,
+// min_rating, max_rating, from, to and q, which matches the vehicle number
+// and the driver's name or phone.
 func (h *AdminHandler) ListDrivers(w http.ResponseWriter, r *http.Request) {
+	f := newListFilter(w, r, h.db.Model(&models.Driver{}))
+	f.in("status", "status")
+	f.boolean("available", "is_available")
+	f.boolean("flagged", "cancellation_flagged")
+	f.numberRange("min_rating", "max_rating", "rating")
+	f.dateRange("created_at", h.loc)
+	f.search(
+		"vehicle_number",
+		"(SELECT name FROM users WHERE users.id = drivers.user_id)",
+		"(SELECT phone FROM users WHERE users.id = drivers.user_id)",
+	)
+	listPage[models.Driver](w, r, f, driverSorts, "drivers")
+}
+
+// ListFlaggedDrivers returns drivers whose recent cancellation rate is
+// above the policy threshold, worst first.
+func (h *AdminHandler) ListFlaggedDrivers(w http.ResponseWriter, r *http.Request) {
 	var drivers []models.Driver
-	if err := h.db.Order("created_at DESC").Find(&drivers).Error; err != nil {
+	if err := h.db.Where("cancellation_flagged = ?", true).Order("cancellation_rate DESC").Find(&drivers).Error; err != nil {
 		http.Error(w, "failed to fetch drivers", http.StatusInternalServerError)
 		return
 	}
 	writeJSON(w, drivers)
 }
 
-func (h *AdminHandler) ListRatings(w http.ResponseWriter, r *http.Request) {
-	var ratings []models.Rating
-	if err := h.db.Order("created_at DESC").Find(&ratings).Error; err != nil {
-		http.Error(w, "failed to fetch ratings", http.StatusInternalServerError)
+type driverUpdateRequest struct {
+	VehicleNumber *string `json:"vehicle_number"`
+	VehicleModel  *string `json:"vehicle_model"`
+	LicenseNumber *string `json:"license_number"`
+}
+
+// UpdateDriver corrects a driver's vehicle and licence details.
+func (h *AdminHandler) UpdateDriver(w http.ResponseWriter, r *http.Request) {
+	db := audit.DB(r.Context(), h.db)
+	var driver models.Driver
+	if err := db.First(&driver, "id = ?", chi.URLParam(r, "id")).Error; err != nil {
+		http.Error(w, "driver not found", http.StatusNotFound)
+		return
+	}
+	before := audit.Snapshot(driver)
+
+	var req driverUpdateRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "invalid request", http.StatusBadRequest)
+		return
+	}
+
+	updates := map[string]interface{}{}
+	for column, v := range map[string]*string{
+		"vehicle_number": req.VehicleNumber,
+		"license_number": req.LicenseNumber,
+	} {
+		if v == nil {
+			continue
+		}
+		if strings.TrimSpace(*v) == "" {
+			http.Error(w, column+" must not be empty", http.StatusBadRequest)
+			return
+		}
+		updates[column] = strings.TrimSpace(*v)
+	}
+	if req.VehicleModel != nil {
+		updates["vehicle_model"] = strings.TrimSpace(*req.VehicleModel)
+	}
+	if len(updates) == 0 {
+		http.Error(w, "nothing to update", http.StatusBadRequest)
 		return
 	}
-	writeJSON(w, ratings)
+
+	if err := db.Model(&driver).Updates(updates).Error; err != nil {
+		http.Error(w, "failed to update driver", http.StatusInternalServerError)
+		return
+	}
+
+	db.First(&driver, "id = ?", driver.ID)
+	audit.Record(r.Context(), "driver.update", "driver", driver.ID, before, driver)
+	writeJSON(w, driver)
 }
 
-func (h *AdminHandler) ListUsers(w http.ResponseWriter, r *http.Request) {
-	var users []models.User
-	if err := h.db.Order("created_at DESC").Find(&users).Error; err != nil {
-		http.Error(w, "failed to fetch users", http.StatusInternalServerError)
+type driverStatusRequest struct {
+	Reason string     `json:"reason"`
+	Until  *time.Time `json:"until"`
+}
+
+// SuspendDriver takes a driver offline and keeps them off until the
+// optional end date or until reactivated. A ride already in progress is
+// left to finish.
+func (h *AdminHandler) SuspendDriver(w http.ResponseWriter, r *http.Request) {
+	var req driverStatusRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil || strings.TrimSpace(req.Reason) == "" {
+		http.Error(w, "reason is required", http.StatusBadRequest)
+		return
+	}
+	if req.Until != nil && !req.Until.After(time.Now()) {
+		http.Error(w, "until must be in the future", http.StatusBadRequest)
+		return
+	}
+	h.setDriverStatus(w, r, "driver.suspend", map[string]interface{}{
+		"status":          models.DriverSuspended,
+		"status_reason":   strings.TrimSpace(req.Reason),
+		"suspended_until": req.Until,
+		"is_available":    false,
+	})
+}
+
+// BanDriver takes a driver offline permanently.
+func (h *AdminHandler) BanDriver(w http.ResponseWriter, r *http.Request) {
+	var req driverStatusRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil || strings.TrimSpace(req.Reason) == "" {
+		http.Error(w, "reason is required", http.StatusBadRequest)
+		return
+	}
+	h.setDriverStatus(w, r, "driver.ban", map[string]interface{}{
+		"status":          models.DriverBanned,
+		"status_reason":   strings.TrimSpace(req.Reason),
+		"suspended_until": nil,
+		"is_available":    false,
+	})
+}
+
+// ReactivateDriver lifts a suspension or ban. The driver goes back online
+// themselves.
+func (h *AdminHandler) ReactivateDriver(w http.ResponseWriter, r *http.Request) {
+	h.setDriverStatus(w, r, "driver.reactivate", map[string]interface{}{
+		"status":          models.DriverActive,
+		"status_reason":   "",
+		"suspended_until": nil,
+	})
+}
+
+// ForceDriverOffline marks a driver unavailable without suspending them,
+// e.g. when their app has stopped reporting.
+func (h *AdminHandler) ForceDriverOffline(w http.ResponseWriter, r *http.Request) {
+	h.setDriverStatus(w, r, "driver.force_offline", map[string]interface{}{
+		"is_available": false,
+	})
+}
+
+func (h *AdminHandler) setDriverStatus(w http.ResponseWriter, r *http.Request, action string, updates map[string]interface{}) {
+	db := audit.DB(r.Context(), h.db)
+	var driver models.Driver
+	if err := db.First(&driver, "id = ?", chi.URLParam(r, "id")).Error; err != nil {
+		http.Error(w, "driver not found", http.StatusNotFound)
+		return
+	}
+	before := audit.Snapshot(driver)
+
+	if err := db.Model(&driver).Updates(updates).Error; err != nil {
+		http.Error(w, "failed to update driver", http.StatusInternalServerError)
 		return
 	}
-	writeJSON(w, users)
+	if available, ok := updates["is_available"].(bool); ok && !available {
+		audit.AfterCommit(r.Context(), func() { h.locations.Remove(context.Background(), driver.ID) })
+	}
+
+	db.First(&driver, "id = ?", driver.ID)
+	audit.Record(r.Context(), action, "driver", driver.ID, before, driver)
+	writeJSON(w, driver)
+}
+
+// ListRatings pages through ratings. Filters: driver_id, rider_id,
+// min_rating, max_rating, from and to.
+func (h *AdminHandler) ListRatings(w http.ResponseWriter, r *http.Request) {
+	f := newListFilter(w, r, h.db.Model(&models.Rating{}))
+	f.equal("driver_id", "driver_id")
+	f.equal("rider_id", "rider_id")
+	f.numberRange("min_rating", "max_rating", "rating")
+	f.dateRange("created_at", h.loc)
+	listPage[models.Rating](w, r, f, ratingSorts, "ratings")
+}
+
+// ListUsers pages through users. Filters: user_type, from, to and q, which
+// matches name or phone.
+func (h *AdminHandler) ListUsers(w http.ResponseWriter, r *http.Request) {
+	f := newListFilter(w, r, h.db.Model(&models.User{}))
+	f.equal("user_type", "user_type")
+	f.dateRange("created_at", h.loc)
+	f.search("name", "phone")
+	listPage[models.User](w, r, f, userSorts, "users")
 }
 
+// ListRideHistory pages through ride status changes and dispatch events.
+// Filters: ride_id, status, event, actor, from and to.
 func (h *AdminHandler) ListRideHistory(w http.ResponseWriter, r *http.Request) {
-	var history []models.RideHistory
-	if err := h.db.Order("created_at DESC").Find(&history).Error; err != nil {
-		http.Error(w, "failed to fetch ride history", http.StatusInternalServerError)
+	f := newListFilter(w, r, h.db.Model(&models.RideHistory{}))
+	f.equal("ride_id", "ride_id")
+	f.in("status", "status")
+	f.in("event", "event")
+	f.equal("actor", "actor")
+	f.dateRange("created_at", h.loc)
+	listPage[models.RideHistory](w, r, f, historySorts, "ride history")
+}
+
+func (h *AdminHandler) ListTariffs(w http.ResponseWriter, r *http.Request) {
+	var tariffs []models.Tariff
+	if err := h.db.Preload("Multipliers").Order("vehicle_class, zone, effective_from DESC").Find(&tariffs).Error; err != nil {
+		http.Error(w, "failed to fetch tariffs", http.StatusInternalServerError)
 		return
 	}
-	writeJSON(w, history)
+	writeJSON(w, tariffs)
+}
+
+// CreateTariff adds a new tariff version. Tariffs are never edited in place
+// so rides keep pointing at the rates they were priced with.
+func (h *AdminHandler) CreateTariff(w http.ResponseWriter, r *http.Request) {
+	db := audit.DB(r.Context(), h.db)
+	var tariff models.Tariff
+	if err := json.NewDecoder(r.Body).Decode(&tariff); err != nil {
+		http.Error(w, "invalid request", http.StatusBadRequest)
+		return
+	}
+
+	if tariff.Name == "" || tariff.BaseFare < 0 || tariff.PerKm < 0 || tariff.PerMinute < 0 ||
+		tariff.MinimumFare < 0 || tariff.BookingFee < 0 {
+		http.Error(w, "name is required and rates must not be negative", http.StatusBadRequest)
+		return
+	}
+	for _, m := range tariff.Multipliers {
+		if m.StartHour < 0 || m.StartHour > 23 || m.EndHour < 0 || m.EndHour > 24 || m.Multiplier <= 0 {
+			http.Error(w, "invalid multiplier window", http.StatusBadRequest)
+			return
+		}
+	}
+
+	tariff.ID = ""
+	if tariff.VehicleClass == "" {
+		tariff.VehicleClass = fare.DefaultVehicleClass
+	}
+	if tariff.EffectiveFrom.IsZero() {
+		tariff.EffectiveFrom = time.Now()
+	}
+	for i := range tariff.Multipliers {
+		tariff.Multipliers[i].ID = ""
+		tariff.Multipliers[i].TariffID = ""
+	}
+
+	if err := db.Create(&tariff).Error; err != nil {
+		http.Error(w, "failed to create tariff", http.StatusInternalServerError)
+		return
+	}
+	audit.Record(r.Context(), "tariff.create", "tariff", tariff.ID, nil, tariff)
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusCreated)
+	writeJSON(w, tariff)
+}
+
+func (h *AdminHandler) ListZones(w http.ResponseWriter, r *http.Request) {
+	var zoneList []models.ServiceZone
+	if err := h.db.Order("key").Find(&zoneList).Error; err != nil {
+		http.Error(w, "failed to fetch zones", http.StatusInternalServerError)
+		return
+	}
+	writeJSON(w, zoneList)
+}
+
+type zoneRequest struct {
+	Key      string           `json:"key"`
+	Name     string           `json:"name"`
+	Geometry *models.Geometry `json:"geometry"`
+	Enabled  *bool            `json:"enabled"`
+}
+
+func (h *AdminHandler) CreateZone(w http.ResponseWriter, r *http.Request) {
+	db := audit.DB(r.Context(), h.db)
+	var req zoneRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil || req.Key == "" || req.Name == "" || req.Geometry == nil {
+		http.Error(w, "key, name and geometry are required", http.StatusBadRequest)
+		return
+	}
+	if err := zones.Validate(*req.Geometry); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	zone := models.ServiceZone{Key: req.Key, Name: req.Name, Geometry: *req.Geometry, Enabled: true}
+	if req.Enabled != nil {
+		zone.Enabled = *req.Enabled
+	}
+
+	if err := db.Create(&zone).Error; err != nil {
+		http.Error(w, "zone key already exists", http.StatusConflict)
+		return
+	}
+	audit.Record(r.Context(), "zone.create", "zone", zone.ID, nil, zone)
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusCreated)
+	writeJSON(w, zone)
+}
+
+// UpdateZone changes a zone's name, boundary or enabled flag. The key is
+// immutable because tariffs and rides refer to it.
+func (h *AdminHandler) UpdateZone(w http.ResponseWriter, r *http.Request) {
+	db := audit.DB(r.Context(), h.db)
+	var zone models.ServiceZone
+	if err := db.First(&zone, "id = ?", chi.URLParam(r, "id")).Error; err != nil {
+		http.Error(w, "zone not found", http.StatusNotFound)
+		return
+	}
+	before := audit.Snapshot(zone)
+
+	var req zoneRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "invalid request", http.StatusBadRequest)
+		return
+	}
+
+	updates := map[string]interface{}{}
+	if req.Name != "" {
+		updates["name"] = req.Name
+	}
+	if req.Geometry != nil {
+		if err := zones.Validate(*req.Geometry); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		updates["geometry"] = *req.Geometry
+	}
+	if req.Enabled != nil {
+		updates["enabled"] = *req.Enabled
+	}
+
+	if err := db.Model(&zone).Updates(updates).Error; err != nil {
+		http.Error(w, "failed to update zone", http.StatusInternalServerError)
+		return
+	}
+
+	db.First(&zone, "id = ?", zone.ID)
+	audit.Record(r.Context(), "zone.update", "zone", zone.ID, before, zone)
+	writeJSON(w, zone)
+}
+
+func (h *AdminHandler) GetSurge(w http.ResponseWriter, r *http.Request) {
+	enabled, err := h.surge.Enabled(r.Context())
+	if err != nil {
+		http.Error(w, "failed to fetch surge", http.StatusInternalServerError)
+		return
+	}
+
+	cells, err := h.surge.Cells(r.Context())
+	if err != nil {
+		http.Error(w, "failed to fetch surge", http.StatusInternalServerError)
+		return
+	}
+
+	writeJSON(w, map[string]any{"enabled": enabled, "cells": cells})
+}
+
+func (h *AdminHandler) SetSurgeEnabled(w http.ResponseWriter, r *http.Request) {
+	var req struct {
+		Enabled bool `json:"enabled"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "invalid request", http.StatusBadRequest)
+		return
+	}
+
+	wasEnabled, err := h.surge.Enabled(r.Context())
+	if err != nil {
+		http.Error(w, "failed to update surge", http.StatusInternalServerError)
+		return
+	}
+	if err := h.surge.SetEnabled(r.Context(), req.Enabled); err != nil {
+		http.Error(w, "failed to update surge", http.StatusInternalServerError)
+		return
+	}
+	audit.Record(r.Context(), "surge.set_enabled", "surge", "",
+		map[string]bool{"enabled": wasEnabled}, map[string]bool{"enabled": req.Enabled})
+
+	writeJSON(w, map[string]bool{"enabled": req.Enabled})
+}
+
+func (h *AdminHandler) OverrideSurge(w http.ResponseWriter, r *http.Request) {
+	cellID := chi.URLParam(r, "cell")
+
+	var req struct {
+		Multiplier float64 `json:"multiplier"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil || req.Multiplier < 1 {
+		http.Error(w, "multiplier must be at least 1", http.StatusBadRequest)
+		return
+	}
+
+	if err := h.surge.SetOverride(r.Context(), cellID, req.Multiplier); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	audit.Record(r.Context(), "surge.override", "surge_cell", cellID, nil, map[string]float64{"override": req.Multiplier})
+
+	writeJSON(w, map[string]any{"id": cellID, "override": req.Multiplier})
+}
+
+func (h *AdminHandler) ClearSurgeOverride(w http.ResponseWriter, r *http.Request) {
+	cellID := chi.URLParam(r, "cell")
+	if err := h.surge.ClearOverride(r.Context(), cellID); err != nil {
+		http.Error(w, "failed to clear override", http.StatusInternalServerError)
+		return
+	}
+	audit.Record(r.Context(), "surge.clear_override", "surge_cell", cellID, nil, nil)
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func (h *AdminHandler) ListPayouts(w http.ResponseWriter, r *http.Request) {
+	query := h.db.Order("created_at DESC")
+	if driverID := r.URL.Query().Get("driver_id"); driverID != "" {
+		query = query.Where("driver_id = ?", driverID)
+	}
+	if status := r.URL.Query().Get("status"); status != "" {
+		query = query.Where("status = ?", status)
+	}
+
+	var payouts []models.Payout
+	if err := query.Find(&payouts).Error; err != nil {
+		http.Error(w, "failed to fetch payouts", http.StatusInternalServerError)
+		return
+	}
+	writeJSON(w, payouts)
+}
+
+// CreatePayouts runs a payout batch for every driver with unpaid earnings
+// between from and to (inclusive dates, YYYY-MM-DD).
+func (h *AdminHandler) CreatePayouts(w http.ResponseWriter, r *http.Request) {
+	var req struct {
+		From string `json:"from"`
+		To   string `json:"to"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "invalid request", http.StatusBadRequest)
+		return
+	}
+	from, err := time.Parse("2006-01-02", req.From)
+	if err != nil {
+		http.Error(w, "from must be YYYY-MM-DD", http.StatusBadRequest)
+		return
+	}
+	to, err := time.Parse("2006-01-02", req.To)
+	if err != nil || to.Before(from) {
+		http.Error(w, "to must be YYYY-MM-DD and not before from", http.StatusBadRequest)
+		return
+	}
+
+	payouts, err := h.earnings.CreatePayouts(audit.DB(r.Context(), h.db), from, to.AddDate(0, 0, 1))
+	if err != nil {
+		http.Error(w, "failed to create payouts", http.StatusInternalServerError)
+		return
+	}
+	audit.Record(r.Context(), "payout.create_batch", "payout", "", nil, payouts)
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusCreated)
+	writeJSON(w, payouts)
+}
+
+func (h *AdminHandler) MarkPayoutPaid(w http.ResponseWriter, r *http.Request) {
+	db := audit.DB(r.Context(), h.db)
+	var before models.Payout
+	if err := db.First(&before, "id = ?", chi.URLParam(r, "id")).Error; err != nil {
+		http.Error(w, "payout not found", http.StatusNotFound)
+		return
+	}
+
+	payout, err := h.earnings.MarkPaid(db, before.ID)
+	if errors.Is(err, earnings.ErrNotPending) {
+		http.Error(w, "payout already paid", http.StatusConflict)
+		return
+	}
+	if err != nil {
+		http.Error(w, "failed to mark payout paid", http.StatusInternalServerError)
+		return
+	}
+	audit.Record(r.Context(), "payout.mark_paid", "payout", payout.ID, before, payout)
+	writeJSON(w, payout)
+}
+
+func (h *AdminHandler) PayoutStatement(w http.ResponseWriter, r *http.Request) {
+	var payout models.Payout
+	if err := h.db.First(&payout, "id = ?", chi.URLParam(r, "id")).Error; err != nil {
+		http.Error(w, "payout not found", http.StatusNotFound)
+		return
+	}
+	writeStatement(w, h.earnings, &payout)
+}
+
+// ListAuditLog searches the audit log by actor, action, entity and time
+// range. from and to are RFC 3339 timestamps or inclusive YYYY-MM-DD dates
+// in the operating timezone.
+func (h *AdminHandler) ListAuditLog(w http.ResponseWriter, r *http.Request) {
+	q := r.URL.Query()
+	filter := audit.Filter{
+		Actor:      q.Get("actor"),
+		Action:     q.Get("action"),
+		EntityType: q.Get("entity_type"),
+		EntityID:   q.Get("entity_id"),
+	}
+
+	var ok bool
+	if filter.From, ok = parseAdminTime(q.Get("from"), false, h.loc); !ok {
+		http.Error(w, "from must be an RFC 3339 time or YYYY-MM-DD", http.StatusBadRequest)
+		return
+	}
+	if filter.To, ok = parseAdminTime(q.Get("to"), true, h.loc); !ok {
+		http.Error(w, "to must be an RFC 3339 time or YYYY-MM-DD", http.StatusBadRequest)
+		return
+	}
+	if v := q.Get("limit"); v != "" {
+		limit, err := strconv.Atoi(v)
+		if err != nil || limit <= 0 || limit > audit.MaxLimit {
+			http.Error(w, "limit must be between 1 and 500", http.StatusBadRequest)
+			return
+		}
+		filter.Limit = limit
+	}
+
+	logs, err := audit.Search(h.db, filter)
+	if err != nil {
+		http.Error(w, "failed to fetch audit log", http.StatusInternalServerError)
+		return
+	}
+	writeJSON(w, logs)
+}
+
+// AnalyticsSummary returns ride, driver and revenue metrics over a date
+// range. Filters: from, to (default the last 7 days) and zone.
+func (h *AdminHandler) AnalyticsSummary(w http.ResponseWriter, r *http.Request) {
+	filter, ok := h.analyticsFilter(w, r)
+	if !ok {
+		return
+	}
+	summary, err := h.analytics.Summary(filter)
+	if errors.Is(err, analytics.ErrInvalidRange) {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if err != nil {
+		http.Error(w, "failed to compute analytics", http.StatusInternalServerError)
+		return
+	}
+	writeJSON(w, summary)
+}
+
+// AnalyticsSeries returns the same metrics per hour or day (?interval=,
+// default day), with the filters of AnalyticsSummary.
+func (h *AdminHandler) AnalyticsSeries(w http.ResponseWriter, r *http.Request) {
+	filter, ok := h.analyticsFilter(w, r)
+	if !ok {
+		return
+	}
+	interval := r.URL.Query().Get("interval")
+	if interval == "" {
+		interval = analytics.Day
+	}
+
+	buckets, err := h.analytics.Series(filter, interval)
+	if errors.Is(err, analytics.ErrInvalidInterval) || errors.Is(err, analytics.ErrInvalidRange) ||
+		errors.Is(err, analytics.ErrRangeTooLarge) {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if err != nil {
+		http.Error(w, "failed to compute analytics", http.StatusInternalServerError)
+		return
+	}
+	writeJSON(w, buckets)
+}
+
+func (h *AdminHandler) analyticsFilter(w http.ResponseWriter, r *http.Request) (analytics.Filter, bool) {
+	q := r.URL.Query()
+	filter := analytics.Filter{Zone: q.Get("zone")}
+
+	var ok bool
+	if filter.From, ok = parseAdminTime(q.Get("from"), false, h.loc); !ok {
+		http.Error(w, "from must be an RFC 3339 time or YYYY-MM-DD", http.StatusBadRequest)
+		return filter, false
+	}
+	if filter.To, ok = parseAdminTime(q.Get("to"), true, h.loc); !ok {
+		http.Error(w, "to must be an RFC 3339 time or YYYY-MM-DD", http.StatusBadRequest)
+		return filter, false
+	}
+	if filter.To.IsZero() {
+		filter.To = time.Now()
+	}
+	if filter.From.IsZero() {
+		filter.From = filter.To.AddDate(0, 0, -7)
+	}
+	return filter, true
+}
+
+// parseAdminTime parses an RFC 3339 time or a date in loc. A date used as
+// the end of a range covers the whole day. The result is in UTC, like the
+// timestamps it is compared with; an empty value is the zero time.
+func parseAdminTime(v string, end bool, loc *time.Location) (time.Time, bool) {
+	if v == "" {
+		return time.Time{}, true
+	}
+	if t, err := time.Parse(time.RFC3339, v); err == nil {
+		return t.UTC(), true
+	}
+	t, err := time.ParseInLocation("2006-01-02", v, loc)
+	if err != nil {
+		return time.Time{}, false
+	}
+	if end {
+		t = t.AddDate(0, 0, 1)
+	}
+	return t.UTC(), true
 }
 
 func writeJSON(w http.ResponseWriter, v any) {
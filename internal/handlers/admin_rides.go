@@ -0,0 +1,460 @@
+package handlers
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"strings"
+	"time"
+
+	"rickshaw-app/internal/audit"
+	"rickshaw-app/internal/cancellation"
+	"rickshaw-app/internal/earnings"
+	"rickshaw-app/internal/fare"
+	"rickshaw-app/internal/models"
+	"rickshaw-app/internal/payments"
+	"rickshaw-app/internal/ridestate"
+
+	"github.com/go-chi/chi/v5"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// Admin ride interventions. They go through the ride state machine as the
+// admin actor, so the ride history, driver availability and payments are
+// kept consistent the same way as when riders and drivers act.
+
+type adminReassignRequest struct {
+	DriverID string `json:"driver_id"`
+	Reason   string `json:"reason"`
+}
+
+type adminCancelRequest struct {
+	Reason string `json:"reason"`
+	Note   string `json:"note"`
+}
+
+type adminCompleteRequest struct {
+	Reason string `json:"reason"`
+}
+
+type adminFareRequest struct {
+	Fare *float64 `json:"fare"`
+	Note string   `json:"note"`
+}
+
+// AdminReassignRide hands an accepted ride to another available driver,
+// who must drive to the pickup afresh. The original driver goes back
+// online unless they have since been suspended or banned.
+func (h *RideHandler) AdminReassignRide(w http.ResponseWriter, r *http.Request) {
+	var req adminReassignRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil || req.DriverID == "" {
+		http.Error(w, "driver_id is required", http.StatusBadRequest)
+		return
+	}
+	req.Reason = strings.TrimSpace(req.Reason)
+	if req.Reason == "" {
+		http.Error(w, "reason is required", http.StatusBadRequest)
+		return
+	}
+
+	db := audit.DB(r.Context(), h.db)
+	var ride models.Ride
+	if err := db.First(&ride, "id = ?", chi.URLParam(r, "id")).Error; err != nil {
+		http.Error(w, "ride not found", http.StatusNotFound)
+		return
+	}
+	before := audit.Snapshot(ride)
+
+	var next models.Driver
+	if err := db.First(&next, "id = ?", req.DriverID).Error; err != nil {
+		http.Error(w, "driver not found", http.StatusNotFound)
+		return
+	}
+	if next.Blocked(time.Now()) {
+		http.Error(w, "driver is "+next.Status, http.StatusConflict)
+		return
+	}
+	if ride.DriverID != nil && *ride.DriverID == next.ID {
+		http.Error(w, "ride is already assigned to this driver", http.StatusConflict)
+		return
+	}
+
+	var previous *models.Driver
+	if ride.DriverID != nil {
+		var d models.Driver
+		if err := db.First(&d, "id = ?", *ride.DriverID).Error; err == nil {
+			previous = &d
+		}
+	}
+
+	withLivePosition(r.Context(), h.locations, &next)
+	now := time.Now()
+
+	actorID := adminActor(r)
+	var released bool
+	err := db.Transaction(func(tx *gorm.DB) error {
+		// Reassigning leaves the ride accepted, so lock it rather than rely
+		// on the status check in Fire to serialise admins.
+		if err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).First(&ride, "id = ?", ride.ID).Error; err != nil {
+			return err
+		}
+
+		// Claim the new driver; this fails if they went offline or took
+		// another ride since we looked.
+		res := tx.Model(&models.Driver{}).
+			Where("id = ? AND is_available = ?", next.ID, true).
+			Update("is_available", false)
+		if res.Error != nil {
+			return res.Error
+		}
+		if res.RowsAffected == 0 {
+			return errDriverUnavailable
+		}
+
+		note := fmt.Sprintf("reassigned by admin %s", actorID)
+		if ride.DriverID != nil {
+			note += fmt.Sprintf(" from driver %s", *ride.DriverID)
+		}
+		note += fmt.Sprintf(" to driver %s: %s", next.ID, req.Reason)
+
+		previousID := ride.DriverID
+		if err := h.machine.FireWithReason(tx, &ride, ridestate.Reassign, ridestate.Admin, actorID, "reassigned",
+			map[string]interface{}{
+				"driver_id":          next.ID,
+				"accepted_at":        now,
+				"pickup_eta":         h.cancellation.PickupETA(&ride, &next, now),
+				"arrived_at":         nil,
+				"start_pin":          newStartPIN(),
+				"start_pin_attempts": 0,
+			}, note); err != nil {
+			return err
+		}
+		var err error
+		released, err = releaseDriver(tx, *previousID)
+		return err
+	})
+	if err != nil {
+		respondAdminRideError(w, err, "failed to reassign ride")
+		return
+	}
+
+	audit.AfterCommit(r.Context(), func() {
+		ctx := context.Background()
+		h.locations.Remove(ctx, next.ID)
+		if previous != nil && released {
+			withLivePosition(ctx, h.locations, previous)
+			if hasPosition(previous) {
+				h.locations.Add(ctx, previous.ID, previous.CurrentLat, previous.CurrentLng)
+			}
+		}
+		h.events.PublishStatus(ctx, ride.ID, ride.Status)
+	})
+
+	db.First(&ride, "id = ?", ride.ID)
+	audit.Record(r.Context(), "ride.reassign", "ride", ride.ID, before, ride)
+	writeJSON(w, ride)
+}
+
+// AdminCancelRide cancels a ride at any point before completion. The rider
+// is never charged a fee and any payment is voided.
+func (h *RideHandler) AdminCancelRide(w http.ResponseWriter, r *http.Request) {
+	var req adminCancelRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "invalid request", http.StatusBadRequest)
+		return
+	}
+	if !cancellation.ValidReason(ridestate.Admin, req.Reason) {
+		http.Error(w, "reason must be one of "+strings.Join(cancellation.Reasons(ridestate.Admin), ", "), http.StatusBadRequest)
+		return
+	}
+	req.Note = strings.TrimSpace(req.Note)
+	if req.Note == "" {
+		http.Error(w, "note is required", http.StatusBadRequest)
+		return
+	}
+
+	db := audit.DB(r.Context(), h.db)
+	var ride models.Ride
+	if err := db.First(&ride, "id = ?", chi.URLParam(r, "id")).Error; err != nil {
+		http.Error(w, "ride not found", http.StatusNotFound)
+		return
+	}
+	before := audit.Snapshot(ride)
+
+	var driver *models.Driver
+	if ride.DriverID != nil {
+		var d models.Driver
+		if err := db.First(&d, "id = ?", *ride.DriverID).Error; err == nil {
+			driver = &d
+		}
+	}
+
+	if err := h.cancel(r.Context(), &ride, driver, ridestate.Admin, adminActor(r), req.Reason, req.Note, 0); err != nil {
+		respondAdminRideError(w, err, "failed to cancel ride")
+		return
+	}
+	audit.Record(r.Context(), "ride.cancel", "ride", ride.ID, before, ride)
+	writeJSON(w, ride)
+}
+
+// AdminCompleteRide ends a started ride on the driver's behalf, billing it
+// as if the driver had completed it.
+func (h *RideHandler) AdminCompleteRide(w http.ResponseWriter, r *http.Request) {
+	var req adminCompleteRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil || strings.TrimSpace(req.Reason) == "" {
+		http.Error(w, "reason is required", http.StatusBadRequest)
+		return
+	}
+
+	db := audit.DB(r.Context(), h.db)
+	var ride models.Ride
+	if err := db.First(&ride, "id = ?", chi.URLParam(r, "id")).Error; err != nil {
+		http.Error(w, "ride not found", http.StatusNotFound)
+		return
+	}
+	before := audit.Snapshot(ride)
+
+	if ride.DriverID == nil {
+		http.Error(w, "ride not assigned to a driver", http.StatusConflict)
+		return
+	}
+	var driver models.Driver
+	if err := db.First(&driver, "id = ?", *ride.DriverID).Error; err != nil {
+		http.Error(w, "driver not found", http.StatusNotFound)
+		return
+	}
+
+	actorID := adminActor(r)
+	note := fmt.Sprintf("completed by admin %s for driver %s: %s", actorID, driver.ID, strings.TrimSpace(req.Reason))
+	if err := h.complete(r.Context(), &ride, &driver, ridestate.Admin, actorID, "force_completed", note); err != nil {
+		respondAdminRideError(w, err, "failed to complete ride")
+		return
+	}
+	audit.Record(r.Context(), "ride.complete", "ride", ride.ID, before, ride)
+	writeJSON(w, ride)
+}
+
+// AdminAdjustFare changes the final fare of a completed ride. The
+// difference is added to the fare breakdown and the driver's earnings.
+// If the rider has already paid, a decrease is refunded to their wallet
+// and an increase is charged to the same method; otherwise the next
+// settlement attempt collects the new amount.
+//
+// An increase is recorded as a pending adjustment, together with the new
+// fare, before it is charged. A declined charge reverses it. If the
+// charge's outcome is unknown the adjustment stays pending, and the next
+// adjustment of the ride retries it with the same idempotency key first.
+func (h *RideHandler) AdminAdjustFare(w http.ResponseWriter, r *http.Request) {
+	var req adminFareRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil || req.Fare == nil {
+		http.Error(w, "fare is required", http.StatusBadRequest)
+		return
+	}
+	if *req.Fare < 0 {
+		http.Error(w, "fare cannot be negative", http.StatusBadRequest)
+		return
+	}
+	req.Note = strings.TrimSpace(req.Note)
+	if req.Note == "" {
+		http.Error(w, "note is required", http.StatusBadRequest)
+		return
+	}
+
+	db := audit.DB(r.Context(), h.db)
+	var ride models.Ride
+	if err := db.First(&ride, "id = ?", chi.URLParam(r, "id")).Error; err != nil {
+		http.Error(w, "ride not found", http.StatusNotFound)
+		return
+	}
+	before := audit.Snapshot(ride)
+
+	actorID := adminActor(r)
+	if err := ridestate.Check(&ride, ridestate.AdjustFare, ridestate.Admin, actorID); err != nil {
+		respondAdminRideError(w, err, "failed to adjust fare")
+		return
+	}
+
+	if err := h.retryPendingAdjustment(r.Context(), ride.ID); err != nil {
+		respondAdminRideError(w, err, "failed to adjust fare")
+		return
+	}
+
+	var adj *models.FareAdjustment
+	err := db.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).First(&ride, "id = ?", ride.ID).Error; err != nil {
+			return err
+		}
+		var pending int64
+		if err := tx.Model(&models.FareAdjustment{}).
+			Where("ride_id = ? AND status = ?", ride.ID, payments.StatusPending).
+			Count(&pending).Error; err != nil {
+			return err
+		}
+		if pending > 0 {
+			return errAdjustmentPending
+		}
+
+		delta := payments.MinorUnits(*req.Fare) - payments.MinorUnits(ride.Fare)
+		if delta == 0 {
+			return nil
+		}
+		paid := ride.PaymentStatus == payments.StatusSucceeded
+		if paid && delta > 0 && ride.PaymentMethod == payments.MethodCash {
+			return payments.ErrCashIncrease
+		}
+
+		adj = &models.FareAdjustment{
+			RideID:   ride.ID,
+			FromFare: ride.Fare,
+			ToFare:   *req.Fare,
+			Amount:   delta,
+			Note:     req.Note,
+			Actor:    actorID,
+			Status:   payments.StatusSucceeded,
+		}
+		if paid && delta > 0 {
+			adj.Status = payments.StatusPending
+		}
+		if err := tx.Create(adj).Error; err != nil {
+			return err
+		}
+
+		note := fmt.Sprintf("fare adjusted by admin %s from %.2f to %.2f: %s", actorID, ride.Fare, *req.Fare, req.Note)
+		if err := h.changeFare(tx, &ride, adj.ToFare, delta, "Adjustment: "+req.Note, "fare_adjusted", note, actorID); err != nil {
+			return err
+		}
+		if paid && delta < 0 {
+			return h.payments.Refund(tx, &ride, -delta)
+		}
+		return nil
+	})
+	if err != nil {
+		respondAdminRideError(w, err, "failed to adjust fare")
+		return
+	}
+
+	db.First(&ride, "id = ?", ride.ID)
+	audit.Record(r.Context(), "ride.adjust_fare", "ride", ride.ID, before, ride)
+
+	if adj != nil && adj.Status == payments.StatusPending {
+		// The pending adjustment must be committed before the charge, so
+		// a retry finds it and reuses its idempotency key.
+		if err := audit.Commit(r.Context()); err != nil {
+			log.Printf("audit: recording fare adjustment on ride %s: %v", ride.ID, err)
+			http.Error(w, "failed to record audit log", http.StatusInternalServerError)
+			return
+		}
+		if err := h.chargeAdjustment(r.Context(), adj); err != nil {
+			respondAdminRideError(w, err, "failed to charge fare increase")
+			return
+		}
+		h.db.First(&ride, "id = ?", ride.ID)
+	}
+	writeJSON(w, ride)
+}
+
+// changeFare sets a completed ride's fare, itemising the change of delta
+// in the breakdown and the driver's earnings.
+func (h *RideHandler) changeFare(tx *gorm.DB, ride *models.Ride, to float64, delta int64, label, reason, note, actorID string) error {
+	breakdown := ride.FareBreakdown
+	if breakdown == nil {
+		breakdown = &models.FareBreakdown{Total: ride.Fare, SurgeMultiplier: 1}
+	}
+	fare.AddItem(breakdown, "adjustment", label, float64(delta)/100)
+
+	if err := h.machine.FireWithReason(tx, ride, ridestate.AdjustFare, ridestate.Admin, actorID, reason,
+		map[string]interface{}{
+			"fare":           to,
+			"fare_breakdown": breakdown,
+		}, note); err != nil {
+		return err
+	}
+	return h.earnings.Adjust(tx, ride, delta)
+}
+
+// chargeAdjustment collects a pending fare increase. If the charge is
+// refused the increase is reversed and the refusal returned; any other
+// error leaves the adjustment pending and is reported as
+// errAdjustmentPending.
+func (h *RideHandler) chargeAdjustment(ctx context.Context, adj *models.FareAdjustment) error {
+	err := h.payments.ChargeAdjustment(ctx, adj)
+	if err == nil {
+		return nil
+	}
+	if !payments.Refused(err) {
+		log.Printf("payments: fare adjustment %s on ride %s left pending: %v", adj.ID, adj.RideID, err)
+		return fmt.Errorf("%w: %v", errAdjustmentPending, err)
+	}
+
+	if rerr := h.reverseAdjustment(adj, err); rerr != nil {
+		log.Printf("payments: reversing declined fare adjustment %s on ride %s: %v", adj.ID, adj.RideID, rerr)
+		return fmt.Errorf("%w: %v", errAdjustmentPending, rerr)
+	}
+	return err
+}
+
+// reverseAdjustment marks a pending adjustment failed and puts the fare
+// back. It does nothing if the adjustment has been settled meanwhile.
+func (h *RideHandler) reverseAdjustment(adj *models.FareAdjustment, cause error) error {
+	return h.db.Transaction(func(tx *gorm.DB) error {
+		var ride models.Ride
+		if err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).First(&ride, "id = ?", adj.RideID).Error; err != nil {
+			return err
+		}
+		res := tx.Model(&models.FareAdjustment{}).
+			Where("id = ? AND status = ?", adj.ID, payments.StatusPending).
+			Updates(map[string]interface{}{"status": payments.StatusFailed, "error": cause.Error()})
+		if res.Error != nil || res.RowsAffected == 0 {
+			return res.Error
+		}
+
+		note := fmt.Sprintf("fare increase from %.2f to %.2f by admin %s reversed: %v", adj.FromFare, adj.ToFare, adj.Actor, cause)
+		return h.changeFare(tx, &ride, adj.FromFare, -adj.Amount, "Reversed adjustment: "+adj.Note, "fare_adjustment_reversed", note, adj.Actor)
+	})
+}
+
+// retryPendingAdjustment charges a fare increase an earlier request left
+// pending, so a new adjustment starts from a settled fare. A refused retry
+// has been reversed and isn't an error for the new adjustment.
+func (h *RideHandler) retryPendingAdjustment(ctx context.Context, rideID string) error {
+	var adj models.FareAdjustment
+	err := h.db.Where("ride_id = ? AND status = ?", rideID, payments.StatusPending).First(&adj).Error
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	if err := h.chargeAdjustment(ctx, &adj); err != nil && !payments.Refused(err) {
+		return err
+	}
+	return nil
+}
+
+var (
+	errDriverUnavailable = errors.New("driver is not available")
+	errAdjustmentPending = errors.New("a fare increase on this ride is still waiting to be charged; try again")
+)
+
+func respondAdminRideError(w http.ResponseWriter, err error, message string) {
+	var stateErr *ridestate.Error
+	switch {
+	case errors.As(err, &stateErr):
+		http.Error(w, stateErr.Message, http.StatusConflict)
+	case errors.Is(err, errAdjustmentPending):
+		http.Error(w, errAdjustmentPending.Error(), http.StatusConflict)
+	case errors.Is(err, errDriverUnavailable), errors.Is(err, earnings.ErrPaidOut),
+		errors.Is(err, payments.ErrCashIncrease):
+		http.Error(w, err.Error(), http.StatusConflict)
+	case errors.Is(err, gorm.ErrRecordNotFound):
+		http.Error(w, "ride not found", http.StatusNotFound)
+	case errors.Is(err, payments.ErrDeclined), errors.Is(err, payments.ErrInsufficientFunds):
+		http.Error(w, err.Error(), http.StatusPaymentRequired)
+	default:
+		http.Error(w, message, http.StatusInternalServerError)
+	}
+}
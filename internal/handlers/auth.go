@@ -2,27 +2,48 @@ package handlers
 
 import (
 	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
 	"encoding/json"
+	"errors"
+	"log"
 	"net/http"
 	"time"
 
 	"rickshaw-app/internal/config"
+	"rickshaw-app/internal/geo"
 	"rickshaw-app/internal/middleware"
 	"rickshaw-app/internal/models"
+	"rickshaw-app/internal/otp"
+	"rickshaw-app/internal/session"
+	"rickshaw-app/internal/sms"
 
+	"github.com/go-chi/chi/v5"
 	"github.com/redis/go-redis/v9"
 	"golang.org/x/crypto/bcrypt"
 	"gorm.io/gorm"
 )
 
 type AuthHandler struct {
-	db  *gorm.DB
-	rdb *redis.Client
-	cfg *config.Config
+	db        *gorm.DB
+	rdb       *redis.Client
+	cfg       *config.Config
+	locations *geo.Index
+	sessions  *session.Store
+	otp       *otp.Service
 }
 
 func NewAuthHandler(db *gorm.DB, rdb *redis.Client, cfg *config.Config) *AuthHandler {
-	return &AuthHandler{db: db, rdb: rdb, cfg: cfg}
+	return &AuthHandler{
+		db:        db,
+		rdb:       rdb,
+		cfg:       cfg,
+		locations: geo.NewIndex(rdb),
+		sessions:  session.NewStore(rdb),
+		otp:       otp.NewService(rdb, sms.NewSender(cfg), cfg.JWTSecret),
+	}
 }
 
 type RegisterRequest struct {
@@ -37,11 +58,28 @@ type LoginRequest struct {
 	Password string `json:"password"`
 }
 
+type OTPRequest struct {
+	Phone string `json:"phone"`
+}
+
+type VerifyOTPRequest struct {
+	Phone string `json:"phone"`
+	Code  string `json:"code"`
+}
+
+type RefreshRequest struct {
+	RefreshToken string `json:"refresh_token"`
+}
+
 type AuthResponse struct {
-	Token string       `json:"token"`
-	User  *models.User `json:"user"`
+	Token        string       `json:"token"`
+	RefreshToken string       `json:"refresh_token"`
+	ExpiresAt    time.Time    `json:"expires_at"`
+	User         *models.User `json:"user"`
 }
 
+var errRefreshTokenReused = errors.New("refresh token reused")
+
 func (h *AuthHandler) Register(w http.ResponseWriter, r *http.Request) {
 	var req RegisterRequest
 	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
@@ -72,16 +110,17 @@ func (h *AuthHandler) Register(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	token, err := middleware.GenerateToken(user.ID, user.UserType, h.cfg.JWTSecret)
+	if err := h.otp.Request(r.Context(), user.Phone); err != nil {
+		log.Printf("failed to send verification code to user %s: %v", user.ID, err)
+	}
+
+	resp, err := h.startSession(r, user)
 	if err != nil {
 		respondJSON(w, http.StatusInternalServerError, map[string]string{"error": "failed to generate token"})
 		return
 	}
 
-	ctx := context.Background()
-	h.rdb.Set(ctx, "token:"+user.ID, token, 24*time.Hour)
-
-	respondJSON(w, http.StatusCreated, AuthResponse{Token: token, User: user})
+	respondJSON(w, http.StatusCreated, resp)
 }
 
 func (h *AuthHandler) Login(w http.ResponseWriter, r *http.Request) {
@@ -102,23 +141,170 @@ func (h *AuthHandler) Login(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	token, err := middleware.GenerateToken(user.ID, user.UserType, h.cfg.JWTSecret)
+	resp, err := h.startSession(r, &user)
 	if err != nil {
 		respondJSON(w, http.StatusInternalServerError, map[string]string{"error": "failed to generate token"})
 		return
 	}
 
-	ctx := context.Background()
-	h.rdb.Set(ctx, "token:"+user.ID, token, 24*time.Hour)
+	respondJSON(w, http.StatusOK, resp)
+}
+
+// RequestOTP texts a verification code to a registered phone number. The
+// response is the same whether or not the number is registered.
+func (h *AuthHandler) RequestOTP(w http.ResponseWriter, r *http.Request) {
+	var req OTPRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil || req.Phone == "" {
+		respondJSON(w, http.StatusBadRequest, map[string]string{"error": "invalid request"})
+		return
+	}
 
-	respondJSON(w, http.StatusOK, AuthResponse{Token: token, User: &user})
+	var user models.User
+	if err := h.db.Where("phone = ?", req.Phone).First(&user).Error; err == nil {
+		err := h.otp.Request(r.Context(), user.Phone)
+		if errors.Is(err, otp.ErrCooldown) {
+			respondJSON(w, http.StatusTooManyRequests, map[string]string{"error": err.Error()})
+			return
+		}
+		if err != nil {
+			respondJSON(w, http.StatusInternalServerError, map[string]string{"error": "failed to send code"})
+			return
+		}
+	}
+
+	respondJSON(w, http.StatusOK, map[string]string{"message": "if the number is registered, a code has been sent"})
+}
+
+// VerifyOTP marks the phone number as verified. It does not log the user
+// in; they still sign in with their password.
+func (h *AuthHandler) VerifyOTP(w http.ResponseWriter, r *http.Request) {
+	var req VerifyOTPRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil || req.Phone == "" || req.Code == "" {
+		respondJSON(w, http.StatusBadRequest, map[string]string{"error": "invalid request"})
+		return
+	}
+
+	var user models.User
+	if err := h.db.Where("phone = ?", req.Phone).First(&user).Error; err != nil {
+		respondJSON(w, http.StatusUnauthorized, map[string]string{"error": otp.ErrInvalidCode.Error()})
+		return
+	}
+
+	err := h.otp.Verify(r.Context(), user.Phone, req.Code)
+	if errors.Is(err, otp.ErrInvalidCode) || errors.Is(err, otp.ErrTooManyAttempts) {
+		respondJSON(w, http.StatusUnauthorized, map[string]string{"error": err.Error()})
+		return
+	}
+	if err != nil {
+		respondJSON(w, http.StatusInternalServerError, map[string]string{"error": "failed to verify code"})
+		return
+	}
+
+	if !user.PhoneVerified {
+		if err := h.db.Model(&user).Update("phone_verified", true).Error; err != nil {
+			respondJSON(w, http.StatusInternalServerError, map[string]string{"error": "failed to verify phone"})
+			return
+		}
+	}
+
+	respondJSON(w, http.StatusOK, map[string]string{"message": "phone number verified"})
+}
+
+// Refresh exchanges a refresh token for a new access/refresh pair. Each
+// refresh token is single use; presenting one that was already rotated
+// means it leaked, so the whole session is revoked.
+func (h *AuthHandler) Refresh(w http.ResponseWriter, r *http.Request) {
+	var req RefreshRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil || req.RefreshToken == "" {
+		respondJSON(w, http.StatusBadRequest, map[string]string{"error": "invalid request"})
+		return
+	}
+
+	var current models.RefreshToken
+	if err := h.db.Where("token_hash = ?", hashToken(req.RefreshToken)).First(&current).Error; err != nil {
+		respondJSON(w, http.StatusUnauthorized, map[string]string{"error": "invalid refresh token"})
+		return
+	}
+
+	if current.RevokedAt != nil || time.Now().After(current.ExpiresAt) {
+		respondJSON(w, http.StatusUnauthorized, map[string]string{"error": "invalid refresh token"})
+		return
+	}
+
+	if current.UsedAt != nil {
+		h.revokeFamily(r.Context(), current.UserID, current.FamilyID)
+		respondJSON(w, http.StatusUnauthorized, map[string]string{"error": "refresh token reuse detected; session revoked"})
+		return
+	}
+
+	var user models.User
+	if err := h.db.First(&user, "id = ?", current.UserID).Error; err != nil {
+		respondJSON(w, http.StatusUnauthorized, map[string]string{"error": "invalid refresh token"})
+		return
+	}
+
+	refreshToken := newRefreshToken()
+	next := &models.RefreshToken{
+		UserID:    user.ID,
+		FamilyID:  current.FamilyID,
+		TokenHash: hashToken(refreshToken),
+		UserAgent: r.UserAgent(),
+		IP:        r.RemoteAddr,
+		ExpiresAt: time.Now().Add(h.cfg.RefreshTokenTTL),
+	}
+
+	err := h.db.Transaction(func(tx *gorm.DB) error {
+		res := tx.Model(&models.RefreshToken{}).
+			Where("id = ? AND used_at IS NULL AND revoked_at IS NULL", current.ID).
+			Update("used_at", time.Now())
+		if res.Error != nil {
+			return res.Error
+		}
+		// Someone else rotated this token between our read and write.
+		if res.RowsAffected == 0 {
+			return errRefreshTokenReused
+		}
+		return tx.Create(next).Error
+	})
+	if errors.Is(err, errRefreshTokenReused) {
+		h.revokeFamily(r.Context(), current.UserID, current.FamilyID)
+		respondJSON(w, http.StatusUnauthorized, map[string]string{"error": "refresh token reuse detected; session revoked"})
+		return
+	}
+	if err != nil {
+		respondJSON(w, http.StatusInternalServerError, map[string]string{"error": "failed to refresh token"})
+		return
+	}
+
+	if err := h.sessions.Extend(r.Context(), user.ID, current.FamilyID, h.cfg.RefreshTokenTTL); err != nil {
+		respondJSON(w, http.StatusUnauthorized, map[string]string{"error": "session revoked"})
+		return
+	}
+
+	expiresAt := time.Now().Add(h.cfg.AccessTokenTTL)
+	token, err := middleware.GenerateToken(user.ID, user.UserType, current.FamilyID, h.cfg.JWTSecret, h.cfg.AccessTokenTTL)
+	if err != nil {
+		respondJSON(w, http.StatusInternalServerError, map[string]string{"error": "failed to generate token"})
+		return
+	}
+
+	respondJSON(w, http.StatusOK, AuthResponse{Token: token, RefreshToken: refreshToken, ExpiresAt: expiresAt, User: &user})
 }
 
 func (h *AuthHandler) Logout(w http.ResponseWriter, r *http.Request) {
 	userID := middleware.GetUserID(r.Context())
 
 	ctx := context.Background()
-	h.rdb.Del(ctx, "token:"+userID)
+	h.revokeFamily(ctx, userID, middleware.GetSessionID(r.Context()))
+
+	// A driver who logs out goes offline so they stop showing up in nearby search.
+	if middleware.GetUserType(r.Context()) == "driver" {
+		var driver models.Driver
+		if err := h.db.Where("user_id = ?", userID).First(&driver).Error; err == nil {
+			h.db.Model(&driver).Update("is_available", false)
+			h.locations.Remove(ctx, driver.ID)
+		}
+	}
 
 	respondJSON(w, http.StatusOK, map[string]string{"message": "logged out successfully"})
 }
@@ -135,6 +321,132 @@ func (h *AuthHandler) Me(w http.ResponseWriter, r *http.Request) {
 	respondJSON(w, http.StatusOK, user)
 }
 
+func (h *AuthHandler) ListSessions(w http.ResponseWriter, r *http.Request) {
+	userID := middleware.GetUserID(r.Context())
+	current := middleware.GetSessionID(r.Context())
+
+	sessions, err := h.sessions.List(r.Context(), userID)
+	if err != nil {
+		respondJSON(w, http.StatusInternalServerError, map[string]string{"error": "failed to fetch sessions"})
+		return
+	}
+
+	for i := range sessions {
+		sessions[i].Current = sessions[i].ID == current
+	}
+
+	respondJSON(w, http.StatusOK, sessions)
+}
+
+func (h *AuthHandler) RevokeSession(w http.ResponseWriter, r *http.Request) {
+	userID := middleware.GetUserID(r.Context())
+	sessionID := chi.URLParam(r, "id")
+
+	exists, err := h.sessions.Exists(r.Context(), userID, sessionID)
+	if err != nil {
+		respondJSON(w, http.StatusInternalServerError, map[string]string{"error": "failed to revoke session"})
+		return
+	}
+	if !exists {
+		respondJSON(w, http.StatusNotFound, map[string]string{"error": "session not found"})
+		return
+	}
+
+	if err := h.revokeFamily(r.Context(), userID, sessionID); err != nil {
+		respondJSON(w, http.StatusInternalServerError, map[string]string{"error": "failed to revoke session"})
+		return
+	}
+
+	respondJSON(w, http.StatusOK, map[string]string{"message": "session revoked"})
+}
+
+func (h *AuthHandler) RevokeAllSessions(w http.ResponseWriter, r *http.Request) {
+	userID := middleware.GetUserID(r.Context())
+
+	if err := h.sessions.RevokeAll(r.Context(), userID); err != nil {
+		respondJSON(w, http.StatusInternalServerError, map[string]string{"error": "failed to revoke sessions"})
+		return
+	}
+
+	if err := h.db.Model(&models.RefreshToken{}).
+		Where("user_id = ? AND revoked_at IS NULL", userID).
+		Update("revoked_at", time.Now()).Error; err != nil {
+		respondJSON(w, http.StatusInternalServerError, map[string]string{"error": "failed to revoke sessions"})
+		return
+	}
+
+	respondJSON(w, http.StatusOK, map[string]string{"message": "all sessions revoked"})
+}
+
+// startSession records a new device session and issues the first
+// access/refresh pair for it.
+func (h *AuthHandler) startSession(r *http.Request, user *models.User) (*AuthResponse, error) {
+	sess := &session.Session{
+		UserID:    user.ID,
+		UserAgent: r.UserAgent(),
+		IP:        r.RemoteAddr,
+	}
+	if err := h.sessions.Create(r.Context(), sess, h.cfg.RefreshTokenTTL); err != nil {
+		return nil, err
+	}
+
+	refreshToken := newRefreshToken()
+	if err := h.db.Create(&models.RefreshToken{
+		UserID:    user.ID,
+		FamilyID:  sess.ID,
+		TokenHash: hashToken(refreshToken),
+		UserAgent: sess.UserAgent,
+		IP:        sess.IP,
+		ExpiresAt: sess.ExpiresAt,
+	}).Error; err != nil {
+		return nil, err
+	}
+
+	expiresAt := time.Now().Add(h.cfg.AccessTokenTTL)
+	token, err := middleware.GenerateToken(user.ID, user.UserType, sess.ID, h.cfg.JWTSecret, h.cfg.AccessTokenTTL)
+	if err != nil {
+		return nil, err
+	}
+
+	return &AuthResponse{Token: token, RefreshToken: refreshToken, ExpiresAt: expiresAt, User: user}, nil
+}
+
+// revokeFamily ends a session and every refresh token issued for it.
+func (h *AuthHandler) revokeFamily(ctx context.Context, userID, familyID string) error {
+	if err := h.sessions.Revoke(ctx, userID, familyID); err != nil {
+		return err
+	}
+	return h.db.Model(&models.RefreshToken{}).
+		Where("family_id = ? AND revoked_at IS NULL", familyID).
+		Update("revoked_at", time.Now()).Error
+}
+
+func newRefreshToken() string {
+	b := make([]byte, 32)
+	rand.Read(b)
+	return base64.RawURLEncoding.EncodeToString(b)
+}
+
+func hashToken(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:])
+}
+
+// requireVerifiedPhone writes a 403 and returns false unless the user has
+// verified their phone number.
+func requireVerifiedPhone(w http.ResponseWriter, db *gorm.DB, userID string) bool {
+	var user models.User
+	if err := db.Select("phone_verified").First(&user, "id = ?", userID).Error; err != nil {
+		respondJSON(w, http.StatusNotFound, map[string]string{"error": "user not found"})
+		return false
+	}
+	if !user.PhoneVerified {
+		respondJSON(w, http.StatusForbidden, map[string]string{"error": "phone number not verified", "code": "phone_unverified"})
+		return false
+	}
+	return true
+}
+
 func respondJSON(w http.ResponseWriter, status int, data interface{}) {
 	w.Header().Set("Content-Type", "application/json")
 	w.WriteHeader(status)
@@ -3,26 +3,56 @@ package handlers
 import (
 	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
-	"math"
+	"log"
 	"net/http"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
 
 	"rickshaw-app/internal/config"
+	"rickshaw-app/internal/events"
+	"rickshaw-app/internal/geo"
 	"rickshaw-app/internal/middleware"
 	"rickshaw-app/internal/models"
+	"rickshaw-app/internal/ridestate"
+	"rickshaw-app/internal/trail"
 
 	"github.com/redis/go-redis/v9"
 	"gorm.io/gorm"
 )
 
+const (
+	defaultNearbyRadiusKm = 10.0
+	maxNearbyRadiusKm     = 50.0
+	defaultNearbyLimit    = 20
+	maxNearbyLimit        = 100
+
+	// maxClockSkew is how far ahead of the server a batched location's
+	// timestamp may be before it is dropped.
+	maxClockSkew = time.Minute
+)
+
 type DriverHandler struct {
-	db  *gorm.DB
-	rdb *redis.Client
-	cfg *config.Config
+	db        *gorm.DB
+	rdb       *redis.Client
+	cfg       *config.Config
+	locations *geo.Index
+	events    *events.Broker
+	trail     *trail.Recorder
 }
 
 func NewDriverHandler(db *gorm.DB, rdb *redis.Client, cfg *config.Config) *DriverHandler {
-	return &DriverHandler{db: db, rdb: rdb, cfg: cfg}
+	return &DriverHandler{
+		db:        db,
+		rdb:       rdb,
+		cfg:       cfg,
+		locations: geo.NewIndex(rdb),
+		events:    events.NewBroker(rdb),
+		trail:     trail.NewRecorder(db, rdb),
+	}
 }
 
 type CreateDriverRequest struct {
@@ -49,6 +79,10 @@ func (h *DriverHandler) CreateProfile(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	if !requireVerifiedPhone(w, h.db, userID) {
+		return
+	}
+
 	var req CreateDriverRequest
 	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
 		respondJSON(w, http.StatusBadRequest, map[string]string{"error": "invalid request"})
@@ -98,22 +132,239 @@ func (h *DriverHandler) UpdateLocation(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	driver.CurrentLat = req.Lat
-	driver.CurrentLng = req.Lng
-
-	if err := h.db.Save(&driver).Error; err != nil {
+	if _, err := h.recordLocation(context.Background(), &driver, []trail.Point{{Lat: req.Lat, Lng: req.Lng, At: time.Now()}}); err != nil {
 		respondJSON(w, http.StatusInternalServerError, map[string]string{"error": "failed to update location"})
 		return
 	}
 
-	ctx := context.Background()
-	h.rdb.GeoAdd(ctx, "drivers:locations", &redis.GeoLocation{
-		Name:      driver.ID,
-		Longitude: req.Lng,
-		Latitude:  req.Lat,
+	respondJSON(w, http.StatusOK, driver)
+}
+
+type LocationPoint struct {
+	Lat        float64   `json:"lat"`
+	Lng        float64   `json:"lng"`
+	RecordedAt time.Time `json:"recorded_at"`
+	Accuracy   *float64  `json:"accuracy,omitempty"` // metres
+	Speed      *float64  `json:"speed,omitempty"`    // m/s
+	Heading    *float64  `json:"heading,omitempty"`  // degrees from north
+}
+
+type UpdateLocationBatchRequest struct {
+	Points []LocationPoint `json:"points"`
+}
+
+type LocationBatchResponse struct {
+	Received    int            `json:"received"`
+	Accepted    int            `json:"accepted"`
+	TrailPoints int            `json:"trail_points"`
+	Driver      *models.Driver `json:"driver"`
+}
+
+// UpdateLocationBatch takes the points a driver's app buffered while it had
+// no signal. Points are de-duplicated and ordered by the time they were
+// recorded; the latest becomes the live position and, during a started
+// ride, all of them are appended to the trail. Points that are too
+// inaccurate or claim to be from the future are dropped.
+func (h *DriverHandler) UpdateLocationBatch(w http.ResponseWriter, r *http.Request) {
+	userID := middleware.GetUserID(r.Context())
+
+	var req UpdateLocationBatchRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		respondJSON(w, http.StatusBadRequest, map[string]string{"error": "invalid request"})
+		return
+	}
+	if len(req.Points) == 0 || len(req.Points) > h.cfg.LocationMaxBatch {
+		respondJSON(w, http.StatusBadRequest, map[string]string{"error": fmt.Sprintf("points must hold between 1 and %d entries", h.cfg.LocationMaxBatch)})
+		return
+	}
+	for i, p := range req.Points {
+		if !validCoordinate(p.Lat, p.Lng) || p.RecordedAt.IsZero() {
+			respondJSON(w, http.StatusBadRequest, map[string]string{"error": fmt.Sprintf("point %d needs a valid lat, lng and recorded_at", i)})
+			return
+		}
+		if (p.Accuracy != nil && *p.Accuracy < 0) || (p.Speed != nil && *p.Speed < 0) ||
+			(p.Heading != nil && (*p.Heading < 0 || *p.Heading >= 360)) {
+			respondJSON(w, http.StatusBadRequest, map[string]string{"error": fmt.Sprintf("point %d has an invalid accuracy, speed or heading", i)})
+			return
+		}
+	}
+
+	var driver models.Driver
+	if err := h.db.Where("user_id = ?", userID).First(&driver).Error; err != nil {
+		respondJSON(w, http.StatusNotFound, map[string]string{"error": "driver profile not found"})
+		return
+	}
+
+	points := h.cleanBatch(req.Points, time.Now())
+	resp := LocationBatchResponse{Received: len(req.Points), Accepted: len(points), Driver: &driver}
+	if len(points) > 0 {
+		trailed, err := h.recordLocation(context.Background(), &driver, points)
+		if err != nil {
+			respondJSON(w, http.StatusInternalServerError, map[string]string{"error": "failed to update location"})
+			return
+		}
+		resp.TrailPoints = trailed
+	}
+
+	respondJSON(w, http.StatusOK, resp)
+}
+
+// cleanBatch orders points by when they were recorded, keeping the first
+// of any sharing a timestamp, and drops inaccurate and future points.
+func (h *DriverHandler) cleanBatch(in []LocationPoint, now time.Time) []trail.Point {
+	sorted := make([]LocationPoint, len(in))
+	copy(sorted, in)
+	slices.SortStableFunc(sorted, func(a, b LocationPoint) int {
+		return a.RecordedAt.Compare(b.RecordedAt)
 	})
 
-	respondJSON(w, http.StatusOK, driver)
+	points := make([]trail.Point, 0, len(sorted))
+	for i, p := range sorted {
+		if i > 0 && p.RecordedAt.Equal(sorted[i-1].RecordedAt) {
+			continue
+		}
+		if p.RecordedAt.After(now.Add(maxClockSkew)) {
+			continue
+		}
+		if p.Accuracy != nil && *p.Accuracy > h.cfg.LocationMaxAccuracyM {
+			continue
+		}
+		points = append(points, trail.Point{
+			Lat:      p.Lat,
+			Lng:      p.Lng,
+			At:       p.RecordedAt,
+			Accuracy: p.Accuracy,
+			Speed:    p.Speed,
+			Heading:  p.Heading,
+		})
+	}
+	return points
+}
+
+// recordLocation applies points, oldest first, to the driver. The newest
+// becomes the live position in Redis unless a newer one is already there,
+// and is persisted to Postgres at most once per LocationWriteInterval. If
+// the driver is on a started ride every point recorded since it started is
+// offered to its trail; the number kept is returned.
+func (h *DriverHandler) recordLocation(ctx context.Context, driver *models.Driver, points []trail.Point) (int, error) {
+	latest := points[len(points)-1]
+
+	live, err := h.locations.SetPosition(ctx, driver.ID, geo.Position{Lat: latest.Lat, Lng: latest.Lng, At: latest.At})
+	if err != nil {
+		// Without Redis, fall back to writing every update to Postgres.
+		log.Printf("location: driver %s: %v", driver.ID, err)
+		live = true
+	}
+	if live {
+		driver.CurrentLat = latest.Lat
+		driver.CurrentLng = latest.Lng
+		if driver.IsAvailable {
+			h.locations.Add(ctx, driver.ID, latest.Lat, latest.Lng)
+		}
+		if err := h.persistLocation(ctx, driver, latest.At); err != nil {
+			return 0, err
+		}
+	} else {
+		withLivePosition(ctx, h.locations, driver)
+	}
+
+	// Let the rider of an in-progress ride watch the driver approach, and
+	// record the route once the trip is under way.
+	var ride models.Ride
+	err = h.db.Select("id", "status", "started_at").
+		Where("driver_id = ? AND status IN ?", driver.ID, []string{string(ridestate.Accepted), string(ridestate.Arrived), string(ridestate.Started)}).
+		First(&ride).Error
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return 0, nil
+	}
+	if err != nil {
+		return 0, err
+	}
+	if live {
+		h.events.PublishLocation(ctx, ride.ID, latest.Lat, latest.Lng)
+	}
+	if ride.Status != string(ridestate.Started) {
+		return 0, nil
+	}
+
+	trailed := 0
+	for _, p := range points {
+		// Buffered batches can include the drive to the pickup, which the
+		// rider doesn't pay for.
+		if ride.StartedAt != nil && p.At.Before(*ride.StartedAt) {
+			continue
+		}
+		kept, err := h.trail.Add(ctx, ride.ID, p)
+		if err != nil {
+			log.Printf("trail: ride %s: %v", ride.ID, err)
+			break
+		}
+		if kept {
+			trailed++
+		}
+	}
+	return trailed, nil
+}
+
+// persistLocation writes the driver's position to Postgres unless it was
+// written within the last LocationWriteInterval. Readers that need the
+// latest position go through withLivePosition. The throttle is claimed
+// before writing so concurrent updates don't all write, and released if the
+// write fails so the next update tries again.
+func (h *DriverHandler) persistLocation(ctx context.Context, driver *models.Driver, at time.Time) error {
+	throttleKey := "driver:" + driver.ID + ":location:persisted"
+	due, err := h.rdb.SetNX(ctx, throttleKey, 1, h.cfg.LocationWriteInterval).Result()
+	if err != nil {
+		log.Printf("location: driver %s: %v", driver.ID, err)
+		due = true
+	}
+	if !due {
+		return nil
+	}
+
+	err = h.db.Model(&models.Driver{}).Where("id = ?", driver.ID).Updates(map[string]interface{}{
+		"current_lat":         driver.CurrentLat,
+		"current_lng":         driver.CurrentLng,
+		"location_updated_at": at,
+	}).Error
+	if err != nil {
+		h.rdb.Del(ctx, throttleKey)
+		return err
+	}
+	driver.LocationUpdatedAt = &at
+	return nil
+}
+
+// respondDriverBlocked rejects a request from a suspended or banned driver.
+func respondDriverBlocked(w http.ResponseWriter, driver *models.Driver) {
+	body := map[string]interface{}{
+		"error":  "driver account is " + driver.Status,
+		"code":   "driver_" + driver.Status,
+		"reason": driver.StatusReason,
+	}
+	if driver.SuspendedUntil != nil {
+		body["until"] = driver.SuspendedUntil
+	}
+	respondJSON(w, http.StatusForbidden, body)
+}
+
+// withLivePosition replaces the driver's persisted position with the one in
+// Redis when that is newer.
+func withLivePosition(ctx context.Context, locations *geo.Index, driver *models.Driver) {
+	p, err := locations.Position(ctx, driver.ID)
+	if err != nil || p == nil {
+		return
+	}
+	if driver.LocationUpdatedAt == nil || p.At.After(*driver.LocationUpdatedAt) {
+		driver.CurrentLat = p.Lat
+		driver.CurrentLng = p.Lng
+	}
+}
+
+// hasPosition reports whether the driver has a known location. (0, 0) is
+// what drivers who have never reported one have, not a place to find them.
+func hasPosition(driver *models.Driver) bool {
+	return driver.CurrentLat != 0 || driver.CurrentLng != 0
 }
 
 func (h *DriverHandler) UpdateAvailability(w http.ResponseWriter, r *http.Request) {
@@ -131,32 +382,82 @@ func (h *DriverHandler) UpdateAvailability(w http.ResponseWriter, r *http.Reques
 		return
 	}
 
-	driver.IsAvailable = req.IsAvailable
+	if req.IsAvailable && driver.Blocked(time.Now()) {
+		respondDriverBlocked(w, &driver)
+		return
+	}
 
-	if err := h.db.Save(&driver).Error; err != nil {
+	// Write only the flag, and going online only while the driver may
+	// work, so a suspension landing since we read the driver still wins.
+	query := h.db.Model(&models.Driver{}).Where("id = ?", driver.ID)
+	if req.IsAvailable {
+		query = query.Scopes(notBlocked(time.Now()))
+	}
+	res := query.Update("is_available", req.IsAvailable)
+	if res.Error != nil {
 		respondJSON(w, http.StatusInternalServerError, map[string]string{"error": "failed to update availability"})
 		return
 	}
+	if res.RowsAffected == 0 {
+		h.db.First(&driver, "id = ?", driver.ID)
+		respondDriverBlocked(w, &driver)
+		return
+	}
+	driver.IsAvailable = req.IsAvailable
+
+	ctx := context.Background()
+	withLivePosition(ctx, h.locations, &driver)
+	if driver.IsAvailable && hasPosition(&driver) {
+		h.locations.Add(ctx, driver.ID, driver.CurrentLat, driver.CurrentLng)
+	} else {
+		h.locations.Remove(ctx, driver.ID)
+	}
 
 	respondJSON(w, http.StatusOK, driver)
 }
 
 func (h *DriverHandler) GetNearbyDrivers(w http.ResponseWriter, r *http.Request) {
-	lat := r.URL.Query().Get("lat")
-	lng := r.URL.Query().Get("lng")
+	query := r.URL.Query()
 
-	if lat == "" || lng == "" {
+	lat, errLat := strconv.ParseFloat(query.Get("lat"), 64)
+	lng, errLng := strconv.ParseFloat(query.Get("lng"), 64)
+	if errLat != nil || errLng != nil {
 		respondJSON(w, http.StatusBadRequest, map[string]string{"error": "lat and lng are required"})
 		return
 	}
 
-	var latF, lngF float64
-	fmt.Sscanf(lat, "%f", &latF)
-	fmt.Sscanf(lng, "%f", &lngF)
+	radius := defaultNearbyRadiusKm
+	if v := query.Get("radius"); v != "" {
+		parsed, err := strconv.ParseFloat(v, 64)
+		if err != nil || parsed <= 0 || parsed > maxNearbyRadiusKm {
+			respondJSON(w, http.StatusBadRequest, map[string]string{"error": "radius must be between 0 and 50 km"})
+			return
+		}
+		radius = parsed
+	}
 
-	var drivers []models.Driver
-	if err := h.db.Where("is_available = ?", true).Find(&drivers).Error; err != nil {
-		respondJSON(w, http.StatusInternalServerError, map[string]string{"error": "failed to fetch drivers"})
+	limit := defaultNearbyLimit
+	if v := query.Get("limit"); v != "" {
+		parsed, err := strconv.Atoi(v)
+		if err != nil || parsed <= 0 || parsed > maxNearbyLimit {
+			respondJSON(w, http.StatusBadRequest, map[string]string{"error": "limit must be between 1 and 100"})
+			return
+		}
+		limit = parsed
+	}
+
+	sort := strings.ToLower(query.Get("sort"))
+	if sort == "" {
+		sort = "asc"
+	}
+	if sort != "asc" && sort != "desc" {
+		respondJSON(w, http.StatusBadRequest, map[string]string{"error": "sort must be asc or desc"})
+		return
+	}
+
+	nearby, err := h.locations.Search(r.Context(), lat, lng, radius, limit, sort)
+	if err != nil {
+		respondJSON(w, http.StatusInternalServerError, map[string]string{"error": "failed to search nearby drivers"})
 		return
 	}
 
@@ -166,32 +467,43 @@ func (h *DriverHandler) GetNearbyDrivers(w http.ResponseWriter, r *http.Request)
 	}
 
 	nearbyDrivers := []DriverWithDistance{}
+	if len(nearby) == 0 {
+		respondJSON(w, http.StatusOK, nearbyDrivers)
+		return
+	}
+
+	ids := make([]string, 0, len(nearby))
+	for _, n := range nearby {
+		ids = append(ids, n.DriverID)
+	}
+
+	// The geo set only holds available drivers, but it is updated outside the
+	// DB write so re-check availability and suspensions before returning
+	// anyone.
+	var drivers []models.Driver
+	if err := h.db.Where("id IN ? AND is_available = ?", ids, true).Find(&drivers).Error; err != nil {
+		respondJSON(w, http.StatusInternalServerError, map[string]string{"error": "failed to fetch drivers"})
+		return
+	}
+
+	now := time.Now()
+	byID := make(map[string]models.Driver, len(drivers))
 	for _, driver := range drivers {
-		if driver.CurrentLat == 0 && driver.CurrentLng == 0 {
-			continue
+		if !driver.Blocked(now) {
+			byID[driver.ID] = driver
 		}
+	}
 
-		distance := haversine(latF, lngF, driver.CurrentLat, driver.CurrentLng)
-		if distance <= 10 {
-			nearbyDrivers = append(nearbyDrivers, DriverWithDistance{
-				Driver:   driver,
-				Distance: distance,
-			})
+	for _, n := range nearby {
+		driver, ok := byID[n.DriverID]
+		if !ok {
+			continue
 		}
+		nearbyDrivers = append(nearbyDrivers, DriverWithDistance{
+			Driver:   driver,
+			Distance: n.Distance,
+		})
 	}
 
 	respondJSON(w, http.StatusOK, nearbyDrivers)
 }
-
-func haversine(lat1, lon1, lat2, lon2 float64) float64 {
-	const R = 6371
-	dLat := (lat2 - lat1) * math.Pi / 180
-	dLon := (lon2 - lon1) * math.Pi / 180
-
-	a := math.Sin(dLat/2)*math.Sin(dLat/2) +
-		math.Cos(lat1*math.Pi/180)*math.Cos(lat2*math.Pi/180)*
-			math.Sin(dLon/2)*math.Sin(dLon/2)
-
-	c := 2 * math.Atan2(math.Sqrt(a), math.Sqrt(1-a))
-	return R * c
-}
@@ -0,0 +1,156 @@
+package handlers
+
+import (
+	"bytes"
+	"net/http"
+	"time"
+
+	"rickshaw-app/internal/config"
+	"rickshaw-app/internal/earnings"
+	"rickshaw-app/internal/middleware"
+	"rickshaw-app/internal/models"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/redis/go-redis/v9"
+	"gorm.io/gorm"
+)
+
+type EarningsHandler struct {
+	db       *gorm.DB
+	earnings *earnings.Service
+}
+
+func NewEarningsHandler(db *gorm.DB, rdb *redis.Client, cfg *config.Config) *EarningsHandler {
+	return &EarningsHandler{db: db, earnings: earnings.NewService(db, cfg)}
+}
+
+// GetEarnings aggregates the driver's earnings by day or week. from and to
+// are inclusive dates (YYYY-MM-DD) and default to the last seven days.
+func (h *EarningsHandler) GetEarnings(w http.ResponseWriter, r *http.Request) {
+	driver, ok := h.currentDriver(w, r)
+	if !ok {
+		return
+	}
+
+	period := r.URL.Query().Get("period")
+	if period == "" {
+		period = "day"
+	}
+	if period != "day" && period != "week" {
+		respondJSON(w, http.StatusBadRequest, map[string]string{"error": "period must be day or week"})
+		return
+	}
+
+	today := time.Now().Truncate(24 * time.Hour)
+	from, to, ok := parseDateRange(w, r, today.AddDate(0, 0, -6), today)
+	if !ok {
+		return
+	}
+
+	buckets, err := h.earnings.Summary(driver.ID, period, from, to)
+	if err != nil {
+		respondJSON(w, http.StatusInternalServerError, map[string]string{"error": "failed to fetch earnings"})
+		return
+	}
+
+	var total earnings.Bucket
+	total.PeriodStart = from
+	for _, b := range buckets {
+		total.Rides += b.Rides
+		total.Gross += b.Gross
+		total.Commission += b.Commission
+		total.Tips += b.Tips
+		total.Net += b.Net
+		total.CashCollected += b.CashCollected
+	}
+
+	respondJSON(w, http.StatusOK, map[string]interface{}{
+		"period":  period,
+		"from":    from,
+		"to":      to,
+		"buckets": buckets,
+		"total":   total,
+	})
+}
+
+func (h *EarningsHandler) ListPayouts(w http.ResponseWriter, r *http.Request) {
+	driver, ok := h.currentDriver(w, r)
+	if !ok {
+		return
+	}
+
+	var payouts []models.Payout
+	if err := h.db.Where("driver_id = ?", driver.ID).Order("period_start DESC").Find(&payouts).Error; err != nil {
+		respondJSON(w, http.StatusInternalServerError, map[string]string{"error": "failed to fetch payouts"})
+		return
+	}
+
+	respondJSON(w, http.StatusOK, payouts)
+}
+
+func (h *EarningsHandler) GetPayoutStatement(w http.ResponseWriter, r *http.Request) {
+	driver, ok := h.currentDriver(w, r)
+	if !ok {
+		return
+	}
+
+	var payout models.Payout
+	if err := h.db.First(&payout, "id = ? AND driver_id = ?", chi.URLParam(r, "id"), driver.ID).Error; err != nil {
+		respondJSON(w, http.StatusNotFound, map[string]string{"error": "payout not found"})
+		return
+	}
+
+	writeStatement(w, h.earnings, &payout)
+}
+
+func (h *EarningsHandler) currentDriver(w http.ResponseWriter, r *http.Request) (*models.Driver, bool) {
+	if middleware.GetUserType(r.Context()) != "driver" {
+		respondJSON(w, http.StatusForbidden, map[string]string{"error": "only drivers have earnings"})
+		return nil, false
+	}
+
+	var driver models.Driver
+	if err := h.db.Where("user_id = ?", middleware.GetUserID(r.Context())).First(&driver).Error; err != nil {
+		respondJSON(w, http.StatusNotFound, map[string]string{"error": "driver profile not found"})
+		return nil, false
+	}
+	return &driver, true
+}
+
+// parseDateRange reads inclusive from/to dates from the query and returns
+// them as a half-open [from, to) range.
+func parseDateRange(w http.ResponseWriter, r *http.Request, defaultFrom, defaultTo time.Time) (time.Time, time.Time, bool) {
+	from, to := defaultFrom, defaultTo
+	if v := r.URL.Query().Get("from"); v != "" {
+		t, err := time.Parse("2006-01-02", v)
+		if err != nil {
+			respondJSON(w, http.StatusBadRequest, map[string]string{"error": "from must be YYYY-MM-DD"})
+			return from, to, false
+		}
+		from = t
+	}
+	if v := r.URL.Query().Get("to"); v != "" {
+		t, err := time.Parse("2006-01-02", v)
+		if err != nil {
+			respondJSON(w, http.StatusBadRequest, map[string]string{"error": "to must be YYYY-MM-DD"})
+			return from, to, false
+		}
+		to = t
+	}
+	if to.Before(from) {
+		respondJSON(w, http.StatusBadRequest, map[string]string{"error": "to must not be before from"})
+		return from, to, false
+	}
+	return from, to.AddDate(0, 0, 1), true
+}
+
+func writeStatement(w http.ResponseWriter, svc *earnings.Service, payout *models.Payout) {
+	var buf bytes.Buffer
+	if err := svc.WriteStatement(&buf, payout); err != nil {
+		http.Error(w, "failed to write statement", http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "text/csv")
+	w.Header().Set("Content-Disposition", `attachment; filename="statement-`+payout.ID+`.csv"`)
+	w.Write(buf.Bytes())
+}
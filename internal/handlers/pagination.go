@@ -0,0 +1,286 @@
+package handlers
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"maps"
+	"net/http"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+const (
+	defaultPageSize = 50
+	maxPageSize     = 200
+)
+
+// Page is one page of an admin list. NextCursor is empty on the last page.
+type Page[T any] struct {
+	Items      []T    `json:"items"`
+	NextCursor string `json:"next_cursor,omitempty"`
+}
+
+// pageQuery is a parsed ?sort=&order=&limit=&cursor= request. Sort keys are
+// the JSON field names of the listed model.
+type pageQuery struct {
+	sort   string
+	expr   string
+	desc   bool
+	limit  int
+	cursor *pageCursor
+}
+
+// pageCursor is the sort value and ID of the last row on the previous page,
+// so the next page starts strictly after it even when sort values repeat.
+type pageCursor struct {
+	Sort  string `json:"s"`
+	Value any    `json:"v"`
+	ID    string `json:"id"`
+}
+
+// parsePageQuery reads paging parameters. sortable maps each allowed sort
+// key to the SQL expression it orders by; results default to newest first.
+func parsePageQuery(r *http.Request, sortable map[string]string) (*pageQuery, error) {
+	q := r.URL.Query()
+	p := &pageQuery{sort: "created_at", desc: true, limit: defaultPageSize}
+
+	if v := q.Get("sort"); v != "" {
+		p.sort = v
+	}
+	expr, ok := sortable[p.sort]
+	if !ok {
+		return nil, fmt.Errorf("sort must be one of %s", strings.Join(slices.Sorted(maps.Keys(sortable)), ", "))
+	}
+	p.expr = expr
+
+	switch q.Get("order") {
+	case "", "desc":
+	case "asc":
+		p.desc = false
+	default:
+		return nil, errors.New("order must be asc or desc")
+	}
+
+	if v := q.Get("limit"); v != "" {
+		limit, err := strconv.Atoi(v)
+		if err != nil || limit <= 0 || limit > maxPageSize {
+			return nil, fmt.Errorf("limit must be between 1 and %d", maxPageSize)
+		}
+		p.limit = limit
+	}
+
+	if v := q.Get("cursor"); v != "" {
+		raw, err := base64.RawURLEncoding.DecodeString(v)
+		var c pageCursor
+		if err != nil || json.Unmarshal(raw, &c) != nil || c.ID == "" || c.Sort != p.sort {
+			return nil, errors.New("invalid cursor")
+		}
+		p.cursor = &c
+	}
+	return p, nil
+}
+
+// paginate runs query, which must select a single table with an id column,
+// for the page p describes.
+func paginate[T any](query *gorm.DB, p *pageQuery) (*Page[T], error) {
+	dir, cmp := "ASC", ">"
+	if p.desc {
+		dir, cmp = "DESC", "<"
+	}
+
+	if p.cursor != nil {
+		query = query.Where(fmt.Sprintf("(%s, id) %s (?, ?)", p.expr, cmp), p.cursorValue(), p.cursor.ID)
+	}
+
+	items := []T{}
+	if err := query.Order(fmt.Sprintf("%s %s, id %s", p.expr, dir, dir)).Limit(p.limit + 1).Find(&items).Error; err != nil {
+		return nil, err
+	}
+
+	page := &Page[T]{Items: items}
+	if len(items) <= p.limit {
+		return page, nil
+	}
+	page.Items = items[:p.limit]
+
+	cursor, err := p.next(page.Items[p.limit-1])
+	if err != nil {
+		return nil, err
+	}
+	page.NextCursor = cursor
+	return page, nil
+}
+
+// cursorValue returns the cursor's sort value for the keyset comparison.
+// Times come back from JSON as strings, so those of time sort keys, which
+// all end in _at, are parsed back; other strings such as names are left
+// alone even if they look like a time.
+func (p *pageQuery) cursorValue() any {
+	value := p.cursor.Value
+	if s, ok := value.(string); ok && strings.HasSuffix(p.sort, "_at") {
+		if t, err := time.Parse(time.RFC3339Nano, s); err == nil {
+			return t
+		}
+	}
+	return value
+}
+
+// next builds the cursor following last from its JSON form, whose field
+// names match the sort keys.
+func (p *pageQuery) next(last any) (string, error) {
+	raw, err := json.Marshal(last)
+	if err != nil {
+		return "", err
+	}
+	var fields map[string]any
+	if err := json.Unmarshal(raw, &fields); err != nil {
+		return "", err
+	}
+
+	id, _ := fields["id"].(string)
+	value := fields[p.sort]
+	if value == nil {
+		value = 0 // sort expressions coalesce NULLs to zero
+	}
+	raw, err = json.Marshal(pageCursor{Sort: p.sort, Value: value, ID: id})
+	if err != nil {
+		return "", err
+	}
+	return base64.RawURLEncoding.EncodeToString(raw), nil
+}
+
+// listFilter applies the query-string filters shared by the admin lists to
+// query, reporting the first invalid one as a 400.
+type listFilter struct {
+	w     http.ResponseWriter
+	r     *http.Request
+	query *gorm.DB
+	ok    bool
+}
+
+func newListFilter(w http.ResponseWriter, r *http.Request, query *gorm.DB) *listFilter {
+	return &listFilter{w: w, r: r, query: query, ok: true}
+}
+
+func (f *listFilter) fail(msg string) {
+	if f.ok {
+		http.Error(f.w, msg, http.StatusBadRequest)
+	}
+	f.ok = false
+}
+
+// equal filters column = ?param when the parameter is present.
+func (f *listFilter) equal(param, column string) {
+	if v := f.r.URL.Query().Get(param); v != "" {
+		f.query = f.query.Where(column+" = ?", v)
+	}
+}
+
+// in filters column to a comma-separated list of values.
+func (f *listFilter) in(param, column string) {
+	if v := f.r.URL.Query().Get(param); v != "" {
+		f.query = f.query.Where(column+" IN ?", strings.Split(v, ","))
+	}
+}
+
+// boolean filters column to ?param=true or false.
+func (f *listFilter) boolean(param, column string) {
+	v := f.r.URL.Query().Get(param)
+	if v == "" {
+		return
+	}
+	b, err := strconv.ParseBool(v)
+	if err != nil {
+		f.fail(param + " must be true or false")
+		return
+	}
+	f.query = f.query.Where(column+" = ?", b)
+}
+
+// dateRange filters column to ?from= and ?to=, RFC 3339 times or inclusive
+// YYYY-MM-DD dates in loc.
+func (f *listFilter) dateRange(column string, loc *time.Location) {
+	q := f.r.URL.Query()
+	from, ok := parseAdminTime(q.Get("from"), false, loc)
+	if !ok {
+		f.fail("from must be an RFC 3339 time or YYYY-MM-DD")
+		return
+	}
+	to, ok := parseAdminTime(q.Get("to"), true, loc)
+	if !ok {
+		f.fail("to must be an RFC 3339 time or YYYY-MM-DD")
+		return
+	}
+	if !from.IsZero() {
+		f.query = f.query.Where(column+" >= ?", from)
+	}
+	if !to.IsZero() {
+		f.query = f.query.Where(column+" < ?", to)
+	}
+}
+
+// numberRange filters column to [?minParam, ?maxParam].
+func (f *listFilter) numberRange(minParam, maxParam, column string) {
+	q := f.r.URL.Query()
+	for _, bound := range []struct {
+		param string
+		op    string
+	}{{minParam, ">="}, {maxParam, "<="}} {
+		v := q.Get(bound.param)
+		if v == "" {
+			continue
+		}
+		n, err := strconv.ParseFloat(v, 64)
+		if err != nil {
+			f.fail(bound.param + " must be a number")
+			return
+		}
+		f.query = f.query.Where(fmt.Sprintf("%s %s ?", column, bound.op), n)
+	}
+}
+
+// search filters by a case-insensitive substring match of ?q against any
+// of the given SQL expressions.
+func (f *listFilter) search(exprs ...string) {
+	v := strings.TrimSpace(f.r.URL.Query().Get("q"))
+	if v == "" {
+		return
+	}
+	pattern := "%" + escapeLike(v) + "%"
+	conds := make([]string, len(exprs))
+	args := make([]interface{}, len(exprs))
+	for i, e := range exprs {
+		conds[i] = e + " ILIKE ?"
+		args[i] = pattern
+	}
+	f.query = f.query.Where("("+strings.Join(conds, " OR ")+")", args...)
+}
+
+func escapeLike(s string) string {
+	return strings.NewReplacer(`\`, `\\`, `%`, `\%`, `_`, `\_`).Replace(s)
+}
+
+// listPage parses paging parameters and writes one page of T from the
+// filtered query.
+func listPage[T any](w http.ResponseWriter, r *http.Request, f *listFilter, sortable map[string]string, what string) {
+	if !f.ok {
+		return
+	}
+	p, err := parsePageQuery(r, sortable)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	page, err := paginate[T](f.query, p)
+	if err != nil {
+		http.Error(w, "failed to fetch "+what, http.StatusInternalServerError)
+		return
+	}
+	writeJSON(w, page)
+}
@@ -0,0 +1,218 @@
+package handlers
+
+import (
+	"net/http/httptest"
+	"net/url"
+	"testing"
+	"time"
+
+	"rickshaw-app/internal/models"
+)
+
+func mustParsePage(t *testing.T, params url.Values, sortable map[string]string) *pageQuery {
+	t.Helper()
+	r := httptest.NewRequest("GET", "/admin/api/list?"+params.Encode(), nil)
+	p, err := parsePageQuery(r, sortable)
+	if err != nil {
+		t.Fatalf("parsePageQuery(%s): %v", params.Encode(), err)
+	}
+	return p
+}
+
+func TestParsePageQuery(t *testing.T) {
+	valid := url.Values{"sort": {"fare"}}
+	cursor, err := mustParsePage(t, valid, rideSorts).next(models.Ride{ID: "r1", Fare: 12.5})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name    string
+		params  url.Values
+		wantErr bool
+	}{
+		{"defaults", url.Values{}, false},
+		{"sort and order", url.Values{"sort": {"distance"}, "order": {"asc"}}, false},
+		{"unknown sort", url.Values{"sort": {"rider_id"}}, true},
+		{"bad order", url.Values{"order": {"up"}}, true},
+		{"zero limit", url.Values{"limit": {"0"}}, true},
+		{"limit too large", url.Values{"limit": {"201"}}, true},
+		{"limit not a number", url.Values{"limit": {"ten"}}, true},
+		{"cursor for the same sort", url.Values{"sort": {"fare"}, "cursor": {cursor}}, false},
+		{"cursor for another sort", url.Values{"sort": {"distance"}, "cursor": {cursor}}, true},
+		{"garbage cursor", url.Values{"cursor": {"not-a-cursor!"}}, true},
+		{"cursor without an id", url.Values{"cursor": {"eyJzIjoiY3JlYXRlZF9hdCJ9"}}, true}, // {"s":"created_at"}
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := httptest.NewRequest("GET", "/admin/api/rides?"+tt.params.Encode(), nil)
+			_, err := parsePageQuery(r, rideSorts)
+			if (err != nil) != tt.wantErr {
+				t.Errorf("err = %v, wantErr %v", err, tt.wantErr)
+			}
+		})
+	}
+
+	p := mustParsePage(t, url.Values{}, rideSorts)
+	if p.sort != "created_at" || !p.desc || p.limit != defaultPageSize || p.cursor != nil {
+		t.Errorf("defaults = sort %s desc %v limit %d cursor %v", p.sort, p.desc, p.limit, p.cursor)
+	}
+}
+
+// TestCursorRoundTrip checks that the cursor built from a page's last row
+// decodes to a value that compares equal to that row's sort value.
+func TestCursorRoundTrip(t *testing.T) {
+	createdAt := time.Date(2026, 3, 1, 12, 30, 15, 123456000, time.UTC)
+
+	tests := []struct {
+		name     string
+		sort     string
+		sortable map[string]string
+		last     any
+		want     any
+	}{
+		{"time keeps sub-second precision", "created_at", rideSorts, models.Ride{ID: "r1", CreatedAt: createdAt}, createdAt},
+		{"number", "fare", rideSorts, models.Ride{ID: "r1", Fare: 42.75}, 42.75},
+		{"zero number", "distance", rideSorts, models.Ride{ID: "r1"}, 0.0},
+		{"integer", "total_rides", driverSorts, models.Driver{ID: "d1", TotalRides: 7}, 7.0},
+		{"string", "name", userSorts, models.User{ID: "u1", Name: "Karim"}, "Karim"},
+		{"string that looks like a time", "name", userSorts, models.User{ID: "u1", Name: "2026-03-01T12:00:00Z"}, "2026-03-01T12:00:00Z"},
+		{"null is coalesced to zero", "rating", driverSorts, map[string]any{"id": "d1", "rating": nil}, 0.0},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			first := mustParsePage(t, url.Values{"sort": {tt.sort}}, tt.sortable)
+			cursor, err := first.next(tt.last)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			p := mustParsePage(t, url.Values{"sort": {tt.sort}, "cursor": {cursor}}, tt.sortable)
+			if p.cursor.Sort != tt.sort {
+				t.Errorf("cursor sort = %q, want %q", p.cursor.Sort, tt.sort)
+			}
+			got := p.cursorValue()
+			if want, ok := tt.want.(time.Time); ok {
+				if gotTime, ok := got.(time.Time); !ok || !gotTime.Equal(want) {
+					t.Errorf("cursor value = %#v, want %v", got, want)
+				}
+				return
+			}
+			if got != tt.want {
+				t.Errorf("cursor value = %#v, want %#v", got, tt.want)
+			}
+		})
+	}
+}
+
+// TestPaginateRides pages through rides with repeated and NULL sort values,
+// two at a time in both directions, and checks every ride comes back once
+// and in order. It needs a migrated database in TEST_DATABASE_URL.
+func TestPaginateRides(t *testing.T) {
+	db, _ := acceptTestHandler(t)
+	rider := newTestUser(t, db, "rider", 0)
+
+	fares := []float64{10, 10, 10, 20, 20, 0, 0, 30}
+	var ids []string
+	for _, fare := range fares {
+		ride := newTestRide(t, db, rider)
+		if err := db.Model(&ride).Update("fare", fare).Error; err != nil {
+			t.Fatal(err)
+		}
+		ids = append(ids, ride.ID)
+	}
+	// The zero fares are stored as NULL, which the sort coalesces to 0.
+	if err := db.Exec("UPDATE rides SET fare = NULL WHERE id IN ?", ids[5:7]).Error; err != nil {
+		t.Fatal(err)
+	}
+
+	for _, sort := range []string{"fare", "created_at"} {
+		for _, order := range []string{"asc", "desc"} {
+			t.Run(sort+" "+order, func(t *testing.T) {
+				var seen []models.Ride
+				params := url.Values{"sort": {sort}, "order": {order}, "limit": {"2"}}
+				for pages := 0; ; pages++ {
+					if pages > len(fares) {
+						t.Fatal("pagination did not end")
+					}
+					p := mustParsePage(t, params, rideSorts)
+					page, err := paginate[models.Ride](db.Model(&models.Ride{}).Where("rider_id = ?", rider.ID), p)
+					if err != nil {
+						t.Fatal(err)
+					}
+					seen = append(seen, page.Items...)
+					if page.NextCursor == "" {
+						break
+					}
+					params.Set("cursor", page.NextCursor)
+				}
+
+				if len(seen) != len(fares) {
+					t.Fatalf("saw %d rides, want %d", len(seen), len(fares))
+				}
+				unique := map[string]bool{}
+				for i, ride := range seen {
+					if unique[ride.ID] {
+						t.Fatalf("ride %s returned twice", ride.ID)
+					}
+					unique[ride.ID] = true
+					if i == 0 {
+						continue
+					}
+					prev := seen[i-1]
+					cmp := compareRides(prev, ride, sort)
+					if (order == "asc" && cmp > 0) || (order == "desc" && cmp < 0) {
+						t.Errorf("rides %d and %d out of %s order", i-1, i, order)
+					}
+				}
+			})
+		}
+	}
+}
+
+// compareRides orders two rides by sort, then ID, as paginate does.
+func compareRides(a, b models.Ride, sort string) int {
+	switch {
+	case sort == "fare" && a.Fare != b.Fare:
+		if a.Fare < b.Fare {
+			return -1
+		}
+		return 1
+	case sort == "created_at" && !a.CreatedAt.Equal(b.CreatedAt):
+		return a.CreatedAt.Compare(b.CreatedAt)
+	case a.ID < b.ID:
+		return -1
+	case a.ID > b.ID:
+		return 1
+	}
+	return 0
+}
+
+func TestParseAdminTime(t *testing.T) {
+	dhaka := time.FixedZone("Asia/Dhaka", 6*60*60)
+
+	tests := []struct {
+		name   string
+		v      string
+		end    bool
+		want   time.Time
+		wantOK bool
+	}{
+		{"empty", "", false, time.Time{}, true},
+		{"date starts at local midnight", "2026-03-02", false, time.Date(2026, 3, 1, 18, 0, 0, 0, time.UTC), true},
+		{"end date covers the day", "2026-03-02", true, time.Date(2026, 3, 2, 18, 0, 0, 0, time.UTC), true},
+		{"RFC 3339 keeps its offset", "2026-03-02T10:00:00+06:00", false, time.Date(2026, 3, 2, 4, 0, 0, 0, time.UTC), true},
+		{"invalid", "02/03/2026", false, time.Time{}, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, ok := parseAdminTime(tt.v, tt.end, dhaka)
+			if ok != tt.wantOK {
+				t.Fatalf("ok = %v, want %v", ok, tt.wantOK)
+			}
+			if !got.Equal(tt.want) || (!got.IsZero() && got.Location() != time.UTC) {
+				t.Errorf("got %v, want %v in UTC", got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,266 @@
+package handlers
+
+import (
+	"encoding/json"
+	"errors"
+	"log"
+	"net/http"
+	"strconv"
+
+	"rickshaw-app/internal/config"
+	"rickshaw-app/internal/earnings"
+	"rickshaw-app/internal/middleware"
+	"rickshaw-app/internal/models"
+	"rickshaw-app/internal/payments"
+	"rickshaw-app/internal/ridestate"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/redis/go-redis/v9"
+	"gorm.io/gorm"
+)
+
+type PaymentHandler struct {
+	db       *gorm.DB
+	payments *payments.Service
+	earnings *earnings.Service
+}
+
+func NewPaymentHandler(db *gorm.DB, rdb *redis.Client, cfg *config.Config) *PaymentHandler {
+	return &PaymentHandler{
+		db:       db,
+		payments: payments.NewService(db, cfg),
+		earnings: earnings.NewService(db, cfg),
+	}
+}
+
+type TopUpRequest struct {
+	Amount         int64  `json:"amount"` // in minor units
+	CardToken      string `json:"card_token"`
+	IdempotencyKey string `json:"idempotency_key"`
+}
+
+type TipRequest struct {
+	Amount int64 `json:"amount"` // in minor units
+}
+
+type RetryPaymentRequest struct {
+	PaymentMethod string `json:"payment_method"`
+	CardToken     string `json:"card_token"`
+}
+
+// GetPayment returns the payment for a ride to its rider or driver.
+func (h *PaymentHandler) GetPayment(w http.ResponseWriter, r *http.Request) {
+	userID := middleware.GetUserID(r.Context())
+	userType := middleware.GetUserType(r.Context())
+	rideID := chi.URLParam(r, "id")
+
+	var ride models.Ride
+	if err := h.db.First(&ride, "id = ?", rideID).Error; err != nil {
+		respondJSON(w, http.StatusNotFound, map[string]string{"error": "ride not found"})
+		return
+	}
+
+	authorized := userType == "rider" && ride.RiderID == userID
+	if userType == "driver" && ride.DriverID != nil {
+		var driver models.Driver
+		authorized = h.db.First(&driver, "id = ? AND user_id = ?", *ride.DriverID, userID).Error == nil
+	}
+	if !authorized {
+		respondJSON(w, http.StatusForbidden, map[string]string{"error": "not authorized"})
+		return
+	}
+
+	payment, err := h.payments.ForRide(ride.ID)
+	if err != nil {
+		respondJSON(w, http.StatusNotFound, map[string]string{"error": "payment not found"})
+		return
+	}
+
+	respondJSON(w, http.StatusOK, payment)
+}
+
+// RetryPayment lets a rider settle a failed payment, optionally with a
+// different method or card.
+func (h *PaymentHandler) RetryPayment(w http.ResponseWriter, r *http.Request) {
+	userID := middleware.GetUserID(r.Context())
+	rideID := chi.URLParam(r, "id")
+
+	var req RetryPaymentRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		respondJSON(w, http.StatusBadRequest, map[string]string{"error": "invalid request"})
+		return
+	}
+
+	var ride models.Ride
+	if err := h.db.First(&ride, "id = ? AND rider_id = ?", rideID, userID).Error; err != nil {
+		respondJSON(w, http.StatusNotFound, map[string]string{"error": "ride not found"})
+		return
+	}
+	owesFee := ride.Status == string(ridestate.Cancelled) && ride.CancellationFee > 0
+	if ride.Status != string(ridestate.Completed) && !owesFee {
+		respondJSON(w, http.StatusConflict, map[string]string{"error": "only completed rides and cancellation fees can be paid"})
+		return
+	}
+
+	if req.PaymentMethod != "" {
+		err := h.payments.ChangeMethod(ride.ID, req.PaymentMethod, req.CardToken)
+		if err != nil {
+			respondPaymentError(w, err)
+			return
+		}
+	}
+
+	payment, err := h.payments.Settle(r.Context(), ride.ID)
+	if err != nil {
+		respondPaymentError(w, err)
+		return
+	}
+	if payment.Status == payments.StatusFailed {
+		respondJSON(w, http.StatusPaymentRequired, payment)
+		return
+	}
+
+	respondJSON(w, http.StatusOK, payment)
+}
+
+// TipRide charges a tip on a completed, paid ride with the ride's payment
+// method. The driver receives all of it. A ride takes one tip; retrying a
+// tip whose charge failed with the same amount is allowed.
+func (h *PaymentHandler) TipRide(w http.ResponseWriter, r *http.Request) {
+	userID := middleware.GetUserID(r.Context())
+	rideID := chi.URLParam(r, "id")
+
+	var req TipRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil || req.Amount <= 0 {
+		respondJSON(w, http.StatusBadRequest, map[string]string{"error": "amount must be positive"})
+		return
+	}
+
+	var ride models.Ride
+	if err := h.db.First(&ride, "id = ? AND rider_id = ?", rideID, userID).Error; err != nil {
+		respondJSON(w, http.StatusNotFound, map[string]string{"error": "ride not found"})
+		return
+	}
+	if ride.Status != string(ridestate.Completed) || ride.PaymentStatus != payments.StatusSucceeded {
+		respondJSON(w, http.StatusConflict, map[string]string{"error": "only completed and paid rides can be tipped"})
+		return
+	}
+
+	// Reserve the tip before charging it so two requests can't both charge
+	// one, then record it with the charge.
+	if err := h.earnings.ReserveTip(ride.ID, req.Amount); err != nil {
+		if errors.Is(err, earnings.ErrAlreadyTipped) {
+			respondJSON(w, http.StatusConflict, map[string]string{"error": "ride already tipped"})
+			return
+		}
+		respondJSON(w, http.StatusInternalServerError, map[string]string{"error": "failed to reserve tip"})
+		return
+	}
+
+	err := h.payments.ChargeTip(r.Context(), ride.ID, req.Amount, func(tx *gorm.DB) (bool, error) {
+		return h.earnings.AddTip(tx, &ride, req.Amount)
+	})
+	if err != nil {
+		// A refused charge frees the ride to be tipped again; otherwise the
+		// reservation stays so the same tip can be retried.
+		if payments.Refused(err) {
+			if err := h.earnings.ReleaseTip(ride.ID); err != nil {
+				log.Printf("payments: releasing refused tip on ride %s: %v", ride.ID, err)
+			}
+		}
+		respondPaymentError(w, err)
+		return
+	}
+
+	respondJSON(w, http.StatusOK, map[string]interface{}{"ride_id": ride.ID, "tip": req.Amount})
+}
+
+func (h *PaymentHandler) GetWallet(w http.ResponseWriter, r *http.Request) {
+	userID := middleware.GetUserID(r.Context())
+
+	if middleware.GetUserType(r.Context()) != "rider" {
+		respondJSON(w, http.StatusForbidden, map[string]string{"error": "only riders have wallets"})
+		return
+	}
+
+	wallet, err := h.payments.Wallet(userID)
+	if err != nil {
+		respondJSON(w, http.StatusInternalServerError, map[string]string{"error": "failed to fetch wallet"})
+		return
+	}
+
+	respondJSON(w, http.StatusOK, map[string]interface{}{
+		"wallet":    wallet,
+		"available": wallet.Balance - wallet.Held,
+	})
+}
+
+// TopUp charges the rider's card and credits their wallet. Clients must
+// send an idempotency key, either in the body or the Idempotency-Key
+// header, so a retried request is never charged twice.
+func (h *PaymentHandler) TopUp(w http.ResponseWriter, r *http.Request) {
+	userID := middleware.GetUserID(r.Context())
+
+	if middleware.GetUserType(r.Context()) != "rider" {
+		respondJSON(w, http.StatusForbidden, map[string]string{"error": "only riders have wallets"})
+		return
+	}
+
+	var req TopUpRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		respondJSON(w, http.StatusBadRequest, map[string]string{"error": "invalid request"})
+		return
+	}
+	if req.IdempotencyKey == "" {
+		req.IdempotencyKey = r.Header.Get("Idempotency-Key")
+	}
+	if req.IdempotencyKey == "" || len(req.IdempotencyKey) > 100 {
+		respondJSON(w, http.StatusBadRequest, map[string]string{"error": "idempotency_key is required"})
+		return
+	}
+
+	txn, err := h.payments.TopUp(r.Context(), userID, req.Amount, req.CardToken, req.IdempotencyKey)
+	if err != nil {
+		respondPaymentError(w, err)
+		return
+	}
+
+	respondJSON(w, http.StatusCreated, txn)
+}
+
+func (h *PaymentHandler) GetWalletTransactions(w http.ResponseWriter, r *http.Request) {
+	userID := middleware.GetUserID(r.Context())
+
+	if middleware.GetUserType(r.Context()) != "rider" {
+		respondJSON(w, http.StatusForbidden, map[string]string{"error": "only riders have wallets"})
+		return
+	}
+
+	limit := 50
+	if v, err := strconv.Atoi(r.URL.Query().Get("limit")); err == nil && v > 0 && v <= 200 {
+		limit = v
+	}
+
+	txns, err := h.payments.WalletTransactions(userID, limit)
+	if err != nil {
+		respondJSON(w, http.StatusInternalServerError, map[string]string{"error": "failed to fetch wallet transactions"})
+		return
+	}
+
+	respondJSON(w, http.StatusOK, txns)
+}
+
+func respondPaymentError(w http.ResponseWriter, err error) {
+	switch {
+	case errors.Is(err, payments.ErrUnknownMethod), errors.Is(err, payments.ErrMissingToken), errors.Is(err, payments.ErrInvalidAmount):
+		respondJSON(w, http.StatusBadRequest, map[string]string{"error": err.Error()})
+	case errors.Is(err, payments.ErrDeclined), errors.Is(err, payments.ErrInsufficientFunds):
+		respondJSON(w, http.StatusPaymentRequired, map[string]string{"error": err.Error()})
+	case errors.Is(err, payments.ErrKeyReused):
+		respondJSON(w, http.StatusUnprocessableEntity, map[string]string{"error": err.Error()})
+	case errors.Is(err, payments.ErrNotSettleable):
+		respondJSON(w, http.StatusConflict, map[string]string{"error": "payment is already settled or in progress"})
+	default:
+		respondJSON(w, http.StatusInternalServerError, map[string]string{"error": "failed to process payment"})
+	}
+}
@@ -1,15 +1,33 @@
 package handlers
 
 import (
+	"context"
+	"crypto/rand"
+	"crypto/subtle"
 	"encoding/json"
+	"errors"
 	"fmt"
+	"log"
 	"math"
+	"math/big"
 	"net/http"
 	"time"
 
+	"rickshaw-app/internal/audit"
+	"rickshaw-app/internal/cancellation"
 	"rickshaw-app/internal/config"
+	"rickshaw-app/internal/dispatch"
+	"rickshaw-app/internal/earnings"
+	"rickshaw-app/internal/events"
+	"rickshaw-app/internal/fare"
+	"rickshaw-app/internal/geo"
 	"rickshaw-app/internal/middleware"
 	"rickshaw-app/internal/models"
+	"rickshaw-app/internal/payments"
+	"rickshaw-app/internal/ridestate"
+	"rickshaw-app/internal/surge"
+	"rickshaw-app/internal/trail"
+	"rickshaw-app/internal/zones"
 
 	"github.com/go-chi/chi/v5"
 	"github.com/redis/go-redis/v9"
@@ -17,13 +35,39 @@ import (
 )
 
 type RideHandler struct {
-	db  *gorm.DB
-	rdb *redis.Client
-	cfg *config.Config
+	db           *gorm.DB
+	rdb          *redis.Client
+	cfg          *config.Config
+	locations    *geo.Index
+	dispatcher   *dispatch.Dispatcher
+	machine      *ridestate.Machine
+	events       *events.Broker
+	fares        *fare.Engine
+	surge        *surge.Engine
+	zones        *zones.Service
+	payments     *payments.Service
+	earnings     *earnings.Service
+	cancellation *cancellation.Policy
+	trail        *trail.Recorder
 }
 
-func NewRideHandler(db *gorm.DB, rdb *redis.Client, cfg *config.Config) *RideHandler {
-	return &RideHandler{db: db, rdb: rdb, cfg: cfg}
+func NewRideHandler(db *gorm.DB, rdb *redis.Client, cfg *config.Config, dispatcher *dispatch.Dispatcher) *RideHandler {
+	return &RideHandler{
+		db:           db,
+		rdb:          rdb,
+		cfg:          cfg,
+		locations:    geo.NewIndex(rdb),
+		dispatcher:   dispatcher,
+		machine:      ridestate.New(),
+		events:       events.NewBroker(rdb),
+		fares:        fare.NewEngine(db, cfg),
+		surge:        surge.NewEngine(db, rdb, cfg),
+		zones:        zones.NewService(db),
+		payments:     payments.NewService(db, cfg),
+		earnings:     earnings.NewService(db, cfg),
+		cancellation: cancellation.NewPolicy(db, cfg),
+		trail:        trail.NewRecorder(db, rdb),
+	}
 }
 
 type CreateRideRequest struct {
@@ -33,6 +77,10 @@ type CreateRideRequest struct {
 	DropoffLat     float64 `json:"dropoff_lat"`
 	DropoffLng     float64 `json:"dropoff_lng"`
 	DropoffAddress string  `json:"dropoff_address"`
+	VehicleClass   string  `json:"vehicle_class"`
+	QuoteID        string  `json:"quote_id"`
+	PaymentMethod  string  `json:"payment_method"`
+	CardToken      string  `json:"card_token"`
 }
 
 type CreateFareRequest struct {
@@ -42,6 +90,7 @@ type CreateFareRequest struct {
 	DropoffLat     float64 `json:"dropoff_lat"`
 	DropoffLng     float64 `json:"dropoff_lng"`
 	DropoffAddress string  `json:"dropoff_address"`
+	VehicleClass   string  `json:"vehicle_class"`
 }
 
 type RateRideRequest struct {
@@ -58,15 +107,48 @@ func (h *RideHandler) CreateRide(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	if !requireVerifiedPhone(w, h.db, userID) {
+		return
+	}
+
 	var req CreateRideRequest
 	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
 		respondJSON(w, http.StatusBadRequest, map[string]string{"error": "invalid request"})
 		return
 	}
 
-	distance := haversine(req.PickupLat, req.PickupLng, req.DropoffLat, req.DropoffLng)
-	duration := int(distance / 0.5)
-	fare := calculateFare(distance)
+	if req.VehicleClass == "" {
+		req.VehicleClass = fare.DefaultVehicleClass
+	}
+	if req.PaymentMethod == "" {
+		req.PaymentMethod = payments.MethodCash
+	}
+	if !h.payments.ValidMethod(req.PaymentMethod) {
+		respondJSON(w, http.StatusBadRequest, map[string]string{"error": "invalid payment method"})
+		return
+	}
+	if req.PaymentMethod == payments.MethodCard && req.CardToken == "" {
+		respondJSON(w, http.StatusBadRequest, map[string]string{"error": "card_token is required for card payments"})
+		return
+	}
+
+	failed, err := h.payments.HasFailed(userID)
+	if err != nil {
+		respondJSON(w, http.StatusInternalServerError, map[string]string{"error": "failed to check payments"})
+		return
+	}
+	if failed {
+		respondJSON(w, http.StatusPaymentRequired, map[string]string{
+			"error": "settle your failed payment before requesting another ride",
+			"code":  "payment_failed",
+		})
+		return
+	}
+
+	zone, ok := locateTrip(w, h.zones, req.PickupLat, req.PickupLng, req.DropoffLat, req.DropoffLng)
+	if !ok {
+		return
+	}
 
 	ride := &models.Ride{
 		RiderID:        userID,
@@ -76,18 +158,109 @@ func (h *RideHandler) CreateRide(w http.ResponseWriter, r *http.Request) {
 		DropoffLat:     req.DropoffLat,
 		DropoffLng:     req.DropoffLng,
 		DropoffAddress: req.DropoffAddress,
-		Status:         "requested",
-		Fare:           fare,
-		Distance:       distance,
-		Duration:       duration,
+		VehicleClass:   req.VehicleClass,
+		Zone:           zone,
+		PaymentMethod:  req.PaymentMethod,
 	}
 
-	if err := h.db.Create(ride).Error; err != nil {
+	if req.QuoteID != "" {
+		var quote models.FareQuote
+		if err := h.db.First(&quote, "id = ? AND rider_id = ?", req.QuoteID, userID).Error; err != nil {
+			respondJSON(w, http.StatusNotFound, map[string]string{"error": "fare quote not found"})
+			return
+		}
+		if quote.RideID != nil {
+			respondJSON(w, http.StatusConflict, map[string]string{"error": "fare quote already used"})
+			return
+		}
+		if time.Now().After(quote.ExpiresAt) {
+			respondJSON(w, http.StatusGone, map[string]string{"error": "fare quote expired"})
+			return
+		}
+		if geo.Distance(quote.PickupLat, quote.PickupLng, req.PickupLat, req.PickupLng) > quoteMatchKm ||
+			geo.Distance(quote.DropoffLat, quote.DropoffLng, req.DropoffLat, req.DropoffLng) > quoteMatchKm {
+			respondJSON(w, http.StatusUnprocessableEntity, map[string]string{"error": "fare quote does not match pickup and dropoff"})
+			return
+		}
+
+		ride.FareQuoteID = &quote.ID
+		ride.VehicleClass = quote.VehicleClass
+		ride.Zone = quote.Zone
+		ride.Fare = quote.Fare
+		ride.FareBreakdown = quote.FareBreakdown
+		ride.Distance = quote.Distance
+		ride.Duration = quote.Duration
+		ride.EstimatedDistance = &quote.Distance
+		ride.EstimatedDuration = &quote.Duration
+	} else {
+		distance := geo.Distance(req.PickupLat, req.PickupLng, req.DropoffLat, req.DropoffLng)
+		duration := int(distance / 0.5)
+		breakdown, err := h.fares.Quote(fare.Input{
+			Distance:     distance,
+			Duration:     duration,
+			VehicleClass: req.VehicleClass,
+			Zone:         zone,
+			Surge:        h.surge.MultiplierAt(r.Context(), req.PickupLat, req.PickupLng),
+		})
+		if err != nil {
+			respondFareError(w, err)
+			return
+		}
+
+		ride.Fare = breakdown.Total
+		ride.FareBreakdown = breakdown
+		ride.Distance = distance
+		ride.Duration = duration
+		ride.EstimatedDistance = &distance
+		ride.EstimatedDuration = &duration
+	}
+
+	if ride.PaymentMethod == payments.MethodWallet {
+		wallet, err := h.payments.Wallet(userID)
+		if err != nil {
+			respondJSON(w, http.StatusInternalServerError, map[string]string{"error": "failed to check wallet"})
+			return
+		}
+		if wallet.Balance-wallet.Held < payments.MinorUnits(ride.Fare) {
+			respondJSON(w, http.StatusPaymentRequired, map[string]string{
+				"error": "insufficient wallet balance",
+				"code":  "insufficient_funds",
+			})
+			return
+		}
+	}
+
+	err = h.db.Transaction(func(tx *gorm.DB) error {
+		if err := h.machine.Create(tx, ride, ridestate.Rider, userID, fmt.Sprintf("created by rider %s", userID)); err != nil {
+			return err
+		}
+		if _, err := h.payments.Open(tx, ride, req.CardToken); err != nil {
+			return err
+		}
+
+		if ride.FareQuoteID == nil {
+			return nil
+		}
+		res := tx.Model(&models.FareQuote{}).
+			Where("id = ? AND ride_id IS NULL", *ride.FareQuoteID).
+			Update("ride_id", ride.ID)
+		if res.Error != nil {
+			return res.Error
+		}
+		if res.RowsAffected == 0 {
+			return errQuoteUsed
+		}
+		return nil
+	})
+	if errors.Is(err, errQuoteUsed) {
+		respondJSON(w, http.StatusConflict, map[string]string{"error": "fare quote already used"})
+		return
+	}
+	if err != nil {
 		respondJSON(w, http.StatusInternalServerError, map[string]string{"error": "failed to create ride"})
 		return
 	}
-
-	h.logHistory(ride.ID, "requested", fmt.Sprintf("created by rider %s", userID))
+	h.dispatcher.Dispatch(ride.ID)
 
 	respondJSON(w, http.StatusCreated, ride)
 }
@@ -116,16 +289,17 @@ func (h *RideHandler) GetRides(w http.ResponseWriter, r *http.Request) {
 		}
 
 		var availableRides []models.Ride
-		if err := h.db.Order("created_at DESC").Where("status = ? AND driver_id IS NULL", "requested").Find(&availableRides).Error; err != nil {
+		if err := h.db.Order("created_at DESC").Where("status = ? AND driver_id IS NULL", string(ridestate.Requested)).Find(&availableRides).Error; err != nil {
 			respondJSON(w, http.StatusInternalServerError, map[string]string{"error": "failed to fetch rides"})
 			return
 		}
 
 		// Filter available rides based on distance from driver's current location
+		withLivePosition(r.Context(), h.locations, &driver)
 		filteredRides := assignedRides // Always include assigned rides
 		if driver.CurrentLat != 0 && driver.CurrentLng != 0 {
 			for _, ride := range availableRides {
-				distance := haversine(driver.CurrentLat, driver.CurrentLng, ride.PickupLat, ride.PickupLng)
+				distance := geo.Distance(driver.CurrentLat, driver.CurrentLng, ride.PickupLat, ride.PickupLng)
 				if distance <= 10 {
 					filteredRides = append(filteredRides, ride)
 				}
@@ -144,8 +318,17 @@ func (h *RideHandler) GetRides(w http.ResponseWriter, r *http.Request) {
 	respondJSON(w, http.StatusOK, rides)
 }
 
+// riderRideResponse adds the start PIN to the ride for its rider, who reads
+// it out to the driver at pickup.
+type riderRideResponse struct {
+	models.Ride
+	StartPIN string `json:"start_pin,omitempty"`
+}
+
 func (h *RideHandler) GetRide(w http.ResponseWriter, r *http.Request) {
 	rideID := chi.URLParam(r, "id")
+	userID := middleware.GetUserID(r.Context())
+	userType := middleware.GetUserType(r.Context())
 
 	var ride models.Ride
 	if err := h.db.First(&ride, "id = ?", rideID).Error; err != nil {
@@ -153,9 +336,150 @@ func (h *RideHandler) GetRide(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	if userType == "rider" && ride.RiderID == userID {
+		status := ridestate.Status(ride.Status)
+		if status == ridestate.Accepted || status == ridestate.Arrived {
+			respondJSON(w, http.StatusOK, riderRideResponse{Ride: ride, StartPIN: ride.StartPIN})
+			return
+		}
+	}
+
 	respondJSON(w, http.StatusOK, ride)
 }
 
+// ResetStartPIN issues a new start PIN once the driver has used up their
+// attempts. Only the rider can do this, so a locked-out driver has to be
+// with them.
+func (h *RideHandler) ResetStartPIN(w http.ResponseWriter, r *http.Request) {
+	rideID := chi.URLParam(r, "id")
+	userID := middleware.GetUserID(r.Context())
+
+	var ride models.Ride
+	if err := h.db.First(&ride, "id = ? AND rider_id = ?", rideID, userID).Error; err != nil {
+		respondJSON(w, http.StatusNotFound, map[string]string{"error": "ride not found"})
+		return
+	}
+
+	pin := newStartPIN()
+	res := h.db.Model(&models.Ride{}).
+		Where("id = ? AND status IN ?", ride.ID, []string{string(ridestate.Accepted), string(ridestate.Arrived)}).
+		Updates(map[string]interface{}{"start_pin": pin, "start_pin_attempts": 0})
+	if res.Error != nil {
+		respondJSON(w, http.StatusInternalServerError, map[string]string{"error": "failed to reset pin"})
+		return
+	}
+	if res.RowsAffected == 0 {
+		respondJSON(w, http.StatusConflict, map[string]string{"error": "ride is not waiting to start"})
+		return
+	}
+
+	ride.StartPIN = pin
+	respondJSON(w, http.StatusOK, riderRideResponse{Ride: ride, StartPIN: pin})
+}
+
+// StreamRide pushes status changes and the assigned driver's location to
+// the ride's rider and driver as server-sent events.
+func (h *RideHandler) StreamRide(w http.ResponseWriter, r *http.Request) {
+	userID := middleware.GetUserID(r.Context())
+	userType := middleware.GetUserType(r.Context())
+	rideID := chi.URLParam(r, "id")
+
+	var ride models.Ride
+	if err := h.db.First(&ride, "id = ?", rideID).Error; err != nil {
+		respondJSON(w, http.StatusNotFound, map[string]string{"error": "ride not found"})
+		return
+	}
+
+	var driver *models.Driver
+	if ride.DriverID != nil {
+		var d models.Driver
+		if err := h.db.First(&d, "id = ?", *ride.DriverID).Error; err == nil {
+			driver = &d
+			withLivePosition(r.Context(), h.locations, driver)
+		}
+	}
+
+	authorized := (userType == "rider" && ride.RiderID == userID) ||
+		(userType == "driver" && driver != nil && driver.UserID == userID)
+	if !authorized {
+		respondJSON(w, http.StatusForbidden, map[string]string{"error": "not authorized"})
+		return
+	}
+
+	ctx := r.Context()
+	sub := h.events.Subscribe(ctx, ride.ID)
+	defer sub.Close()
+
+	if _, err := sub.Receive(ctx); err != nil {
+		respondJSON(w, http.StatusInternalServerError, map[string]string{"error": "failed to subscribe to ride events"})
+		return
+	}
+
+	// The server-wide write timeout would otherwise cut the stream off.
+	rc := http.NewResponseController(w)
+	rc.SetWriteDeadline(time.Time{})
+
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.Header().Set("Connection", "keep-alive")
+	w.WriteHeader(http.StatusOK)
+
+	send := func(event events.RideEvent) error {
+		payload, err := json.Marshal(event)
+		if err != nil {
+			return err
+		}
+		if _, err := fmt.Fprintf(w, "event: %s\ndata: %s\n\n", event.Type, payload); err != nil {
+			return err
+		}
+		return rc.Flush()
+	}
+
+	if err := send(events.RideEvent{Type: events.TypeStatus, RideID: ride.ID, Status: ride.Status, At: ride.UpdatedAt}); err != nil {
+		return
+	}
+	if driver != nil && (driver.CurrentLat != 0 || driver.CurrentLng != 0) {
+		if err := send(events.RideEvent{Type: events.TypeLocation, RideID: ride.ID, Lat: driver.CurrentLat, Lng: driver.CurrentLng, At: driver.UpdatedAt}); err != nil {
+			return
+		}
+	}
+	if ridestate.Status(ride.Status).Terminal() {
+		return
+	}
+
+	keepAlive := time.NewTicker(25 * time.Second)
+	defer keepAlive.Stop()
+
+	messages := sub.Channel()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-keepAlive.C:
+			if _, err := fmt.Fprint(w, ": ping\n\n"); err != nil {
+				return
+			}
+			if err := rc.Flush(); err != nil {
+				return
+			}
+		case msg, ok := <-messages:
+			if !ok {
+				return
+			}
+			var event events.RideEvent
+			if err := json.Unmarshal([]byte(msg.Payload), &event); err != nil {
+				continue
+			}
+			if err := send(event); err != nil {
+				return
+			}
+			if event.Type == events.TypeStatus && ridestate.Status(event.Status).Terminal() {
+				return
+			}
+		}
+	}
+}
+
 func (h *RideHandler) AcceptRide(w http.ResponseWriter, r *http.Request) {
 	userID := middleware.GetUserID(r.Context())
 	userType := middleware.GetUserType(r.Context())
@@ -172,33 +496,238 @@ func (h *RideHandler) AcceptRide(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	h.acceptRide(w, rideID, &driver)
+}
+
+func (h *RideHandler) GetOffer(w http.ResponseWriter, r *http.Request) {
+	userID := middleware.GetUserID(r.Context())
+
+	var driver models.Driver
+	if err := h.db.Where("user_id = ?", userID).First(&driver).Error; err != nil {
+		respondJSON(w, http.StatusNotFound, map[string]string{"error": "driver profile not found"})
+		return
+	}
+
+	offer, err := h.dispatcher.CurrentOffer(r.Context(), driver.ID)
+	if err == dispatch.ErrNoOffer {
+		respondJSON(w, http.StatusNotFound, map[string]string{"error": "no pending offer"})
+		return
+	}
+	if err != nil {
+		respondJSON(w, http.StatusInternalServerError, map[string]string{"error": "failed to fetch offer"})
+		return
+	}
+
+	respondJSON(w, http.StatusOK, offer)
+}
+
+func (h *RideHandler) AcceptOffer(w http.ResponseWriter, r *http.Request) {
+	h.respondToOffer(w, r, true)
+}
+
+func (h *RideHandler) DeclineOffer(w http.ResponseWriter, r *http.Request) {
+	h.respondToOffer(w, r, false)
+}
+
+func (h *RideHandler) respondToOffer(w http.ResponseWriter, r *http.Request, accept bool) {
+	userID := middleware.GetUserID(r.Context())
+	userType := middleware.GetUserType(r.Context())
+	rideID := chi.URLParam(r, "id")
+
+	if userType != "driver" {
+		respondJSON(w, http.StatusForbidden, map[string]string{"error": "only drivers can respond to offers"})
+		return
+	}
+
+	var driver models.Driver
+	if err := h.db.Where("user_id = ?", userID).First(&driver).Error; err != nil {
+		respondJSON(w, http.StatusNotFound, map[string]string{"error": "driver profile not found"})
+		return
+	}
+
+	offer, err := h.dispatcher.CurrentOffer(r.Context(), driver.ID)
+	if err != nil || offer.RideID != rideID {
+		respondJSON(w, http.StatusConflict, map[string]string{"error": "offer expired or not found"})
+		return
+	}
+
+	if !accept {
+		if err := h.dispatcher.Respond(r.Context(), driver.ID, rideID, false); err != nil {
+			respondJSON(w, http.StatusConflict, map[string]string{"error": "offer expired or not found"})
+			return
+		}
+		respondJSON(w, http.StatusOK, map[string]string{"message": "offer declined"})
+		return
+	}
+
+	accepted := h.acceptRide(w, rideID, &driver)
+	if err := h.dispatcher.Respond(r.Context(), driver.ID, rideID, accepted); err != nil {
+		log.Printf("dispatch: recording driver %s's answer to ride %s: %v", driver.ID, rideID, err)
+	}
+}
+
+// acceptRide assigns the driver to the ride and writes the response. It
+// reports whether the assignment succeeded.
+func (h *RideHandler) acceptRide(w http.ResponseWriter, rideID string, driver *models.Driver) bool {
+	if driver.Blocked(time.Now()) {
+		respondDriverBlocked(w, driver)
+		return false
+	}
+
 	var ride models.Ride
 	if err := h.db.First(&ride, "id = ?", rideID).Error; err != nil {
 		respondJSON(w, http.StatusNotFound, map[string]string{"error": "ride not found"})
-		return
+		return false
 	}
 
-	if ride.Status != "requested" {
-		respondJSON(w, http.StatusBadRequest, map[string]string{"error": "ride already accepted or completed"})
+	ctx := context.Background()
+	withLivePosition(ctx, h.locations, driver)
+	now := time.Now()
+
+	err := h.db.Transaction(func(tx *gorm.DB) error {
+		// Claim the driver first; this fails if they are offline, already
+		// on another ride, or were suspended since we loaded them.
+		res := tx.Model(&models.Driver{}).
+			Where("id = ? AND is_available = ?", driver.ID, true).
+			Scopes(notBlocked(time.Now())).
+			Update("is_available", false)
+		if res.Error != nil {
+			return res.Error
+		}
+		if res.RowsAffected == 0 {
+			return errDriverUnavailable
+		}
+
+		if err := h.machine.Fire(tx, &ride, ridestate.Accept, ridestate.Driver, driver.ID,
+			map[string]interface{}{
+				"driver_id":          driver.ID,
+				"accepted_at":        now,
+				"pickup_eta":         h.cancellation.PickupETA(&ride, driver, now),
+				"start_pin":          newStartPIN(),
+				"start_pin_attempts": 0,
+			},
+			fmt.Sprintf("accepted by driver %s", driver.ID)); err != nil {
+			return err
+		}
+		return h.payments.Hold(tx, &ride)
+	})
+	if errors.Is(err, errDriverUnavailable) {
+		if h.db.First(driver, "id = ?", driver.ID).Error == nil && driver.Blocked(time.Now()) {
+			respondDriverBlocked(w, driver)
+			return false
+		}
+		respondJSON(w, http.StatusConflict, map[string]string{
+			"error": "driver is offline or already on a ride",
+			"code":  "driver_unavailable",
+		})
+		return false
+	}
+	if err != nil {
+		respondTransitionError(w, err, "failed to accept ride")
+		return false
+	}
+
+	h.locations.Remove(ctx, driver.ID)
+	h.events.PublishStatus(ctx, ride.ID, ride.Status)
+	if err := h.dispatcher.Withdraw(ctx, ride.ID); err != nil {
+		log.Printf("dispatch: withdrawing offers of accepted ride %s: %v", ride.ID, err)
+	}
+
+	h.db.First(&ride, "id = ?", ride.ID)
+	respondJSON(w, http.StatusOK, ride)
+	return true
+}
+
+// ArriveRide records that the driver has reached the pickup. Waiting time
+// is measured from here until the ride starts.
+func (h *RideHandler) ArriveRide(w http.ResponseWriter, r *http.Request) {
+	rideID := chi.URLParam(r, "id")
+	userID := middleware.GetUserID(r.Context())
+
+	var driver models.Driver
+	if err := h.db.Where("user_id = ?", userID).First(&driver).Error; err != nil {
+		respondJSON(w, http.StatusNotFound, map[string]string{"error": "driver profile not found"})
 		return
 	}
 
-	ride.DriverID = &driver.ID
-	ride.Status = "accepted"
+	var ride models.Ride
+	if err := h.db.First(&ride, "id = ?", rideID).Error; err != nil {
+		respondJSON(w, http.StatusNotFound, map[string]string{"error": "ride not found"})
+		return
+	}
 
-	if err := h.db.Save(&ride).Error; err != nil {
-		respondJSON(w, http.StatusInternalServerError, map[string]string{"error": "failed to accept ride"})
+	withLivePosition(r.Context(), h.locations, &driver)
+	if !h.cancellation.NearPickup(&ride, &driver) {
+		respondJSON(w, http.StatusConflict, map[string]string{"error": "driver is not at the pickup", "code": "not_at_pickup"})
 		return
 	}
 
-	h.logHistory(ride.ID, "accepted", fmt.Sprintf("accepted by driver %s", driver.ID))
+	err := h.db.Transaction(func(tx *gorm.DB) error {
+		return h.machine.Fire(tx, &ride, ridestate.Arrive, ridestate.Driver, driver.ID,
+			map[string]interface{}{"arrived_at": time.Now()},
+			fmt.Sprintf("driver %s arrived at pickup", driver.ID))
+	})
+	if err != nil {
+		respondTransitionError(w, err, "failed to mark ride arrived")
+		return
+	}
 
-	driver.IsAvailable = false
-	h.db.Save(&driver)
+	h.events.PublishStatus(context.Background(), ride.ID, ride.Status)
 
+	h.db.First(&ride, "id = ?", ride.ID)
 	respondJSON(w, http.StatusOK, ride)
 }
 
+type StartRideRequest struct {
+	PIN string `json:"pin"`
+}
+
+// maxStartPINAttempts is how many wrong PINs a driver may enter before the
+// rider has to issue a new one.
+const maxStartPINAttempts = 5
+
+// checkStartPIN uses up one attempt and compares the PIN, writing the error
+// response if it does not match. The attempt is taken before comparing so
+// parallel guesses cannot exceed the limit, and both the PIN and the
+// attempts left come from the row it updates rather than the ride loaded
+// earlier, so a reset in between is seen.
+func (h *RideHandler) checkStartPIN(w http.ResponseWriter, ride *models.Ride, pin string) bool {
+	var current struct {
+		StartPIN         string `gorm:"column:start_pin"`
+		StartPINAttempts int    `gorm:"column:start_pin_attempts"`
+	}
+	res := h.db.Raw(`UPDATE rides SET start_pin_attempts = start_pin_attempts + 1
+		WHERE id = ? AND start_pin_attempts < ?
+		RETURNING start_pin, start_pin_attempts`, ride.ID, maxStartPINAttempts).
+		Scan(&current)
+	if res.Error != nil {
+		respondJSON(w, http.StatusInternalServerError, map[string]string{"error": "failed to check pin"})
+		return false
+	}
+	if res.RowsAffected == 0 {
+		respondJSON(w, http.StatusTooManyRequests, map[string]string{
+			"error": "too many incorrect pins; ask the rider to reset it",
+			"code":  "pin_locked",
+		})
+		return false
+	}
+
+	if current.StartPIN == "" || subtle.ConstantTimeCompare([]byte(current.StartPIN), []byte(pin)) != 1 {
+		respondJSON(w, http.StatusForbidden, map[string]interface{}{
+			"error":              "incorrect pin",
+			"code":               "pin_incorrect",
+			"attempts_remaining": max(maxStartPINAttempts-current.StartPINAttempts, 0),
+		})
+		return false
+	}
+	return true
+}
+
+func newStartPIN() string {
+	n, _ := rand.Int(rand.Reader, big.NewInt(10000))
+	return fmt.Sprintf("%04d", n.Int64())
+}
+
 func (h *RideHandler) StartRide(w http.ResponseWriter, r *http.Request) {
 	rideID := chi.URLParam(r, "id")
 	userID := middleware.GetUserID(r.Context())
@@ -209,31 +738,61 @@ func (h *RideHandler) StartRide(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	var req StartRideRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil || req.PIN == "" {
+		respondJSON(w, http.StatusBadRequest, map[string]string{"error": "pin is required"})
+		return
+	}
+
 	var ride models.Ride
 	if err := h.db.First(&ride, "id = ?", rideID).Error; err != nil {
 		respondJSON(w, http.StatusNotFound, map[string]string{"error": "ride not found"})
 		return
 	}
 
-	if ride.DriverID == nil || *ride.DriverID != driver.ID {
-		respondJSON(w, http.StatusForbidden, map[string]string{"error": "not authorized"})
+	if err := ridestate.Check(&ride, ridestate.Start, ridestate.Driver, driver.ID); err != nil {
+		respondTransitionError(w, err, "failed to start ride")
 		return
 	}
-
-	if ride.Status != "accepted" {
-		respondJSON(w, http.StatusBadRequest, map[string]string{"error": "ride must be accepted first"})
+	if !h.checkStartPIN(w, &ride, req.PIN) {
 		return
 	}
 
-	ride.Status = "started"
+	now := time.Now()
+	updates := map[string]interface{}{"started_at": now}
+	note := fmt.Sprintf("started by driver %s", driver.ID)
+
+	if ride.ArrivedAt != nil {
+		waited := now.Sub(*ride.ArrivedAt)
+		minutes, charge := fare.WaitingCharge(waited, h.cfg.WaitGracePeriod, h.cfg.WaitChargePerMinute)
+		note += fmt.Sprintf(" after waiting %d min", int(waited.Minutes()))
+		if charge > 0 {
+			breakdown := models.FareBreakdown{Total: ride.Fare}
+			if ride.FareBreakdown != nil {
+				breakdown = *ride.FareBreakdown
+				breakdown.Items = append([]models.FareLineItem(nil), breakdown.Items...)
+			}
+			fare.AddItem(&breakdown, "waiting", fmt.Sprintf("Waiting %d min × %.2f", minutes, h.cfg.WaitChargePerMinute), charge)
 
-	if err := h.db.Save(&ride).Error; err != nil {
-		respondJSON(w, http.StatusInternalServerError, map[string]string{"error": "failed to start ride"})
+			updates["wait_minutes"] = minutes
+			updates["wait_charge"] = charge
+			updates["fare"] = breakdown.Total
+			updates["fare_breakdown"] = &breakdown
+			note += fmt.Sprintf(", %d chargeable", minutes)
+		}
+	}
+
+	err := h.db.Transaction(func(tx *gorm.DB) error {
+		return h.machine.Fire(tx, &ride, ridestate.Start, ridestate.Driver, driver.ID, updates, note)
+	})
+	if err != nil {
+		respondTransitionError(w, err, "failed to start ride")
 		return
 	}
 
-	h.logHistory(ride.ID, "started", fmt.Sprintf("started by driver %s", driver.ID))
+	h.events.PublishStatus(context.Background(), ride.ID, ride.Status)
 
+	h.db.First(&ride, "id = ?", ride.ID)
 	respondJSON(w, http.StatusOK, ride)
 }
 
@@ -253,84 +812,327 @@ func (h *RideHandler) CompleteRide(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	if ride.DriverID == nil || *ride.DriverID != driver.ID {
-		respondJSON(w, http.StatusForbidden, map[string]string{"error": "not authorized"})
+	if err := h.complete(r.Context(), &ride, &driver, ridestate.Driver, driver.ID, "",
+		fmt.Sprintf("completed by driver %s", driver.ID)); err != nil {
+		respondTransitionError(w, err, "failed to complete ride")
 		return
 	}
+	respondJSON(w, http.StatusOK, ride)
+}
 
-	if ride.Status != "started" {
-		respondJSON(w, http.StatusBadRequest, map[string]string{"error": "ride must be started first"})
-		return
+// complete bills the ride on its actuals, records the driver's earnings and
+// frees the driver, then settles payment. ride is reloaded on success.
+func (h *RideHandler) complete(ctx context.Context, ride *models.Ride, driver *models.Driver, actor ridestate.Actor, actorID, reason, note string) error {
+	if err := ridestate.Check(ride, ridestate.Complete, actor, actorID); err != nil {
+		return err
 	}
 
 	now := time.Now()
-	ride.Status = "completed"
-	ride.CompletedAt = &now
+	actual, err := h.actuals(context.Background(), ride, now)
+	if err != nil {
+		log.Printf("trail: billing ride %s on its estimate: %v", ride.ID, err)
+		actual = ride
+	}
+
+	if actual != ride {
+		note += fmt.Sprintf("; %.2f km in %d min (estimated %.2f km in %d min)",
+			actual.Distance, actual.Duration, ride.Distance, ride.Duration)
+	}
+
+	db := audit.DB(ctx, h.db)
+	var released bool
+	err = db.Transaction(func(tx *gorm.DB) error {
+		if err := h.machine.FireWithReason(tx, ride, ridestate.Complete, actor, actorID, reason,
+			map[string]interface{}{
+				"completed_at":   now,
+				"distance":       actual.Distance,
+				"duration":       actual.Duration,
+				"fare":           actual.Fare,
+				"fare_breakdown": actual.FareBreakdown,
+			}, note); err != nil {
+			return err
+		}
+		ride.Distance, ride.Duration = actual.Distance, actual.Duration
+		ride.Fare, ride.FareBreakdown = actual.Fare, actual.FareBreakdown
+
+		if err := h.earnings.Record(tx, ride); err != nil {
+			return err
+		}
+
+		if err := tx.Model(&models.Driver{}).Where("id = ?", driver.ID).
+			Update("total_rides", gorm.Expr("total_rides + 1")).Error; err != nil {
+			return err
+		}
+		released, err = releaseDriver(tx, driver.ID)
+		return err
+	})
+	if err != nil {
+		return err
+	}
+
+	audit.AfterCommit(ctx, func() {
+		ctx := context.Background()
+		if released {
+			withLivePosition(ctx, h.locations, driver)
+			if hasPosition(driver) {
+				h.locations.Add(ctx, driver.ID, driver.CurrentLat, driver.CurrentLng)
+			}
+		}
+		h.events.PublishStatus(ctx, ride.ID, ride.Status)
+		h.trail.Finish(ctx, ride.ID)
+
+		if _, err := h.payments.Settle(ctx, ride.ID); err != nil {
+			log.Printf("payments: settling ride %s: %v", ride.ID, err)
+		}
+	})
+
+	db.First(ride, "id = ?", ride.ID)
+	return nil
+}
 
-	if err := h.db.Save(&ride).Error; err != nil {
-		respondJSON(w, http.StatusInternalServerError, map[string]string{"error": "failed to complete ride"})
+// actuals returns a copy of the ride with distance and duration measured
+// from its GPS trail and the fare repriced accordingly, waiting charge
+// included. Rides with fewer than two trail points keep their estimate,
+// and rides booked from a fare quote keep their quoted fare.
+func (h *RideHandler) actuals(ctx context.Context, ride *models.Ride, completedAt time.Time) (*models.Ride, error) {
+	points, err := h.trail.Points(ctx, ride.ID)
+	if err != nil {
+		return nil, err
+	}
+	if len(points) < 2 || ride.StartedAt == nil {
+		return ride, nil
+	}
+
+	actual := *ride
+	actual.Distance = math.Round(trail.Distance(points)*100) / 100
+	actual.Duration = int(math.Ceil(completedAt.Sub(*ride.StartedAt).Minutes()))
+
+	// A ride booked from a fare quote was promised its price up front, so
+	// it keeps the quoted fare, plus any waiting charge added at start,
+	// whatever the trip measured.
+	if ride.FareQuoteID != nil {
+		return &actual, nil
+	}
+
+	breakdown, err := h.fares.Reprice(ride.FareBreakdown, fare.Input{
+		Distance:     actual.Distance,
+		Duration:     actual.Duration,
+		VehicleClass: ride.VehicleClass,
+		Zone:         ride.Zone,
+		At:           ride.CreatedAt,
+	})
+	if err != nil {
+		return nil, err
+	}
+	if ride.WaitCharge > 0 {
+		fare.AddItem(breakdown, "waiting", fmt.Sprintf("Waiting %d min × %.2f", ride.WaitMinutes, h.cfg.WaitChargePerMinute), ride.WaitCharge)
+	}
+	actual.Fare = breakdown.Total
+	actual.FareBreakdown = breakdown
+	return &actual, nil
+}
+
+// GetRoute returns the ride's GPS trail to its rider or driver, as a GeoJSON
+// Feature by default or an encoded polyline with ?format=polyline.
+func (h *RideHandler) GetRoute(w http.ResponseWriter, r *http.Request) {
+	userID := middleware.GetUserID(r.Context())
+	userType := middleware.GetUserType(r.Context())
+	rideID := chi.URLParam(r, "id")
+
+	var ride models.Ride
+	if err := h.db.First(&ride, "id = ?", rideID).Error; err != nil {
+		respondJSON(w, http.StatusNotFound, map[string]string{"error": "ride not found"})
 		return
 	}
 
-	h.logHistory(ride.ID, "completed", fmt.Sprintf("completed by driver %s", driver.ID))
+	authorized := userType == "rider" && ride.RiderID == userID
+	if userType == "driver" && ride.DriverID != nil {
+		var driver models.Driver
+		authorized = h.db.First(&driver, "id = ? AND user_id = ?", *ride.DriverID, userID).Error == nil
+	}
+	if !authorized {
+		respondJSON(w, http.StatusForbidden, map[string]string{"error": "not authorized"})
+		return
+	}
 
-	driver.IsAvailable = true
-	driver.TotalRides += 1
-	h.db.Save(&driver)
+	points, err := h.trail.Points(r.Context(), ride.ID)
+	if err != nil {
+		respondJSON(w, http.StatusInternalServerError, map[string]string{"error": "failed to fetch route"})
+		return
+	}
 
-	respondJSON(w, http.StatusOK, ride)
+	properties := map[string]interface{}{
+		"ride_id":  ride.ID,
+		"points":   len(points),
+		"distance": math.Round(trail.Distance(points)*100) / 100,
+	}
+
+	switch r.URL.Query().Get("format") {
+	case "polyline":
+		properties["polyline"] = trail.Encode(points)
+		respondJSON(w, http.StatusOK, properties)
+	case "", "geojson":
+		coordinates := make([][2]float64, 0, len(points))
+		timestamps := make([]time.Time, 0, len(points))
+		for _, p := range points {
+			coordinates = append(coordinates, [2]float64{p.Lng, p.Lat})
+			timestamps = append(timestamps, p.RecordedAt)
+		}
+		properties["timestamps"] = timestamps
+		respondJSON(w, http.StatusOK, map[string]interface{}{
+			"type": "Feature",
+			"geometry": map[string]interface{}{
+				"type":        "LineString",
+				"coordinates": coordinates,
+			},
+			"properties": properties,
+		})
+	default:
+		respondJSON(w, http.StatusBadRequest, map[string]string{"error": "format must be geojson or polyline"})
+	}
+}
+
+type CancelRideRequest struct {
+	Reason string `json:"reason"`
+	Note   string `json:"note"`
+}
+
+// CancellationReasons lists the reason codes riders and drivers can cancel
+// with.
+func (h *RideHandler) CancellationReasons(w http.ResponseWriter, r *http.Request) {
+	respondJSON(w, http.StatusOK, map[string][]string{
+		"rider":  cancellation.Reasons(ridestate.Rider),
+		"driver": cancellation.Reasons(ridestate.Driver),
+	})
 }
 
 func (h *RideHandler) CancelRide(w http.ResponseWriter, r *http.Request) {
 	rideID := chi.URLParam(r, "id")
 	userID := middleware.GetUserID(r.Context())
 
+	var req CancelRideRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		respondJSON(w, http.StatusBadRequest, map[string]string{"error": "invalid request"})
+		return
+	}
+
 	var ride models.Ride
 	if err := h.db.First(&ride, "id = ?", rideID).Error; err != nil {
 		respondJSON(w, http.StatusNotFound, map[string]string{"error": "ride not found"})
 		return
 	}
 
+	var driver *models.Driver
+	if ride.DriverID != nil {
+		var d models.Driver
+		if err := h.db.First(&d, "id = ?", *ride.DriverID).Error; err == nil {
+			driver = &d
+		}
+	}
+
+	actor, actorID := ridestate.Rider, userID
 	if ride.RiderID != userID {
-		var driver models.Driver
-		if err := h.db.Where("user_id = ?", userID).First(&driver).Error; err == nil {
-			if ride.DriverID == nil || *ride.DriverID != driver.ID {
-				respondJSON(w, http.StatusForbidden, map[string]string{"error": "not authorized"})
-				return
-			}
-		} else {
+		if driver == nil || driver.UserID != userID {
 			respondJSON(w, http.StatusForbidden, map[string]string{"error": "not authorized"})
 			return
 		}
+		actor, actorID = ridestate.Driver, driver.ID
 	}
 
-	if ride.Status == "completed" || ride.Status == "cancelled" {
-		respondJSON(w, http.StatusBadRequest, map[string]string{"error": "cannot cancel completed or already cancelled ride"})
+	if !cancellation.ValidReason(actor, req.Reason) {
+		respondJSON(w, http.StatusBadRequest, map[string]interface{}{
+			"error":   "invalid cancellation reason",
+			"reasons": cancellation.Reasons(actor),
+		})
 		return
 	}
 
-	ride.Status = "cancelled"
+	fee, err := h.cancellation.Fee(&ride, actor, req.Reason, time.Now())
+	if errors.Is(err, cancellation.ErrNotArrived) {
+		respondJSON(w, http.StatusConflict, map[string]string{"error": err.Error(), "code": "not_arrived"})
+		return
+	}
+	if errors.Is(err, cancellation.ErrNoShowTooEarly) {
+		respondJSON(w, http.StatusConflict, map[string]string{"error": err.Error(), "code": "no_show_too_early"})
+		return
+	}
 
-	if err := h.db.Save(&ride).Error; err != nil {
-		respondJSON(w, http.StatusInternalServerError, map[string]string{"error": "failed to cancel ride"})
+	if err := h.cancel(r.Context(), &ride, driver, actor, actorID, req.Reason, req.Note, fee); err != nil {
+		respondTransitionError(w, err, "failed to cancel ride")
 		return
 	}
+	respondJSON(w, http.StatusOK, ride)
+}
 
-	note := fmt.Sprintf("cancelled by user %s", userID)
+// cancel cancels the ride for reason, charging fee or voiding the payment,
+// and frees its driver, if any. ride is reloaded on success.
+func (h *RideHandler) cancel(ctx context.Context, ride *models.Ride, driver *models.Driver, actor ridestate.Actor, actorID, reason, comment string, fee float64) error {
+	note := fmt.Sprintf("cancelled by %s %s", actor, actorID)
 	if ride.DriverID != nil {
-		note = fmt.Sprintf("cancelled; driver %s released", *ride.DriverID)
+		note += fmt.Sprintf("; driver %s released", *ride.DriverID)
+	}
+	if comment != "" {
+		note += ": " + comment
 	}
-	h.logHistory(ride.ID, "cancelled", note)
 
-	if ride.DriverID != nil {
-		var driver models.Driver
-		if err := h.db.First(&driver, "id = ?", *ride.DriverID).Error; err == nil {
-			driver.IsAvailable = true
-			h.db.Save(&driver)
+	db := audit.DB(ctx, h.db)
+	var released bool
+	err := db.Transaction(func(tx *gorm.DB) error {
+		if err := h.machine.FireWithReason(tx, ride, ridestate.Cancel, actor, actorID, reason,
+			map[string]interface{}{
+				"cancel_reason":    reason,
+				"cancelled_by":     string(actor),
+				"cancellation_fee": fee,
+			}, note); err != nil {
+			return err
+		}
+		ride.CancellationFee = fee
+
+		if fee > 0 {
+			if err := h.earnings.Record(tx, ride); err != nil {
+				return err
+			}
+		} else if err := h.payments.Void(tx, ride.ID); err != nil {
+			return err
+		}
+
+		if ride.DriverID == nil {
+			return nil
+		}
+		if actor == ridestate.Driver {
+			if err := h.cancellation.UpdateDriverStats(tx, *ride.DriverID); err != nil {
+				return err
+			}
 		}
+		var err error
+		released, err = releaseDriver(tx, *ride.DriverID)
+		return err
+	})
+	if err != nil {
+		return err
 	}
 
-	respondJSON(w, http.StatusOK, ride)
+	audit.AfterCommit(ctx, func() {
+		ctx := context.Background()
+		h.events.PublishStatus(ctx, ride.ID, ride.Status)
+		h.trail.Finish(ctx, ride.ID)
+
+		if driver != nil && released {
+			withLivePosition(ctx, h.locations, driver)
+			if hasPosition(driver) {
+				h.locations.Add(ctx, driver.ID, driver.CurrentLat, driver.CurrentLng)
+			}
+		}
+
+		if fee > 0 {
+			if _, err := h.payments.Settle(ctx, ride.ID); err != nil {
+				log.Printf("payments: settling cancellation fee for ride %s: %v", ride.ID, err)
+			}
+		}
+	})
+
+	db.First(ride, "id = ?", ride.ID)
+	return nil
 }
 
 func (h *RideHandler) RateRide(w http.ResponseWriter, r *http.Request) {
@@ -360,53 +1162,100 @@ func (h *RideHandler) RateRide(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	if ride.RiderID != userID {
-		respondJSON(w, http.StatusForbidden, map[string]string{"error": "not authorized"})
-		return
-	}
+	var rating *models.Rating
+	err := h.db.Transaction(func(tx *gorm.DB) error {
+		// Firing Rate locks the ride row, so concurrent ratings are
+		// serialised before the duplicate check below.
+		if err := h.machine.Fire(tx, &ride, ridestate.Rate, ridestate.Rider, userID, nil, ""); err != nil {
+			return err
+		}
 
-	if ride.Status != "completed" {
-		respondJSON(w, http.StatusBadRequest, map[string]string{"error": "can only rate completed rides"})
-		return
-	}
+		var existing int64
+		if err := tx.Model(&models.Rating{}).Where("ride_id = ?", ride.ID).Count(&existing).Error; err != nil {
+			return err
+		}
+		if existing > 0 {
+			return errRideAlreadyRated
+		}
+
+		rating = &models.Rating{
+			RideID:   ride.ID,
+			RiderID:  ride.RiderID,
+			DriverID: *ride.DriverID,
+			Rating:   req.Rating,
+			Comment:  req.Comment,
+		}
+		if err := tx.Create(rating).Error; err != nil {
+			return err
+		}
 
-	if ride.DriverID == nil {
-		respondJSON(w, http.StatusBadRequest, map[string]string{"error": "ride not assigned to a driver"})
+		return tx.Model(&models.Driver{}).Where("id = ?", *ride.DriverID).
+			Update("rating", gorm.Expr("(SELECT ROUND(AVG(rating)::numeric, 2) FROM ratings WHERE driver_id = ?)", *ride.DriverID)).Error
+	})
+	if err != nil {
+		respondTransitionError(w, err, "failed to rate ride")
 		return
 	}
 
-	rating := &models.Rating{
-		RideID:   ride.ID,
-		RiderID:  ride.RiderID,
-		DriverID: *ride.DriverID,
-		Rating:   req.Rating,
-		Comment:  req.Comment,
-	}
+	respondJSON(w, http.StatusCreated, rating)
+}
 
-	if err := h.db.Create(rating).Error; err != nil {
-		respondJSON(w, http.StatusConflict, map[string]string{"error": "ride already rated"})
-		return
-	}
+// quoteMatchKm is how far the requested pickup or dropoff may drift from the
+// quoted one before the quote no longer applies.
+const quoteMatchKm = 0.05
 
-	var driver models.Driver
-	if err := h.db.First(&driver, "id = ?", *ride.DriverID).Error; err == nil {
-		var avgRating float64
-		h.db.Model(&models.Rating{}).Where("driver_id = ?", driver.ID).Select("AVG(rating)").Scan(&avgRating)
-		driver.Rating = math.Round(avgRating*100) / 100
-		h.db.Save(&driver)
-	}
+var (
+	errRideAlreadyRated = errors.New("ride already rated")
+	errQuoteUsed        = errors.New("fare quote already used")
+)
 
-	respondJSON(w, http.StatusCreated, rating)
+// releaseDriver puts a driver who is done with a ride back online, unless
+// they were suspended or banned in the meantime. It reports whether they
+// went online, so callers know whether to return them to the location
+// index.
+func releaseDriver(tx *gorm.DB, driverID string) (bool, error) {
+	res := tx.Model(&models.Driver{}).
+		Where("id = ?", driverID).
+		Scopes(notBlocked(time.Now())).
+		Update("is_available", true)
+	return res.RowsAffected > 0, res.Error
 }
 
-func (h *RideHandler) logHistory(rideID, status, note string) {
-	_ = h.db.Create(&models.RideHistory{RideID: rideID, Status: status, Note: note}).Error
+// notBlocked limits a driver query to the drivers models.Driver.Blocked
+// allows to work at now.
+func notBlocked(now time.Time) func(*gorm.DB) *gorm.DB {
+	return func(db *gorm.DB) *gorm.DB {
+		return db.Where("status = ? OR (status = ? AND suspended_until <= ?)",
+			models.DriverActive, models.DriverSuspended, now)
+	}
 }
 
-func calculateFare(distance float64) float64 {
-	baseFare := 20.0
-	perKmRate := 30.0
-	return math.Round((baseFare+distance*perKmRate)*100) / 100
+func respondTransitionError(w http.ResponseWriter, err error, message string) {
+	var stateErr *ridestate.Error
+	switch {
+	case errors.As(err, &stateErr):
+		status := http.StatusConflict
+		if stateErr.Code == ridestate.CodeActorNotAllowed || stateErr.Code == ridestate.CodeNotParticipant {
+			status = http.StatusForbidden
+		}
+		respondJSON(w, status, map[string]string{"error": stateErr.Message, "code": stateErr.Code})
+	case errors.Is(err, payments.ErrInsufficientFunds):
+		respondJSON(w, http.StatusPaymentRequired, map[string]string{"error": "rider has insufficient wallet balance", "code": "insufficient_funds"})
+	case errors.Is(err, errRideAlreadyRated):
+		respondJSON(w, http.StatusConflict, map[string]string{"error": "ride already rated", "code": "already_rated"})
+	case errors.Is(err, gorm.ErrRecordNotFound):
+		respondJSON(w, http.StatusNotFound, map[string]string{"error": "ride not found"})
+	default:
+		respondJSON(w, http.StatusInternalServerError, map[string]string{"error": message})
+	}
+}
+
+func respondFareError(w http.ResponseWriter, err error) {
+	if errors.Is(err, fare.ErrNoTariff) {
+		respondJSON(w, http.StatusUnprocessableEntity, map[string]string{"error": err.Error()})
+		return
+	}
+	respondJSON(w, http.StatusInternalServerError, map[string]string{"error": "failed to calculate fare"})
 }
 
 func (h *RideHandler) CreateFare(w http.ResponseWriter, r *http.Request) {
@@ -424,11 +1273,30 @@ func (h *RideHandler) CreateFare(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	distance := haversine(req.PickupLat, req.PickupLng, req.DropoffLat, req.DropoffLng)
+	if req.VehicleClass == "" {
+		req.VehicleClass = fare.DefaultVehicleClass
+	}
+
+	zone, ok := locateTrip(w, h.zones, req.PickupLat, req.PickupLng, req.DropoffLat, req.DropoffLng)
+	if !ok {
+		return
+	}
+
+	distance := geo.Distance(req.PickupLat, req.PickupLng, req.DropoffLat, req.DropoffLng)
 	duration := int(distance / 0.5)
-	fare := calculateFare(distance)
+	breakdown, err := h.fares.Quote(fare.Input{
+		Distance:     distance,
+		Duration:     duration,
+		VehicleClass: req.VehicleClass,
+		Zone:         zone,
+		Surge:        h.surge.MultiplierAt(r.Context(), req.PickupLat, req.PickupLng),
+	})
+	if err != nil {
+		respondFareError(w, err)
+		return
+	}
 
-	ride := &models.Ride{
+	quote := &models.FareQuote{
 		RiderID:        userID,
 		PickupLat:      req.PickupLat,
 		PickupLng:      req.PickupLng,
@@ -436,11 +1304,19 @@ func (h *RideHandler) CreateFare(w http.ResponseWriter, r *http.Request) {
 		DropoffLat:     req.DropoffLat,
 		DropoffLng:     req.DropoffLng,
 		DropoffAddress: req.DropoffAddress,
-		Status:         "FARE_ESTIMATED",
-		Fare:           fare,
+		VehicleClass:   req.VehicleClass,
+		Zone:           zone,
+		Fare:           breakdown.Total,
+		FareBreakdown:  breakdown,
 		Distance:       distance,
 		Duration:       duration,
+		ExpiresAt:      time.Now().Add(h.cfg.FareQuoteTTL),
 	}
 
-	respondJSON(w, http.StatusCreated, ride)
+	if err := h.db.Create(quote).Error; err != nil {
+		respondJSON(w, http.StatusInternalServerError, map[string]string{"error": "failed to save fare quote"})
+		return
+	}
+
+	respondJSON(w, http.StatusCreated, quote)
 }
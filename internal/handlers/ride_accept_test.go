@@ -0,0 +1,241 @@
+package handlers
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"sync"
+	"testing"
+	"time"
+
+	"rickshaw-app/internal/config"
+	"rickshaw-app/internal/database"
+	"rickshaw-app/internal/dispatch"
+	"rickshaw-app/internal/middleware"
+	"rickshaw-app/internal/models"
+	"rickshaw-app/internal/redis"
+	"rickshaw-app/internal/ridestate"
+
+	"github.com/go-chi/chi/v5"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// TestAcceptRideRace has two drivers accept the same ride at once and
+// checks that exactly one of them gets it. It needs a migrated database in
+// TEST_DATABASE_URL; Redis is taken from TEST_REDIS_URL if set.
+func TestAcceptRideRace(t *testing.T) {
+	db, h := acceptTestHandler(t)
+
+	rider := newTestUser(t, db, "rider", 0)
+	var drivers [2]models.Driver
+	for i := range drivers {
+		drivers[i] = newTestDriver(t, db, i+1)
+	}
+	ride := newTestRide(t, db, rider)
+
+	// Hold the ride row so both requests read it as requested and then
+	// queue on the conditional update; releasing it lets them race.
+	blocker := db.Begin()
+	if err := blocker.Clauses(clause.Locking{Strength: "UPDATE"}).First(&models.Ride{}, "id = ?", ride.ID).Error; err != nil {
+		blocker.Rollback()
+		t.Fatal(err)
+	}
+
+	var wg sync.WaitGroup
+	results := make([]*httptest.ResponseRecorder, len(drivers))
+	for i, d := range drivers {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			results[i] = acceptAs(h, ride.ID, d.UserID)
+		}()
+	}
+
+	waitForLockWaiters(t, db, len(drivers))
+	blocker.Commit()
+	wg.Wait()
+
+	winner := -1
+	for i, rec := range results {
+		switch rec.Code {
+		case http.StatusOK:
+			if winner >= 0 {
+				t.Fatalf("both drivers accepted the ride")
+			}
+			winner = i
+		case http.StatusConflict:
+			var body map[string]string
+			json.Unmarshal(rec.Body.Bytes(), &body)
+			if body["code"] != ridestate.CodeConflict {
+				t.Errorf("loser got code %q, want %q", body["code"], ridestate.CodeConflict)
+			}
+		default:
+			t.Fatalf("driver %d got %d: %s", i, rec.Code, rec.Body.String())
+		}
+	}
+	if winner < 0 {
+		t.Fatal("neither driver accepted the ride")
+	}
+
+	var got models.Ride
+	if err := db.First(&got, "id = ?", ride.ID).Error; err != nil {
+		t.Fatal(err)
+	}
+	if got.DriverID == nil || *got.DriverID != drivers[winner].ID {
+		t.Errorf("ride driver_id = %v, want %s", got.DriverID, drivers[winner].ID)
+	}
+	if got.Status != string(ridestate.Accepted) {
+		t.Errorf("ride status = %s, want accepted", got.Status)
+	}
+}
+
+// TestAcceptTwoRides has one driver accept two rides at once and checks
+// that they end up holding only one of them.
+func TestAcceptTwoRides(t *testing.T) {
+	db, h := acceptTestHandler(t)
+
+	rider := newTestUser(t, db, "rider", 0)
+	driver := newTestDriver(t, db, 1)
+	rides := [2]models.Ride{newTestRide(t, db, rider), newTestRide(t, db, rider)}
+
+	var wg sync.WaitGroup
+	results := make([]*httptest.ResponseRecorder, len(rides))
+	for i, ride := range rides {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			results[i] = acceptAs(h, ride.ID, driver.UserID)
+		}()
+	}
+	wg.Wait()
+
+	accepted := 0
+	for i, rec := range results {
+		switch rec.Code {
+		case http.StatusOK:
+			accepted++
+		case http.StatusConflict:
+			var body map[string]string
+			json.Unmarshal(rec.Body.Bytes(), &body)
+			if body["code"] != "driver_unavailable" {
+				t.Errorf("ride %d got code %q, want driver_unavailable", i, body["code"])
+			}
+		default:
+			t.Fatalf("ride %d got %d: %s", i, rec.Code, rec.Body.String())
+		}
+	}
+	if accepted != 1 {
+		t.Fatalf("driver accepted %d rides, want 1", accepted)
+	}
+
+	var held int64
+	if err := db.Model(&models.Ride{}).
+		Where("driver_id = ? AND status = ?", driver.ID, ridestate.Accepted).
+		Count(&held).Error; err != nil {
+		t.Fatal(err)
+	}
+	if held != 1 {
+		t.Errorf("driver holds %d accepted rides, want 1", held)
+	}
+
+	// With one ride in hand the driver is unavailable, so a later accept
+	// is refused too.
+	third := newTestRide(t, db, rider)
+	if rec := acceptAs(h, third.ID, driver.UserID); rec.Code != http.StatusConflict {
+		t.Errorf("third accept got %d, want %d", rec.Code, http.StatusConflict)
+	}
+}
+
+// acceptTestHandler connects to the test database and Redis, skipping the
+// test when no database is configured.
+func acceptTestHandler(t *testing.T) (*gorm.DB, *RideHandler) {
+	t.Helper()
+	dsn := os.Getenv("TEST_DATABASE_URL")
+	if dsn == "" {
+		t.Skip("TEST_DATABASE_URL not set")
+	}
+	db, err := database.Connect(dsn)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cfg := config.Load()
+	if addr := os.Getenv("TEST_REDIS_URL"); addr != "" {
+		cfg.RedisURL = addr
+	}
+	rdb := redis.Connect(cfg.RedisURL)
+	return db, NewRideHandler(db, rdb, cfg, dispatch.NewDispatcher(context.Background(), db, rdb, cfg))
+}
+
+func newTestUser(t *testing.T, db *gorm.DB, userType string, n int) models.User {
+	t.Helper()
+	user := models.User{
+		Name:     "race " + userType,
+		Phone:    fmt.Sprintf("+1%09d%d", time.Now().UnixNano()%1e9, n),
+		Password: "x",
+		UserType: userType,
+	}
+	mustCreate(t, db, &user)
+	t.Cleanup(func() { db.Delete(&user) })
+	return user
+}
+
+func newTestDriver(t *testing.T, db *gorm.DB, n int) models.Driver {
+	t.Helper()
+	user := newTestUser(t, db, "driver", n)
+	driver := models.Driver{UserID: user.ID, VehicleNumber: "RACE", LicenseNumber: "RACE", IsAvailable: true}
+	mustCreate(t, db, &driver)
+	t.Cleanup(func() { db.Delete(&driver) })
+	return driver
+}
+
+func newTestRide(t *testing.T, db *gorm.DB, rider models.User) models.Ride {
+	t.Helper()
+	ride := models.Ride{RiderID: rider.ID, Status: string(ridestate.Requested), PaymentMethod: "cash"}
+	mustCreate(t, db, &ride)
+	t.Cleanup(func() {
+		db.Where("ride_id = ?", ride.ID).Delete(&models.RideHistory{})
+		db.Delete(&ride)
+	})
+	return ride
+}
+
+func acceptAs(h *RideHandler, rideID, userID string) *httptest.ResponseRecorder {
+	rctx := chi.NewRouteContext()
+	rctx.URLParams.Add("id", rideID)
+	ctx := context.WithValue(context.Background(), chi.RouteCtxKey, rctx)
+	ctx = context.WithValue(ctx, middleware.UserIDKey, userID)
+	ctx = context.WithValue(ctx, middleware.UserTypeKey, "driver")
+
+	req := httptest.NewRequest(http.MethodPost, "/api/rides/"+rideID+"/accept", nil).WithContext(ctx)
+	rec := httptest.NewRecorder()
+	h.AcceptRide(rec, req)
+	return rec
+}
+
+// waitForLockWaiters waits until n other backends are blocked on a lock.
+func waitForLockWaiters(t *testing.T, db *gorm.DB, n int) {
+	t.Helper()
+	deadline := time.Now().Add(10 * time.Second)
+	for time.Now().Before(deadline) {
+		var waiting int64
+		if err := db.Raw("SELECT COUNT(DISTINCT pid) FROM pg_locks WHERE NOT granted").Scan(&waiting).Error; err != nil {
+			t.Fatal(err)
+		}
+		if waiting >= int64(n) {
+			return
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	t.Fatalf("timed out waiting for %d requests to block on the ride", n)
+}
+
+func mustCreate(t *testing.T, db *gorm.DB, v any) {
+	t.Helper()
+	if err := db.Create(v).Error; err != nil {
+		t.Fatal(err)
+	}
+}
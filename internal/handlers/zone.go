@@ -0,0 +1,88 @@
+package handlers
+
+import (
+	"net/http"
+
+	"rickshaw-app/internal/models"
+	"rickshaw-app/internal/zones"
+
+	"gorm.io/gorm"
+)
+
+type ZoneHandler struct {
+	zones *zones.Service
+}
+
+func NewZoneHandler(db *gorm.DB) *ZoneHandler {
+	return &ZoneHandler{zones: zones.NewService(db)}
+}
+
+type zoneFeature struct {
+	Type       string            `json:"type"`
+	Geometry   models.Geometry   `json:"geometry"`
+	Properties map[string]string `json:"properties"`
+}
+
+// ServiceArea returns the enabled zones as a GeoJSON FeatureCollection so
+// apps can draw where rides can be requested.
+func (h *ZoneHandler) ServiceArea(w http.ResponseWriter, r *http.Request) {
+	enabled, err := h.zones.Enabled()
+	if err != nil {
+		respondJSON(w, http.StatusInternalServerError, map[string]string{"error": "failed to fetch service area"})
+		return
+	}
+
+	features := make([]zoneFeature, 0, len(enabled))
+	for _, zone := range enabled {
+		features = append(features, zoneFeature{
+			Type:       "Feature",
+			Geometry:   zone.Geometry,
+			Properties: map[string]string{"key": zone.Key, "name": zone.Name},
+		})
+	}
+
+	respondJSON(w, http.StatusOK, map[string]interface{}{
+		"type":     "FeatureCollection",
+		"features": features,
+	})
+}
+
+// locateTrip validates the pickup and dropoff coordinates and checks both
+// lie inside an enabled service zone. It returns the pickup zone key, which
+// is empty when no zones are configured.
+func locateTrip(w http.ResponseWriter, svc *zones.Service, pickupLat, pickupLng, dropoffLat, dropoffLng float64) (string, bool) {
+	if !validCoordinate(pickupLat, pickupLng) || !validCoordinate(dropoffLat, dropoffLng) {
+		respondJSON(w, http.StatusBadRequest, map[string]string{"error": "pickup and dropoff must be valid coordinates"})
+		return "", false
+	}
+
+	pickupZone, err := svc.Locate(pickupLat, pickupLng)
+	if err == zones.ErrOutsideServiceArea {
+		respondJSON(w, http.StatusUnprocessableEntity, map[string]string{"error": "pickup is outside the service area", "code": "outside_service_area"})
+		return "", false
+	}
+	if err != nil {
+		respondJSON(w, http.StatusInternalServerError, map[string]string{"error": "failed to check service area"})
+		return "", false
+	}
+
+	if _, err := svc.Locate(dropoffLat, dropoffLng); err == zones.ErrOutsideServiceArea {
+		respondJSON(w, http.StatusUnprocessableEntity, map[string]string{"error": "dropoff is outside the service area", "code": "outside_service_area"})
+		return "", false
+	} else if err != nil {
+		respondJSON(w, http.StatusInternalServerError, map[string]string{"error": "failed to check service area"})
+		return "", false
+	}
+
+	if pickupZone == nil {
+		return "", true
+	}
+	return pickupZone.Key, true
+}
+
+func validCoordinate(lat, lng float64) bool {
+	if lat == 0 && lng == 0 {
+		return false
+	}
+	return lat >= -90 && lat <= 90 && lng >= -180 && lng <= 180
+}
@@ -6,6 +6,8 @@ import (
 	"strings"
 	"time"
 
+	"rickshaw-app/internal/session"
+
 	"github.com/golang-jwt/jwt/v5"
 )
 
@@ -13,6 +15,7 @@ type contextKey string
 
 const UserIDKey contextKey = "user_id"
 const UserTypeKey contextKey = "user_type"
+const SessionIDKey contextKey = "session_id"
 
 type Claims struct {
 	UserID   string `json:"user_id"`
@@ -20,12 +23,14 @@ type Claims struct {
 	jwt.RegisteredClaims
 }
 
-func GenerateToken(userID, userType, secret string) (string, error) {
+// GenerateToken issues an access token bound to sessionID, which becomes its jti.
+func GenerateToken(userID, userType, sessionID, secret string, ttl time.Duration) (string, error) {
 	claims := &Claims{
 		UserID:   userID,
 		UserType: userType,
 		RegisteredClaims: jwt.RegisteredClaims{
-			ExpiresAt: jwt.NewNumericDate(time.Now().Add(24 * time.Hour)),
+			ID:        sessionID,
+			ExpiresAt: jwt.NewNumericDate(time.Now().Add(ttl)),
 			IssuedAt:  jwt.NewNumericDate(time.Now()),
 		},
 	}
@@ -34,7 +39,7 @@ func GenerateToken(userID, userType, secret string) (string, error) {
 	return token.SignedString([]byte(secret))
 }
 
-func AuthMiddleware(secret string) func(http.Handler) http.Handler {
+func AuthMiddleware(secret string, sessions *session.Store) func(http.Handler) http.Handler {
 	return func(next http.Handler) http.Handler {
 		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 			authHeader := r.Header.Get("Authorization")
@@ -54,13 +59,24 @@ func AuthMiddleware(secret string) func(http.Handler) http.Handler {
 				return []byte(secret), nil
 			})
 
-			if err != nil || !token.Valid {
+			if err != nil || !token.Valid || claims.ID == "" {
 				http.Error(w, `{"error":"invalid token"}`, http.StatusUnauthorized)
 				return
 			}
 
+			active, err := sessions.Exists(r.Context(), claims.UserID, claims.ID)
+			if err != nil {
+				http.Error(w, `{"error":"session store unavailable"}`, http.StatusServiceUnavailable)
+				return
+			}
+			if !active {
+				http.Error(w, `{"error":"session revoked"}`, http.StatusUnauthorized)
+				return
+			}
+
 			ctx := context.WithValue(r.Context(), UserIDKey, claims.UserID)
 			ctx = context.WithValue(ctx, UserTypeKey, claims.UserType)
+			ctx = context.WithValue(ctx, SessionIDKey, claims.ID)
 			next.ServeHTTP(w, r.WithContext(ctx))
 		})
 	}
@@ -79,3 +95,10 @@ func GetUserType(ctx context.Context) string {
 	}
 	return ""
 }
+
+func GetSessionID(ctx context.Context) string {
+	if sessionID, ok := ctx.Value(SessionIDKey).(string); ok {
+		return sessionID
+	}
+	return ""
+}
@@ -1,51 +1,105 @@
 package models
 
 import (
+	"database/sql/driver"
+	"encoding/json"
+	"fmt"
 	"time"
 )
 
 type User struct {
-	ID        string    `gorm:"primaryKey;type:uuid;default:gen_random_uuid()" json:"id"`
-	Name      string    `gorm:"not null" json:"name"`
-	Phone     string    `gorm:"uniqueIndex;not null" json:"phone"`
-	Password  string    `gorm:"not null" json:"-"`
-	UserType  string    `gorm:"not null" json:"user_type"` // "rider" or "driver"
-	CreatedAt time.Time `json:"created_at"`
-	UpdatedAt time.Time `json:"updated_at"`
-}
-
-type Driver struct {
 	ID            string    `gorm:"primaryKey;type:uuid;default:gen_random_uuid()" json:"id"`
-	UserID        string    `gorm:"not null;index" json:"user_id"`
-	VehicleNumber string    `gorm:"not null" json:"vehicle_number"`
-	VehicleModel  string    `json:"vehicle_model"`
-	LicenseNumber string    `gorm:"not null" json:"license_number"`
-	IsAvailable   bool      `gorm:"default:true" json:"is_available"`
-	CurrentLat    float64   `json:"current_lat"`
-	CurrentLng    float64   `json:"current_lng"`
-	Rating        float64   `gorm:"default:5.0" json:"rating"`
-	TotalRides    int       `gorm:"default:0" json:"total_rides"`
+	Name          string    `gorm:"not null" json:"name"`
+	Phone         string    `gorm:"uniqueIndex;not null" json:"phone"`
+	PhoneVerified bool      `gorm:"default:false" json:"phone_verified"`
+	Password      string    `gorm:"not null" json:"-"`
+	UserType      string    `gorm:"not null" json:"user_type"` // "rider" or "driver"
 	CreatedAt     time.Time `json:"created_at"`
 	UpdatedAt     time.Time `json:"updated_at"`
 }
 
-type Ride struct {
-	ID             string     `gorm:"primaryKey;type:uuid;default:gen_random_uuid()" json:"id"`
-	RiderID        string     `gorm:"not null;index" json:"rider_id"`
-	DriverID       *string    `gorm:"index" json:"driver_id,omitempty"`
-	PickupLat      float64    `gorm:"not null" json:"pickup_lat"`
-	PickupLng      float64    `gorm:"not null" json:"pickup_lng"`
-	PickupAddress  string     `json:"pickup_address"`
-	DropoffLat     float64    `gorm:"not null" json:"dropoff_lat"`
-	DropoffLng     float64    `gorm:"not null" json:"dropoff_lng"`
-	DropoffAddress string     `json:"dropoff_address"`
-	Status         string     `gorm:"not null;default:'requested'" json:"status"` // requested, accepted, started, completed, cancelled
-	Fare           float64    `json:"fare"`
-	Distance       float64    `json:"distance"` // in km
-	Duration       int        `json:"duration"` // in minutes
+type Driver struct {
+	ID            string  `gorm:"primaryKey;type:uuid;default:gen_random_uuid()" json:"id"`
+	UserID        string  `gorm:"not null;index" json:"user_id"`
+	VehicleNumber string  `gorm:"not null" json:"vehicle_number"`
+	VehicleModel  string  `json:"vehicle_model"`
+	LicenseNumber string  `gorm:"not null" json:"license_number"`
+	IsAvailable   bool    `gorm:"default:true" json:"is_available"`
+	CurrentLat    float64 `json:"current_lat"`
+	CurrentLng    float64 `json:"current_lng"`
+	// LocationUpdatedAt is when CurrentLat/CurrentLng were last persisted.
+	// Writes are throttled; the live position is kept in Redis.
+	LocationUpdatedAt *time.Time `json:"location_updated_at,omitempty"`
+	Rating            float64    `gorm:"default:5.0" json:"rating"`
+	TotalRides        int        `gorm:"default:0" json:"total_rides"`
+	// CancellationRate is the share of recently accepted rides the driver
+	// cancelled. CancellationFlagged is set when it crosses the policy limit.
+	CancellationRate    float64 `gorm:"not null;default:0" json:"cancellation_rate"`
+	CancellationFlagged bool    `gorm:"not null;default:false" json:"cancellation_flagged"`
+	// Status is active, suspended or banned. A suspension with an end date
+	// lapses on its own once SuspendedUntil has passed.
+	Status         string     `gorm:"not null;default:'active'" json:"status"`
+	StatusReason   string     `gorm:"not null;default:''" json:"status_reason,omitempty"`
+	SuspendedUntil *time.Time `json:"suspended_until,omitempty"`
 	CreatedAt      time.Time  `json:"created_at"`
 	UpdatedAt      time.Time  `json:"updated_at"`
-	CompletedAt    *time.Time `json:"completed_at,omitempty"`
+}
+
+const (
+	DriverActive    = "active"
+	DriverSuspended = "suspended"
+	DriverBanned    = "banned"
+)
+
+// Blocked reports whether the driver is barred from going online and taking
+// rides at now.
+func (d *Driver) Blocked(now time.Time) bool {
+	switch d.Status {
+	case DriverBanned:
+		return true
+	case DriverSuspended:
+		return d.SuspendedUntil == nil || now.Before(*d.SuspendedUntil)
+	default:
+		return false
+	}
+}
+
+type Ride struct {
+	ID                string         `gorm:"primaryKey;type:uuid;default:gen_random_uuid()" json:"id"`
+	RiderID           string         `gorm:"not null;index" json:"rider_id"`
+	DriverID          *string        `gorm:"index" json:"driver_id,omitempty"`
+	PickupLat         float64        `gorm:"not null" json:"pickup_lat"`
+	PickupLng         float64        `gorm:"not null" json:"pickup_lng"`
+	PickupAddress     string         `json:"pickup_address"`
+	DropoffLat        float64        `gorm:"not null" json:"dropoff_lat"`
+	DropoffLng        float64        `gorm:"not null" json:"dropoff_lng"`
+	DropoffAddress    string         `json:"dropoff_address"`
+	Status            string         `gorm:"not null;default:'requested'" json:"status"` // requested, accepted, arrived, started, completed, cancelled
+	VehicleClass      string         `gorm:"not null;default:'standard'" json:"vehicle_class"`
+	Zone              string         `gorm:"not null;default:''" json:"zone"`
+	Fare              float64        `json:"fare"`
+	FareBreakdown     *FareBreakdown `gorm:"type:jsonb" json:"fare_breakdown,omitempty"`
+	FareQuoteID       *string        `json:"fare_quote_id,omitempty"`
+	PaymentMethod     string         `gorm:"not null;default:'cash'" json:"payment_method"`
+	PaymentStatus     string         `gorm:"not null;default:'pending'" json:"payment_status"`
+	Distance          float64        `json:"distance"`                     // in km
+	Duration          int            `json:"duration"`                     // in minutes
+	EstimatedDistance *float64       `json:"estimated_distance,omitempty"` // booking-time estimate; Distance is actual once completed
+	EstimatedDuration *int           `json:"estimated_duration,omitempty"`
+	CancelReason      string         `gorm:"not null;default:''" json:"cancel_reason,omitempty"`
+	CancelledBy       string         `gorm:"not null;default:''" json:"cancelled_by,omitempty"`
+	CancellationFee   float64        `gorm:"not null;default:0" json:"cancellation_fee"`
+	WaitMinutes       int            `gorm:"not null;default:0" json:"wait_minutes"` // chargeable minutes at pickup
+	WaitCharge        float64        `gorm:"not null;default:0" json:"wait_charge"`
+	StartPIN          string         `gorm:"column:start_pin;not null;default:''" json:"-"` // shown to the rider only
+	StartPINAttempts  int            `gorm:"column:start_pin_attempts;not null;default:0" json:"-"`
+	CreatedAt         time.Time      `json:"created_at"`
+	UpdatedAt         time.Time      `json:"updated_at"`
+	AcceptedAt        *time.Time     `json:"accepted_at,omitempty"`
+	PickupETA         *time.Time     `gorm:"column:pickup_eta" json:"pickup_eta,omitempty"` // when the driver should reach the pickup
+	ArrivedAt         *time.Time     `json:"arrived_at,omitempty"`
+	StartedAt         *time.Time     `json:"started_at,omitempty"`
+	CompletedAt       *time.Time     `json:"completed_at,omitempty"`
 }
 
 type Rating struct {
@@ -62,6 +116,325 @@ type RideHistory struct {
 	ID        string    `gorm:"primaryKey;type:uuid;default:gen_random_uuid()" json:"id"`
 	RideID    string    `gorm:"not null;index" json:"ride_id"`
 	Status    string    `gorm:"not null" json:"status"`
+	Event     string    `gorm:"not null;default:''" json:"event"`
+	Actor     string    `gorm:"not null;default:''" json:"actor"`
+	ActorID   string    `gorm:"not null;default:''" json:"actor_id"`
+	Reason    string    `gorm:"not null;default:''" json:"reason,omitempty"`
 	Note      string    `json:"note"`
 	CreatedAt time.Time `json:"created_at"`
 }
+
+// RefreshToken is one link in a rotation chain. FamilyID is the session ID
+// shared by every token issued from the same login.
+type RefreshToken struct {
+	ID        string     `gorm:"primaryKey;type:uuid;default:gen_random_uuid()" json:"id"`
+	UserID    string     `gorm:"not null;index" json:"user_id"`
+	FamilyID  string     `gorm:"not null;index" json:"family_id"`
+	TokenHash string     `gorm:"not null;uniqueIndex" json:"-"`
+	UserAgent string     `json:"user_agent"`
+	IP        string     `json:"ip"`
+	ExpiresAt time.Time  `gorm:"not null" json:"expires_at"`
+	UsedAt    *time.Time `json:"used_at,omitempty"`
+	RevokedAt *time.Time `json:"revoked_at,omitempty"`
+	CreatedAt time.Time  `json:"created_at"`
+}
+
+type Tariff struct {
+	ID            string             `gorm:"primaryKey;type:uuid;default:gen_random_uuid()" json:"id"`
+	Name          string             `gorm:"not null" json:"name"`
+	VehicleClass  string             `gorm:"not null;default:'standard'" json:"vehicle_class"`
+	Zone          string             `gorm:"not null;default:''" json:"zone"` // empty applies everywhere
+	BaseFare      float64            `gorm:"not null" json:"base_fare"`
+	PerKm         float64            `gorm:"not null" json:"per_km"`
+	PerMinute     float64            `gorm:"not null" json:"per_minute"`
+	MinimumFare   float64            `gorm:"not null" json:"minimum_fare"`
+	BookingFee    float64            `gorm:"not null" json:"booking_fee"`
+	EffectiveFrom time.Time          `gorm:"not null" json:"effective_from"`
+	Multipliers   []TariffMultiplier `json:"multipliers"`
+	CreatedAt     time.Time          `json:"created_at"`
+}
+
+// TariffMultiplier scales the metered fare between StartHour (inclusive)
+// and EndHour (exclusive), in the operating timezone. Windows may wrap past
+// midnight.
+type TariffMultiplier struct {
+	ID         string  `gorm:"primaryKey;type:uuid;default:gen_random_uuid()" json:"id"`
+	TariffID   string  `gorm:"not null;index" json:"tariff_id"`
+	Name       string  `gorm:"not null" json:"name"`
+	StartHour  int     `gorm:"not null" json:"start_hour"`
+	EndHour    int     `gorm:"not null" json:"end_hour"`
+	Multiplier float64 `gorm:"not null" json:"multiplier"`
+}
+
+type FareLineItem struct {
+	Code   string  `json:"code"`
+	Label  string  `json:"label"`
+	Amount float64 `json:"amount"`
+}
+
+type FareBreakdown struct {
+	TariffID        string         `json:"tariff_id"`
+	SurgeMultiplier float64        `json:"surge_multiplier"`
+	Items           []FareLineItem `json:"items"`
+	Total           float64        `json:"total"`
+}
+
+func (b FareBreakdown) Value() (driver.Value, error) {
+	return json.Marshal(b)
+}
+
+func (b *FareBreakdown) Scan(value interface{}) error {
+	var data []byte
+	switch v := value.(type) {
+	case []byte:
+		data = v
+	case string:
+		data = []byte(v)
+	case nil:
+		return nil
+	default:
+		return fmt.Errorf("cannot scan %T into FareBreakdown", value)
+	}
+	return json.Unmarshal(data, b)
+}
+
+// FareQuote is a price promised to a rider for a specific trip. CreateRide
+// honours it until ExpiresAt; RideID is set once it has been used.
+type FareQuote struct {
+	ID             string         `gorm:"primaryKey;type:uuid;default:gen_random_uuid()" json:"id"`
+	RiderID        string         `gorm:"not null;index" json:"rider_id"`
+	PickupLat      float64        `gorm:"not null" json:"pickup_lat"`
+	PickupLng      float64        `gorm:"not null" json:"pickup_lng"`
+	PickupAddress  string         `json:"pickup_address"`
+	DropoffLat     float64        `gorm:"not null" json:"dropoff_lat"`
+	DropoffLng     float64        `gorm:"not null" json:"dropoff_lng"`
+	DropoffAddress string         `json:"dropoff_address"`
+	VehicleClass   string         `gorm:"not null;default:'standard'" json:"vehicle_class"`
+	Zone           string         `gorm:"not null;default:''" json:"zone"`
+	Fare           float64        `gorm:"not null" json:"fare"`
+	FareBreakdown  *FareBreakdown `gorm:"type:jsonb;not null" json:"fare_breakdown"`
+	Distance       float64        `gorm:"not null" json:"distance"` // in km
+	Duration       int            `gorm:"not null" json:"duration"` // in minutes
+	RideID         *string        `json:"ride_id,omitempty"`
+	ExpiresAt      time.Time      `gorm:"not null" json:"expires_at"`
+	CreatedAt      time.Time      `json:"created_at"`
+}
+
+// Geometry is a GeoJSON Polygon or MultiPolygon stored as JSONB.
+type Geometry struct {
+	Type        string          `json:"type"`
+	Coordinates json.RawMessage `json:"coordinates"`
+}
+
+func (g Geometry) Value() (driver.Value, error) {
+	return json.Marshal(g)
+}
+
+func (g *Geometry) Scan(value interface{}) error {
+	switch v := value.(type) {
+	case []byte:
+		return json.Unmarshal(v, g)
+	case string:
+		return json.Unmarshal([]byte(v), g)
+	default:
+		return fmt.Errorf("cannot scan %T into Geometry", value)
+	}
+}
+
+// ServiceZone is an area we operate in. Key is the stable identifier used
+// by tariffs and reporting.
+type ServiceZone struct {
+	ID        string    `gorm:"primaryKey;type:uuid;default:gen_random_uuid()" json:"id"`
+	Key       string    `gorm:"not null;uniqueIndex" json:"key"`
+	Name      string    `gorm:"not null" json:"name"`
+	Geometry  Geometry  `gorm:"type:jsonb;not null" json:"geometry"`
+	Enabled   bool      `gorm:"not null" json:"enabled"`
+	CreatedAt time.Time `json:"created_at"`
+	UpdatedAt time.Time `json:"updated_at"`
+}
+
+// Payment is the charge for one ride. Amount is in minor currency units.
+type Payment struct {
+	ID            string    `gorm:"primaryKey;type:uuid;default:gen_random_uuid()" json:"id"`
+	RideID        string    `gorm:"not null;uniqueIndex" json:"ride_id"`
+	RiderID       string    `gorm:"not null;index" json:"rider_id"`
+	Method        string    `gorm:"not null" json:"method"`
+	Provider      string    `gorm:"not null" json:"provider"`
+	ProviderToken string    `json:"-"`
+	ProviderRef   string    `json:"provider_ref,omitempty"`
+	Amount        int64     `gorm:"not null" json:"amount"`
+	Currency      string    `gorm:"not null" json:"currency"`
+	Status        string    `gorm:"not null;default:'pending'" json:"status"` // pending, processing, succeeded, failed, voided
+	FailureReason string    `json:"failure_reason,omitempty"`
+	CreatedAt     time.Time `json:"created_at"`
+	UpdatedAt     time.Time `json:"updated_at"`
+}
+
+// LedgerEntry is one leg of a double-entry transaction. Entries sharing a
+// TransactionID always sum to zero. Rows are never updated or deleted.
+type LedgerEntry struct {
+	ID            string    `gorm:"primaryKey;type:uuid;default:gen_random_uuid()" json:"id"`
+	TransactionID string    `gorm:"type:uuid;not null;index" json:"transaction_id"`
+	Account       string    `gorm:"not null;index" json:"account"`
+	Amount        int64     `gorm:"not null" json:"amount"`
+	Currency      string    `gorm:"not null" json:"currency"`
+	RideID        *string   `gorm:"index" json:"ride_id,omitempty"`
+	PaymentID     *string   `json:"payment_id,omitempty"`
+	Description   string    `json:"description"`
+	CreatedAt     time.Time `json:"created_at"`
+}
+
+// Wallet is a rider's prepaid balance in minor currency units. Held is the
+// part of Balance reserved for rides in progress.
+type Wallet struct {
+	ID        string    `gorm:"primaryKey;type:uuid;default:gen_random_uuid()" json:"id"`
+	UserID    string    `gorm:"not null;uniqueIndex" json:"user_id"`
+	Balance   int64     `gorm:"not null;default:0" json:"balance"`
+	Held      int64     `gorm:"not null;default:0" json:"held"`
+	Currency  string    `gorm:"not null" json:"currency"`
+	CreatedAt time.Time `json:"created_at"`
+	UpdatedAt time.Time `json:"updated_at"`
+}
+
+// WalletTransaction records one balance change together with the balance
+// and hold it left behind. IdempotencyKey makes every change apply once.
+type WalletTransaction struct {
+	ID             string    `gorm:"primaryKey;type:uuid;default:gen_random_uuid()" json:"id"`
+	WalletID       string    `gorm:"not null;index" json:"wallet_id"`
+	Type           string    `gorm:"not null" json:"type"` // topup, hold, capture, release
+	Amount         int64     `gorm:"not null" json:"amount"`
+	Balance        int64     `gorm:"not null" json:"balance"`
+	Held           int64     `gorm:"not null" json:"held"`
+	RideID         *string   `json:"ride_id,omitempty"`
+	Reference      string    `json:"reference,omitempty"`
+	IdempotencyKey string    `gorm:"not null;uniqueIndex" json:"-"`
+	CreatedAt      time.Time `json:"created_at"`
+}
+
+// DriverEarning is what a driver made on one ride, in minor units. Net is
+// the fare less commission plus any tip. CashCollected is the part of the
+// fare the driver already holds because the rider paid cash.
+type DriverEarning struct {
+	ID             string    `gorm:"primaryKey;type:uuid;default:gen_random_uuid()" json:"id"`
+	RideID         string    `gorm:"not null;uniqueIndex" json:"ride_id"`
+	DriverID       string    `gorm:"not null;index" json:"driver_id"`
+	Fare           int64     `gorm:"not null" json:"fare"`
+	CommissionRate float64   `gorm:"not null" json:"commission_rate"`
+	Commission     int64     `gorm:"not null" json:"commission"`
+	Tip            int64     `gorm:"not null;default:0" json:"tip"`
+	PendingTip     int64     `gorm:"not null;default:0" json:"pending_tip"` // reserved while the tip is charged
+	Net            int64     `gorm:"not null" json:"net"`
+	CashCollected  int64     `gorm:"not null;default:0" json:"cash_collected"`
+	Currency       string    `gorm:"not null" json:"currency"`
+	PayoutID       *string   `gorm:"index" json:"payout_id,omitempty"`
+	EarnedAt       time.Time `gorm:"not null" json:"earned_at"`
+	CreatedAt      time.Time `json:"created_at"`
+}
+
+// Payout settles a driver's earnings for a period. Amount is Net less the
+// cash the driver collected and may be negative when they owe us.
+type Payout struct {
+	ID            string     `gorm:"primaryKey;type:uuid;default:gen_random_uuid()" json:"id"`
+	DriverID      string     `gorm:"not null;index" json:"driver_id"`
+	PeriodStart   time.Time  `gorm:"not null" json:"period_start"`
+	PeriodEnd     time.Time  `gorm:"not null" json:"period_end"`
+	Rides         int        `gorm:"not null" json:"rides"`
+	Gross         int64      `gorm:"not null" json:"gross"`
+	Commission    int64      `gorm:"not null" json:"commission"`
+	Tips          int64      `gorm:"not null" json:"tips"`
+	Net           int64      `gorm:"not null" json:"net"`
+	CashCollected int64      `gorm:"not null" json:"cash_collected"`
+	Amount        int64      `gorm:"not null" json:"amount"`
+	Currency      string     `gorm:"not null" json:"currency"`
+	Status        string     `gorm:"not null;default:'pending'" json:"status"` // pending, paid
+	PaidAt        *time.Time `json:"paid_at,omitempty"`
+	CreatedAt     time.Time  `json:"created_at"`
+}
+
+// RideTrailPoint is one filtered GPS position recorded while a ride was in
+// progress.
+type RideTrailPoint struct {
+	ID         int64     `gorm:"primaryKey" json:"-"`
+	RideID     string    `gorm:"type:uuid;not null;index" json:"-"`
+	Lat        float64   `gorm:"not null" json:"lat"`
+	Lng        float64   `gorm:"not null" json:"lng"`
+	RecordedAt time.Time `gorm:"not null" json:"recorded_at"`
+	Accuracy   *float64  `json:"accuracy,omitempty"` // metres
+	Speed      *float64  `json:"speed,omitempty"`    // m/s
+	Heading    *float64  `json:"heading,omitempty"`  // degrees from north
+	CreatedAt  time.Time `json:"-"`
+}
+
+// JSON is an arbitrary JSON document stored as JSONB. An empty value is
+// stored as NULL.
+type JSON json.RawMessage
+
+func (j JSON) MarshalJSON() ([]byte, error) {
+	if len(j) == 0 {
+		return []byte("null"), nil
+	}
+	return j, nil
+}
+
+func (j *JSON) UnmarshalJSON(data []byte) error {
+	*j = append((*j)[:0], data...)
+	return nil
+}
+
+func (j JSON) Value() (driver.Value, error) {
+	if len(j) == 0 {
+		return nil, nil
+	}
+	return []byte(j), nil
+}
+
+func (j *JSON) Scan(value interface{}) error {
+	switch v := value.(type) {
+	case []byte:
+		*j = append((*j)[:0], v...)
+	case string:
+		*j = JSON(v)
+	case nil:
+		*j = nil
+	default:
+		return fmt.Errorf("cannot scan %T into JSON", value)
+	}
+	return nil
+}
+
+// FareAdjustment is an admin change to the fare of a completed ride.
+// Amount is ToFare - FromFare in minor units. An increase on a ride that
+// has already been paid stays pending until the extra charge goes
+// through; if it is declined the adjustment fails and is reversed.
+type FareAdjustment struct {
+	ID        string    `gorm:"primaryKey;type:uuid;default:gen_random_uuid()" json:"id"`
+	RideID    string    `gorm:"not null;index" json:"ride_id"`
+	FromFare  float64   `gorm:"not null" json:"from_fare"`
+	ToFare    float64   `gorm:"not null" json:"to_fare"`
+	Amount    int64     `gorm:"not null" json:"amount"`
+	Note      string    `gorm:"not null" json:"note"`
+	Actor     string    `gorm:"not null" json:"actor"`
+	Status    string    `gorm:"not null" json:"status"` // payments.StatusPending, StatusSucceeded or StatusFailed
+	Error     string    `gorm:"not null;default:''" json:"error,omitempty"`
+	CreatedAt time.Time `json:"created_at"`
+	UpdatedAt time.Time `json:"updated_at"`
+}
+
+// AuditLog records one change an admin made. Rows are append-only; the
+// database rejects updates and deletes.
+type AuditLog struct {
+	ID         string    `gorm:"primaryKey;type:uuid;default:gen_random_uuid()" json:"id"`
+	Actor      string    `gorm:"not null;index" json:"actor"`
+	Action     string    `gorm:"not null" json:"action"`
+	EntityType string    `gorm:"not null;default:''" json:"entity_type"`
+	EntityID   string    `gorm:"not null;default:''" json:"entity_id"`
+	Before     JSON      `gorm:"type:jsonb" json:"before"`
+	After      JSON      `gorm:"type:jsonb" json:"after"`
+	Changes    JSON      `gorm:"type:jsonb" json:"changes"` // field -> {"from", "to"}
+	Method     string    `gorm:"not null" json:"method"`
+	Path       string    `gorm:"not null" json:"path"`
+	Status     int       `gorm:"not null" json:"status"`
+	RequestID  string    `gorm:"not null;default:''" json:"request_id"`
+	IP         string    `gorm:"not null;default:''" json:"ip"`
+	CreatedAt  time.Time `json:"created_at"`
+}
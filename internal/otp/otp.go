@@ -0,0 +1,120 @@
+package otp
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"math/big"
+	"time"
+
+	"rickshaw-app/internal/sms"
+
+	"github.com/redis/go-redis/v9"
+)
+
+const (
+	codeTTL     = 5 * time.Minute
+	cooldown    = time.Minute
+	maxAttempts = 5
+)
+
+var (
+	ErrCooldown        = errors.New("a code was sent recently; try again later")
+	ErrInvalidCode     = errors.New("invalid or expired code")
+	ErrTooManyAttempts = errors.New("too many attempts; request a new code")
+)
+
+// Service issues one-time codes by SMS. Only an HMAC of each code is kept,
+// in Redis, alongside a counter of failed verification attempts.
+type Service struct {
+	rdb    *redis.Client
+	sender sms.Sender
+	secret []byte
+}
+
+func NewService(rdb *redis.Client, sender sms.Sender, secret string) *Service {
+	return &Service{rdb: rdb, sender: sender, secret: []byte(secret)}
+}
+
+func codeKey(phone string) string {
+	return "otp:" + phone
+}
+
+func cooldownKey(phone string) string {
+	return "otp:" + phone + ":cooldown"
+}
+
+func (s *Service) Request(ctx context.Context, phone string) error {
+	ok, err := s.rdb.SetNX(ctx, cooldownKey(phone), 1, cooldown).Result()
+	if err != nil {
+		return err
+	}
+	if !ok {
+		return ErrCooldown
+	}
+
+	n, err := rand.Int(rand.Reader, big.NewInt(1000000))
+	if err != nil {
+		return err
+	}
+	code := fmt.Sprintf("%06d", n.Int64())
+
+	pipe := s.rdb.TxPipeline()
+	pipe.Del(ctx, codeKey(phone))
+	pipe.HSet(ctx, codeKey(phone), "hash", s.hash(phone, code), "attempts", 0)
+	pipe.Expire(ctx, codeKey(phone), codeTTL)
+	if _, err := pipe.Exec(ctx); err != nil {
+		return err
+	}
+
+	return s.sender.Send(ctx, phone, fmt.Sprintf("Your Rickshaw verification code is %s", code))
+}
+
+// verifyScript checks a code hash against the outstanding code in one step,
+// so a code that expires mid-check can't be recreated without a TTL and
+// parallel guesses can't slip past maxAttempts. It returns 1 if the code
+// matched and was consumed, 0 if it didn't match or there was no code, and
+// -1 if the attempts ran out and the code was dropped.
+var verifyScript = redis.NewScript(`
+local stored = redis.call('HGET', KEYS[1], 'hash')
+if not stored then
+	return 0
+end
+local attempts = redis.call('HINCRBY', KEYS[1], 'attempts', 1)
+if attempts > tonumber(ARGV[2]) then
+	redis.call('DEL', KEYS[1])
+	return -1
+end
+if stored ~= ARGV[1] then
+	return 0
+end
+redis.call('DEL', KEYS[1])
+return 1
+`)
+
+// Verify checks code against the outstanding code for phone. A correct code
+// is consumed; wrong codes count towards maxAttempts.
+func (s *Service) Verify(ctx context.Context, phone, code string) error {
+	result, err := verifyScript.Run(ctx, s.rdb, []string{codeKey(phone)}, s.hash(phone, code), maxAttempts).Int()
+	if err != nil {
+		return err
+	}
+	switch result {
+	case 1:
+		return nil
+	case -1:
+		return ErrTooManyAttempts
+	default:
+		return ErrInvalidCode
+	}
+}
+
+func (s *Service) hash(phone, code string) string {
+	mac := hmac.New(sha256.New, s.secret)
+	mac.Write([]byte(phone + ":" + code))
+	return hex.EncodeToString(mac.Sum(nil))
+}
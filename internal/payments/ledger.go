@@ -0,0 +1,97 @@
+package payments
+
+import (
+	"crypto/rand"
+	"fmt"
+
+	"rickshaw-app/internal/models"
+
+	"gorm.io/gorm"
+)
+
+const (
+	// CardClearingAccount holds card money the gateway owes us until it is
+	// paid out.
+	CardClearingAccount = "clearing:card"
+	// CommissionAccount is our revenue from ride fares.
+	CommissionAccount = "platform:commission"
+	// PayoutAccount is the bank account driver payouts are sent from.
+	PayoutAccount = "bank:payouts"
+)
+
+func RiderAccount(userID string) string {
+	return "rider:" + userID
+}
+
+// DriverCashAccount holds cash a driver has collected on our behalf.
+func DriverCashAccount(driverID string) string {
+	return "driver:" + driverID + ":cash"
+}
+
+// DriverEarningsAccount holds what we owe a driver until it is paid out.
+func DriverEarningsAccount(driverID string) string {
+	return "driver:" + driverID + ":earnings"
+}
+
+// Posting is one leg of a ledger transaction. Positive amounts are debits
+// and negative amounts are credits.
+type Posting struct {
+	Account string
+	Amount  int64
+}
+
+// Entry links a ledger transaction to the ride and payment it belongs to.
+type Entry struct {
+	Currency    string
+	Description string
+	RideID      *string
+	PaymentID   *string
+}
+
+// Post writes a balanced transaction. It refuses postings that do not sum to
+// zero.
+func Post(tx *gorm.DB, entry Entry, postings ...Posting) error {
+	if len(postings) < 2 {
+		return fmt.Errorf("ledger transaction needs at least two postings")
+	}
+	var sum int64
+	for _, p := range postings {
+		sum += p.Amount
+	}
+	if sum != 0 {
+		return fmt.Errorf("ledger transaction is unbalanced by %d", sum)
+	}
+
+	transactionID := newUUID()
+	rows := make([]models.LedgerEntry, 0, len(postings))
+	for _, p := range postings {
+		rows = append(rows, models.LedgerEntry{
+			TransactionID: transactionID,
+			Account:       p.Account,
+			Amount:        p.Amount,
+			Currency:      entry.Currency,
+			RideID:        entry.RideID,
+			PaymentID:     entry.PaymentID,
+			Description:   entry.Description,
+		})
+	}
+	return tx.Create(&rows).Error
+}
+
+// Balance returns the sum of every posting to account.
+func Balance(db *gorm.DB, account string) (int64, error) {
+	var balance int64
+	err := db.Model(&models.LedgerEntry{}).
+		Select("COALESCE(SUM(amount), 0)").
+		Where("account = ?", account).
+		Scan(&balance).Error
+	return balance, err
+}
+
+func newUUID() string {
+	b := make([]byte, 16)
+	rand.Read(b)
+	b[6] = b[6]&0x0f | 0x40
+	b[8] = b[8]&0x3f | 0x80
+	return fmt.Sprintf("%x-%x-%x-%x-%x", b[0:4], b[4:6], b[6:8], b[8:10], b[10:16])
+}
@@ -0,0 +1,339 @@
+package payments
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"math"
+
+	"rickshaw-app/internal/config"
+	"rickshaw-app/internal/models"
+	"rickshaw-app/internal/ridestate"
+
+	"gorm.io/gorm"
+)
+
+const (
+	StatusPending    = "pending"
+	StatusProcessing = "processing"
+	StatusSucceeded  = "succeeded"
+	StatusFailed     = "failed"
+	StatusVoided     = "voided"
+)
+
+var (
+	ErrUnknownMethod      = errors.New("unknown payment method")
+	ErrCashNotCollectable = errors.New("cancellation fees cannot be paid in cash")
+	// ErrNotSettleable is returned when the payment is already settled,
+	// voided or being charged by another request.
+	ErrNotSettleable = errors.New("payment cannot be settled")
+	ErrCashIncrease  = errors.New("a cash fare cannot be increased once paid")
+)
+
+type Service struct {
+	db        *gorm.DB
+	providers map[string]Provider
+	currency  string
+}
+
+func NewService(db *gorm.DB, cfg *config.Config) *Service {
+	s := &Service{
+		db: db,
+		providers: map[string]Provider{
+			MethodCash: CashProvider{},
+			MethodCard: FakeCardProvider{},
+		},
+		currency: cfg.Currency,
+	}
+	s.providers[MethodWallet] = walletProvider{s: s}
+	return s
+}
+
+// MinorUnits converts a fare in major units to the integer amount stored
+// in payments and the ledger.
+func MinorUnits(amount float64) int64 {
+	return int64(math.Round(amount * 100))
+}
+
+// AmountDue is what the rider owes for the ride in minor units: the fare
+// once completed, or the cancellation fee once cancelled.
+func AmountDue(ride *models.Ride) int64 {
+	if ride.Status == string(ridestate.Cancelled) {
+		return MinorUnits(ride.CancellationFee)
+	}
+	return MinorUnits(ride.Fare)
+}
+
+// ValidMethod reports whether method is a payment method riders can choose.
+func (s *Service) ValidMethod(method string) bool {
+	_, ok := s.providers[method]
+	return ok
+}
+
+// Open creates the pending payment for a newly created ride.
+func (s *Service) Open(tx *gorm.DB, ride *models.Ride, token string) (*models.Payment, error) {
+	provider, ok := s.providers[ride.PaymentMethod]
+	if !ok {
+		return nil, ErrUnknownMethod
+	}
+	if ride.PaymentMethod == MethodCard && token == "" {
+		return nil, ErrMissingToken
+	}
+
+	payment := &models.Payment{
+		RideID:        ride.ID,
+		RiderID:       ride.RiderID,
+		Method:        ride.PaymentMethod,
+		Provider:      provider.Name(),
+		ProviderToken: token,
+		Amount:        MinorUnits(ride.Fare),
+		Currency:      s.currency,
+		Status:        StatusPending,
+	}
+	return payment, tx.Create(payment).Error
+}
+
+// Void abandons the payment of a cancelled ride and frees any wallet hold.
+// Settled payments are left alone.
+func (s *Service) Void(tx *gorm.DB, rideID string) error {
+	if err := s.Release(tx, rideID); err != nil {
+		return err
+	}
+	if err := tx.Model(&models.Payment{}).
+		Where("ride_id = ? AND status IN ?", rideID, []string{StatusPending, StatusFailed}).
+		Update("status", StatusVoided).Error; err != nil {
+		return err
+	}
+	return tx.Model(&models.Ride{}).Where("id = ?", rideID).Update("payment_status", StatusVoided).Error
+}
+
+// HasFailed reports whether the rider owes money from a failed payment.
+func (s *Service) HasFailed(riderID string) (bool, error) {
+	var count int64
+	err := s.db.Model(&models.Payment{}).
+		Where("rider_id = ? AND status = ?", riderID, StatusFailed).
+		Count(&count).Error
+	return count > 0, err
+}
+
+func (s *Service) ForRide(rideID string) (*models.Payment, error) {
+	var payment models.Payment
+	if err := s.db.First(&payment, "ride_id = ?", rideID).Error; err != nil {
+		return nil, err
+	}
+	return &payment, nil
+}
+
+// ChangeMethod switches a pending or failed payment to another method or
+// card before it is retried.
+func (s *Service) ChangeMethod(rideID, method, token string) error {
+	provider, ok := s.providers[method]
+	if !ok {
+		return ErrUnknownMethod
+	}
+	if method == MethodCard && token == "" {
+		return ErrMissingToken
+	}
+
+	return s.db.Transaction(func(tx *gorm.DB) error {
+		res := tx.Model(&models.Payment{}).
+			Where("ride_id = ? AND status IN ?", rideID, []string{StatusPending, StatusFailed}).
+			Updates(map[string]interface{}{
+				"method":         method,
+				"provider":       provider.Name(),
+				"provider_token": token,
+			})
+		if res.Error != nil {
+			return res.Error
+		}
+		if res.RowsAffected == 0 {
+			return ErrNotSettleable
+		}
+		if method != MethodWallet {
+			if err := s.Release(tx, rideID); err != nil {
+				return err
+			}
+		}
+		return tx.Model(&models.Ride{}).Where("id = ?", rideID).Update("payment_method", method).Error
+	})
+}
+
+// Settle charges the final fare of a completed ride and records it in the
+// ledger. Only one caller can settle a payment at a time; a failed payment
+// may be settled again once the rider has fixed it.
+func (s *Service) Settle(ctx context.Context, rideID string) (*models.Payment, error) {
+	var ride models.Ride
+	if err := s.db.First(&ride, "id = ?", rideID).Error; err != nil {
+		return nil, err
+	}
+	if ride.DriverID == nil {
+		return nil, fmt.Errorf("ride %s has no driver", rideID)
+	}
+	amount := AmountDue(&ride)
+
+	res := s.db.Model(&models.Payment{}).
+		Where("ride_id = ? AND status IN ?", rideID, []string{StatusPending, StatusFailed}).
+		Updates(map[string]interface{}{"status": StatusProcessing, "amount": amount})
+	if res.Error != nil {
+		return nil, res.Error
+	}
+	if res.RowsAffected == 0 {
+		return nil, ErrNotSettleable
+	}
+
+	payment, err := s.ForRide(rideID)
+	if err != nil {
+		return nil, err
+	}
+
+	var result *ChargeResult
+	var chargeErr error
+	if payment.Method == MethodCash && ride.Status == string(ridestate.Cancelled) {
+		// Nobody is there to hand cash to; the rider has to settle the
+		// fee with another method.
+		chargeErr = ErrCashNotCollectable
+	} else {
+		result, chargeErr = s.providers[payment.Method].Charge(ctx, ChargeRequest{
+			PaymentID:  payment.ID,
+			Amount:     payment.Amount,
+			Currency:   payment.Currency,
+			Token:      payment.ProviderToken,
+			CustomerID: payment.RiderID,
+			RideID:     payment.RideID,
+		})
+	}
+
+	err = s.db.Transaction(func(tx *gorm.DB) error {
+		if chargeErr != nil {
+			payment.Status = StatusFailed
+			payment.FailureReason = chargeErr.Error()
+		} else {
+			payment.Status = StatusSucceeded
+			payment.ProviderRef = result.Reference
+			payment.FailureReason = ""
+		}
+
+		if err := tx.Model(payment).Updates(map[string]interface{}{
+			"status":         payment.Status,
+			"provider_ref":   payment.ProviderRef,
+			"failure_reason": payment.FailureReason,
+		}).Error; err != nil {
+			return err
+		}
+		if err := tx.Model(&models.Ride{}).Where("id = ?", rideID).Update("payment_status", payment.Status).Error; err != nil {
+			return err
+		}
+		if chargeErr != nil {
+			return nil
+		}
+
+		return Post(tx, Entry{
+			Currency:    payment.Currency,
+			Description: fmt.Sprintf("%s payment for ride %s", payment.Method, rideID),
+			RideID:      &ride.ID,
+			PaymentID:   &payment.ID,
+		},
+			Posting{Account: sourceAccount(payment.Method, &ride), Amount: payment.Amount},
+			Posting{Account: RiderAccount(ride.RiderID), Amount: -payment.Amount},
+		)
+	})
+	if err != nil {
+		return nil, err
+	}
+	return payment, nil
+}
+
+// ChargeTip collects a tip on a completed ride with the ride's payment
+// method and records it in the ledger. The charge is keyed by the payment,
+// since a ride takes one tip, so retrying it can't take the money twice.
+// record runs in the transaction that records the charge and reports
+// whether this call is the one to record it.
+func (s *Service) ChargeTip(ctx context.Context, rideID string, amount int64, record func(tx *gorm.DB) (bool, error)) error {
+	payment, err := s.ForRide(rideID)
+	if err != nil {
+		return err
+	}
+	return s.chargeExtra(ctx, payment, "tip_"+payment.ID, "tip", amount, record)
+}
+
+// ChargeAdjustment collects a pending fare increase on a ride whose payment
+// has already succeeded, with the same method. The charge is keyed by the
+// adjustment, so retrying one whose outcome was unknown can't take the
+// money twice, and it reaches the ledger when the adjustment is marked
+// succeeded.
+func (s *Service) ChargeAdjustment(ctx context.Context, adj *models.FareAdjustment) error {
+	payment, err := s.ForRide(adj.RideID)
+	if err != nil {
+		return err
+	}
+	if payment.Method == MethodCash {
+		return ErrCashIncrease
+	}
+	return s.chargeExtra(ctx, payment, "adjust_"+adj.RideID+"_"+adj.ID, "fare adjustment", adj.Amount,
+		func(tx *gorm.DB) (bool, error) {
+			res := tx.Model(&models.FareAdjustment{}).
+				Where("id = ? AND status = ?", adj.ID, StatusPending).
+				Update("status", StatusSucceeded)
+			return res.RowsAffected > 0, res.Error
+		})
+}
+
+// chargeExtra charges amount on top of a ride's payment and records it in
+// the ledger. chargeID identifies the charge to the provider. If claim is
+// set it runs in the transaction that records the charge and reports
+// whether this call should record it, so a charge retried concurrently is
+// only recorded once.
+func (s *Service) chargeExtra(ctx context.Context, payment *models.Payment, chargeID, what string, amount int64, claim func(tx *gorm.DB) (bool, error)) error {
+	if amount <= 0 {
+		return ErrInvalidAmount
+	}
+	rideID := payment.RideID
+	var ride models.Ride
+	if err := s.db.First(&ride, "id = ?", rideID).Error; err != nil {
+		return err
+	}
+	if ride.DriverID == nil {
+		return fmt.Errorf("ride %s has no driver", rideID)
+	}
+
+	if _, err := s.providers[payment.Method].Charge(ctx, ChargeRequest{
+		PaymentID:  chargeID,
+		Amount:     amount,
+		Currency:   payment.Currency,
+		Token:      payment.ProviderToken,
+		CustomerID: payment.RiderID,
+		RideID:     payment.RideID,
+	}); err != nil {
+		return err
+	}
+
+	return s.db.Transaction(func(tx *gorm.DB) error {
+		if claim != nil {
+			ok, err := claim(tx)
+			if err != nil || !ok {
+				return err
+			}
+		}
+		return Post(tx, Entry{
+			Currency:    payment.Currency,
+			Description: fmt.Sprintf("%s for ride %s", what, rideID),
+			RideID:      &ride.ID,
+			PaymentID:   &payment.ID,
+		},
+			Posting{Account: sourceAccount(payment.Method, &ride), Amount: amount},
+			Posting{Account: RiderAccount(ride.RiderID), Amount: -amount},
+		)
+	})
+}
+
+// sourceAccount is the ledger account money paid with method arrives in.
+func sourceAccount(method string, ride *models.Ride) string {
+	switch method {
+	case MethodCash:
+		return DriverCashAccount(*ride.DriverID)
+	case MethodWallet:
+		return WalletAccount(ride.RiderID)
+	default:
+		return CardClearingAccount
+	}
+}
@@ -0,0 +1,73 @@
+package payments
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"strings"
+)
+
+const (
+	MethodCash = "cash"
+	MethodCard = "card"
+)
+
+var (
+	ErrDeclined     = errors.New("payment declined")
+	ErrMissingToken = errors.New("card token is required")
+)
+
+// Refused reports whether err means a charge was turned down, as opposed to
+// failing in a way that leaves its outcome unknown.
+func Refused(err error) bool {
+	return errors.Is(err, ErrDeclined) || errors.Is(err, ErrMissingToken) || errors.Is(err, ErrInsufficientFunds)
+}
+
+// ChargeRequest asks a provider to collect Amount minor units. PaymentID
+// doubles as the idempotency key so a retried charge is never taken twice.
+type ChargeRequest struct {
+	PaymentID  string
+	Amount     int64
+	Currency   string
+	Token      string
+	CustomerID string
+	RideID     string
+}
+
+type ChargeResult struct {
+	Reference string
+}
+
+type Provider interface {
+	Name() string
+	Charge(ctx context.Context, req ChargeRequest) (*ChargeResult, error)
+}
+
+// CashProvider records fares the driver collects in person. It always
+// succeeds.
+type CashProvider struct{}
+
+func (CashProvider) Name() string { return "cash" }
+
+func (CashProvider) Charge(ctx context.Context, req ChargeRequest) (*ChargeResult, error) {
+	return &ChargeResult{Reference: "cash_" + req.PaymentID}, nil
+}
+
+// FakeCardProvider is a card gateway for local testing. Any token succeeds
+// except ones starting with "tok_decline".
+type FakeCardProvider struct{}
+
+func (FakeCardProvider) Name() string { return "fake_card" }
+
+func (FakeCardProvider) Charge(ctx context.Context, req ChargeRequest) (*ChargeResult, error) {
+	if req.Token == "" {
+		return nil, ErrMissingToken
+	}
+	if strings.HasPrefix(req.Token, "tok_decline") {
+		return nil, ErrDeclined
+	}
+	b := make([]byte, 8)
+	rand.Read(b)
+	return &ChargeResult{Reference: "fake_ch_" + hex.EncodeToString(b)}, nil
+}
@@ -0,0 +1,268 @@
+package payments
+
+import (
+	"context"
+	"errors"
+	"fmt"
+
+	"rickshaw-app/internal/models"
+
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+const MethodWallet = "wallet"
+
+const (
+	WalletTopUp   = "topup"
+	WalletHold    = "hold"
+	WalletCapture = "capture"
+	WalletRelease = "release"
+	WalletRefund  = "refund"
+)
+
+var (
+	ErrInsufficientFunds = errors.New("insufficient wallet balance")
+	ErrInvalidAmount     = errors.New("amount must be positive")
+	ErrKeyReused         = errors.New("idempotency key was already used with a different amount")
+)
+
+// WalletAccount is the ledger account for money we owe a rider through
+// their wallet.
+func WalletAccount(userID string) string {
+	return "wallet:" + userID
+}
+
+// walletProvider charges a ride by capturing the hold placed on the rider's
+// wallet when the driver accepted.
+type walletProvider struct {
+	s *Service
+}
+
+func (walletProvider) Name() string { return "wallet" }
+
+func (p walletProvider) Charge(ctx context.Context, req ChargeRequest) (*ChargeResult, error) {
+	txn, err := p.s.capture(req.CustomerID, req.RideID, req.PaymentID, req.Amount)
+	if err != nil {
+		return nil, err
+	}
+	return &ChargeResult{Reference: "wallet_" + txn.ID}, nil
+}
+
+// Wallet returns the user's wallet, creating an empty one on first use.
+func (s *Service) Wallet(userID string) (*models.Wallet, error) {
+	wallet := models.Wallet{UserID: userID, Currency: s.currency}
+	err := s.db.Clauses(clause.OnConflict{DoNothing: true}).Create(&wallet).Error
+	if err != nil {
+		return nil, err
+	}
+	if err := s.db.First(&wallet, "user_id = ?", userID).Error; err != nil {
+		return nil, err
+	}
+	return &wallet, nil
+}
+
+func (s *Service) WalletTransactions(userID string, limit int) ([]models.WalletTransaction, error) {
+	wallet, err := s.Wallet(userID)
+	if err != nil {
+		return nil, err
+	}
+	var txns []models.WalletTransaction
+	err = s.db.Where("wallet_id = ?", wallet.ID).Order("created_at DESC").Limit(limit).Find(&txns).Error
+	return txns, err
+}
+
+// TopUp charges the card and credits the wallet. Repeating a request with
+// the same key returns the original transaction without charging again, or
+// ErrKeyReused if the amount differs.
+func (s *Service) TopUp(ctx context.Context, userID string, amount int64, token, key string) (*models.WalletTransaction, error) {
+	if amount <= 0 {
+		return nil, ErrInvalidAmount
+	}
+	if _, err := s.Wallet(userID); err != nil {
+		return nil, err
+	}
+
+	idempotencyKey := fmt.Sprintf("topup:%s:%s", userID, key)
+	var existing models.WalletTransaction
+	if err := s.db.First(&existing, "idempotency_key = ?", idempotencyKey).Error; err == nil {
+		if existing.Amount != amount {
+			return nil, ErrKeyReused
+		}
+		return &existing, nil
+	}
+
+	result, err := s.providers[MethodCard].Charge(ctx, ChargeRequest{
+		PaymentID:  idempotencyKey,
+		Amount:     amount,
+		Currency:   s.currency,
+		Token:      token,
+		CustomerID: userID,
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	var txn *models.WalletTransaction
+	err = s.db.Transaction(func(tx *gorm.DB) error {
+		var created bool
+		var err error
+		txn, created, err = s.apply(tx, userID, WalletTopUp, amount, 0, nil, result.Reference, idempotencyKey)
+		if err != nil {
+			return err
+		}
+		// A concurrent request with the same key got here first and has
+		// already posted the credit.
+		if !created {
+			if txn.Amount != amount {
+				return ErrKeyReused
+			}
+			return nil
+		}
+		return Post(tx, Entry{Currency: s.currency, Description: "wallet top-up"},
+			Posting{Account: CardClearingAccount, Amount: amount},
+			Posting{Account: WalletAccount(userID), Amount: -amount},
+		)
+	})
+	return txn, err
+}
+
+// Hold reserves the fare of a wallet-paid ride. It does nothing for other
+// payment methods or if the ride already has a hold.
+func (s *Service) Hold(tx *gorm.DB, ride *models.Ride) error {
+	if ride.PaymentMethod != MethodWallet {
+		return nil
+	}
+	amount := MinorUnits(ride.Fare)
+	_, _, err := s.apply(tx, ride.RiderID, WalletHold, 0, amount, &ride.ID, "", "hold:"+ride.ID)
+	return err
+}
+
+// Release frees the hold on a ride, if there is one.
+func (s *Service) Release(tx *gorm.DB, rideID string) error {
+	hold, err := s.activeHold(tx, rideID)
+	if err != nil || hold == nil {
+		return err
+	}
+	var wallet models.Wallet
+	if err := tx.First(&wallet, "id = ?", hold.WalletID).Error; err != nil {
+		return err
+	}
+	_, _, err = s.apply(tx, wallet.UserID, WalletRelease, 0, -hold.Amount, &rideID, "", "release:"+rideID)
+	return err
+}
+
+// Refund credits amount to the rider's wallet for the ride, e.g. after an
+// admin lowered a fare that had already been paid.
+func (s *Service) Refund(tx *gorm.DB, ride *models.Ride, amount int64) error {
+	if amount <= 0 {
+		return ErrInvalidAmount
+	}
+	if err := tx.Clauses(clause.OnConflict{DoNothing: true}).
+		Create(&models.Wallet{UserID: ride.RiderID, Currency: s.currency}).Error; err != nil {
+		return err
+	}
+	txn, created, err := s.apply(tx, ride.RiderID, WalletRefund, amount, 0, &ride.ID, "", "refund:"+newUUID())
+	if err != nil || !created {
+		return err
+	}
+	return Post(tx, Entry{
+		Currency:    s.currency,
+		Description: fmt.Sprintf("refund to wallet for ride %s", ride.ID),
+		RideID:      &ride.ID,
+	},
+		Posting{Account: RiderAccount(ride.RiderID), Amount: txn.Amount},
+		Posting{Account: WalletAccount(ride.RiderID), Amount: -txn.Amount},
+	)
+}
+
+// capture debits amount from the wallet and frees the ride's hold in one
+// step. The hold stays in place if the balance is short so the rider can
+// top up and retry.
+func (s *Service) capture(userID, rideID, paymentID string, amount int64) (*models.WalletTransaction, error) {
+	var txn *models.WalletTransaction
+	err := s.db.Transaction(func(tx *gorm.DB) error {
+		hold, err := s.activeHold(tx, rideID)
+		if err != nil {
+			return err
+		}
+		var held int64
+		if hold != nil {
+			held = hold.Amount
+		}
+		txn, _, err = s.apply(tx, userID, WalletCapture, -amount, -held, &rideID, "", "capture:"+paymentID)
+		return err
+	})
+	return txn, err
+}
+
+// activeHold returns the hold placed on a ride that has been neither
+// captured nor released.
+func (s *Service) activeHold(tx *gorm.DB, rideID string) (*models.WalletTransaction, error) {
+	var txns []models.WalletTransaction
+	if err := tx.Where("ride_id = ?", rideID).Order("created_at").Find(&txns).Error; err != nil {
+		return nil, err
+	}
+	var hold *models.WalletTransaction
+	for i := range txns {
+		switch txns[i].Type {
+		case WalletHold:
+			hold = &txns[i]
+		case WalletCapture, WalletRelease:
+			hold = nil
+		}
+	}
+	return hold, nil
+}
+
+// apply changes the wallet's balance and hold by the given deltas under a
+// row lock and records the change. If a transaction with key already exists
+// it is returned and nothing changes; created reports which happened, so
+// callers only post to the ledger for a change they made.
+func (s *Service) apply(tx *gorm.DB, userID, kind string, balanceDelta, heldDelta int64, rideID *string, reference, key string) (txn *models.WalletTransaction, created bool, err error) {
+	var wallet models.Wallet
+	if err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).First(&wallet, "user_id = ?", userID).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, false, ErrInsufficientFunds
+		}
+		return nil, false, err
+	}
+
+	var existing models.WalletTransaction
+	err = tx.First(&existing, "idempotency_key = ?", key).Error
+	if err == nil {
+		return &existing, false, nil
+	}
+	if !errors.Is(err, gorm.ErrRecordNotFound) {
+		return nil, false, err
+	}
+
+	balance := wallet.Balance + balanceDelta
+	held := wallet.Held + heldDelta
+	if balance < 0 || held < 0 || held > balance {
+		return nil, false, ErrInsufficientFunds
+	}
+
+	if err := tx.Model(&wallet).Updates(map[string]interface{}{"balance": balance, "held": held}).Error; err != nil {
+		return nil, false, err
+	}
+
+	amount := balanceDelta
+	if kind == WalletHold || kind == WalletRelease {
+		amount = heldDelta
+	}
+	txn = &models.WalletTransaction{
+		WalletID:       wallet.ID,
+		Type:           kind,
+		Amount:         amount,
+		Balance:        balance,
+		Held:           held,
+		RideID:         rideID,
+		Reference:      reference,
+		IdempotencyKey: key,
+	}
+	if err := tx.Create(txn).Error; err != nil {
+		return nil, false, err
+	}
+	return txn, true, nil
+}
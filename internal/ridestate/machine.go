@@ -0,0 +1,128 @@
+package ridestate
+
+import (
+	"fmt"
+
+	"rickshaw-app/internal/models"
+
+	"gorm.io/gorm"
+)
+
+const (
+	CodeInvalidTransition = "invalid_transition"
+	CodeActorNotAllowed   = "actor_not_allowed"
+	CodeNotParticipant    = "not_participant"
+	CodeConflict          = "state_conflict"
+)
+
+// Error is returned for every rejected transition. Code is stable and safe
+// to expose to API clients.
+type Error struct {
+	Code    string
+	Message string
+}
+
+func (e *Error) Error() string {
+	return e.Message
+}
+
+func newError(code, format string, args ...interface{}) *Error {
+	return &Error{Code: code, Message: fmt.Sprintf(format, args...)}
+}
+
+type Change struct {
+	Event   Event
+	From    Status
+	To      Status
+	Actor   Actor
+	ActorID string
+	Reason  string
+	Note    string
+}
+
+// Hook runs inside the transaction after the ride row has been updated.
+// Returning an error rolls the transition back.
+type Hook func(tx *gorm.DB, ride *models.Ride, change Change) error
+
+type Machine struct {
+	hooks []Hook
+}
+
+func New() *Machine {
+	return &Machine{hooks: []Hook{historyHook}}
+}
+
+func (m *Machine) AddHook(hook Hook) {
+	m.hooks = append(m.hooks, hook)
+}
+
+// Create inserts a new ride in the requested state.
+func (m *Machine) Create(tx *gorm.DB, ride *models.Ride, actor Actor, actorID, note string) error {
+	ride.Status = string(Requested)
+	if err := tx.Create(ride).Error; err != nil {
+		return err
+	}
+	return m.runHooks(tx, ride, Change{Event: Request, To: Requested, Actor: actor, ActorID: actorID, Note: note})
+}
+
+// Fire applies event to the ride. The status update is conditional on the
+// ride still being in the status it was read with, so of two concurrent
+// callers only one succeeds and the other gets CodeConflict. Extra column
+// updates are written in the same statement.
+func (m *Machine) Fire(tx *gorm.DB, ride *models.Ride, event Event, actor Actor, actorID string, updates map[string]interface{}, note string) error {
+	return m.FireWithReason(tx, ride, event, actor, actorID, "", updates, note)
+}
+
+// FireWithReason is Fire with a reason code recorded in the ride history,
+// such as why a ride was cancelled.
+func (m *Machine) FireWithReason(tx *gorm.DB, ride *models.Ride, event Event, actor Actor, actorID, reason string, updates map[string]interface{}, note string) error {
+	if err := Check(ride, event, actor, actorID); err != nil {
+		return err
+	}
+
+	t := transitions[event]
+	from := Status(ride.Status)
+
+	if updates == nil {
+		updates = map[string]interface{}{}
+	}
+	updates["status"] = string(t.To)
+
+	res := tx.Model(&models.Ride{}).Where("id = ? AND status = ?", ride.ID, string(from)).Updates(updates)
+	if res.Error != nil {
+		return res.Error
+	}
+	if res.RowsAffected == 0 {
+		return newError(CodeConflict, "ride was updated by another request")
+	}
+
+	ride.Status = string(t.To)
+	return m.runHooks(tx, ride, Change{Event: event, From: from, To: t.To, Actor: actor, ActorID: actorID, Reason: reason, Note: note})
+}
+
+func (m *Machine) runHooks(tx *gorm.DB, ride *models.Ride, change Change) error {
+	for _, hook := range m.hooks {
+		if err := hook(tx, ride, change); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// historyHook records every change except ratings, which leave the ride
+// as it was. Admin interventions are recorded even when the status stays
+// the same.
+func historyHook(tx *gorm.DB, ride *models.Ride, change Change) error {
+	if change.Event == Rate {
+		return nil
+	}
+	return tx.Create(&models.RideHistory{
+		RideID:  ride.ID,
+		Status:  string(change.To),
+		Event:   string(change.Event),
+		Actor:   string(change.Actor),
+		ActorID: change.ActorID,
+		Reason:  change.Reason,
+		Note:    change.Note,
+	}).Error
+}
@@ -0,0 +1,224 @@
+package ridestate
+
+import (
+	"rickshaw-app/internal/models"
+)
+
+type Status string
+
+const (
+	Requested Status = "requested"
+	Accepted  Status = "accepted"
+	Arrived   Status = "arrived"
+	Started   Status = "started"
+	Completed Status = "completed"
+	Cancelled Status = "cancelled"
+)
+
+// Statuses lists every ride status, in lifecycle order. It must match the
+// CHECK constraint on rides.status.
+var Statuses = []Status{Requested, Accepted, Arrived, Started, Completed, Cancelled}
+
+func (s Status) Terminal() bool {
+	return s == Completed || s == Cancelled
+}
+
+type Actor string
+
+const (
+	Rider  Actor = "rider"
+	Driver Actor = "driver"
+	System Actor = "system"
+	Admin  Actor = "admin"
+)
+
+type Event string
+
+const (
+	Request  Event = "request"
+	Accept   Event = "accept"
+	Arrive   Event = "arrive"
+	Start    Event = "start"
+	Complete Event = "complete"
+	Cancel   Event = "cancel"
+	Rate     Event = "rate"
+
+	// Reassign and AdjustFare are admin interventions.
+	Reassign   Event = "reassign"
+	AdjustFare Event = "adjust_fare"
+
+	// The dispatcher records its offers in the ride history. They have no
+	// transition and leave the ride requested.
+	Offer        Event = "offer"
+	DeclineOffer Event = "decline_offer"
+	ExpireOffer  Event = "expire_offer"
+	Unassign     Event = "unassign"
+)
+
+// Guard runs after the status and actor checks. actorID is the user ID for
+// riders and the driver ID for drivers.
+type Guard func(ride *models.Ride, actor Actor, actorID string) error
+
+type Transition struct {
+	Event  Event
+	From   []Status
+	To     Status
+	Actors []Actor
+	Guard  Guard
+}
+
+var transitions = map[Event]Transition{
+	Accept: {
+		Event:  Accept,
+		From:   []Status{Requested},
+		To:     Accepted,
+		Actors: []Actor{Driver, System, Admin},
+		Guard:  unassigned,
+	},
+	Arrive: {
+		Event:  Arrive,
+		From:   []Status{Accepted},
+		To:     Arrived,
+		Actors: []Actor{Driver, Admin},
+		Guard:  assignedDriver,
+	},
+	Start: {
+		Event:  Start,
+		From:   []Status{Arrived},
+		To:     Started,
+		Actors: []Actor{Driver, Admin},
+		Guard:  assignedDriver,
+	},
+	Complete: {
+		Event:  Complete,
+		From:   []Status{Started},
+		To:     Completed,
+		Actors: []Actor{Driver, Admin},
+		Guard:  assignedDriver,
+	},
+	Cancel: {
+		Event:  Cancel,
+		From:   []Status{Requested, Accepted, Arrived, Started},
+		To:     Cancelled,
+		Actors: []Actor{Rider, Driver, System, Admin},
+		Guard:  participant,
+	},
+	// Reassigning hands the ride to another driver, who has to drive to
+	// the pickup again.
+	Reassign: {
+		Event:  Reassign,
+		From:   []Status{Accepted, Arrived},
+		To:     Accepted,
+		Actors: []Actor{Admin},
+		Guard:  hasDriver,
+	},
+	AdjustFare: {
+		Event:  AdjustFare,
+		From:   []Status{Completed},
+		To:     Completed,
+		Actors: []Actor{Admin},
+		Guard:  hasDriver,
+	},
+	// Rating does not move the ride; it is modelled here so the same
+	// status and ownership rules apply.
+	Rate: {
+		Event:  Rate,
+		From:   []Status{Completed},
+		To:     Completed,
+		Actors: []Actor{Rider},
+		Guard:  ratable,
+	},
+}
+
+func Lookup(event Event) (Transition, bool) {
+	t, ok := transitions[event]
+	return t, ok
+}
+
+// Check reports whether actor may fire event on the ride in its current state.
+func Check(ride *models.Ride, event Event, actor Actor, actorID string) error {
+	t, ok := transitions[event]
+	if !ok {
+		return newError(CodeInvalidTransition, "unknown ride event %q", event)
+	}
+
+	if !containsActor(t.Actors, actor) {
+		return newError(CodeActorNotAllowed, "%s cannot %s a ride", actor, event)
+	}
+
+	from := Status(ride.Status)
+	if !containsStatus(t.From, from) {
+		return newError(CodeInvalidTransition, "cannot %s a ride that is %s", event, from)
+	}
+
+	if t.Guard != nil {
+		return t.Guard(ride, actor, actorID)
+	}
+	return nil
+}
+
+func unassigned(ride *models.Ride, actor Actor, actorID string) error {
+	if ride.DriverID != nil {
+		return newError(CodeInvalidTransition, "ride already has a driver")
+	}
+	return nil
+}
+
+func assignedDriver(ride *models.Ride, actor Actor, actorID string) error {
+	if actor != Driver {
+		return nil
+	}
+	if ride.DriverID == nil || *ride.DriverID != actorID {
+		return newError(CodeNotParticipant, "driver is not assigned to this ride")
+	}
+	return nil
+}
+
+func hasDriver(ride *models.Ride, actor Actor, actorID string) error {
+	if ride.DriverID == nil {
+		return newError(CodeInvalidTransition, "ride not assigned to a driver")
+	}
+	return nil
+}
+
+func participant(ride *models.Ride, actor Actor, actorID string) error {
+	switch actor {
+	case Rider:
+		if ride.RiderID != actorID {
+			return newError(CodeNotParticipant, "rider does not own this ride")
+		}
+	case Driver:
+		if ride.DriverID == nil || *ride.DriverID != actorID {
+			return newError(CodeNotParticipant, "driver is not assigned to this ride")
+		}
+	}
+	return nil
+}
+
+func ratable(ride *models.Ride, actor Actor, actorID string) error {
+	if ride.RiderID != actorID {
+		return newError(CodeNotParticipant, "rider does not own this ride")
+	}
+	if ride.DriverID == nil {
+		return newError(CodeInvalidTransition, "ride not assigned to a driver")
+	}
+	return nil
+}
+
+func containsActor(actors []Actor, actor Actor) bool {
+	for _, a := range actors {
+		if a == actor {
+			return true
+		}
+	}
+	return false
+}
+
+func containsStatus(statuses []Status, status Status) bool {
+	for _, s := range statuses {
+		if s == status {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,143 @@
+package session
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+)
+
+// Session is one logged-in device. Its ID is the jti of the tokens issued
+// for it, so revoking the session invalidates those tokens.
+type Session struct {
+	ID        string    `json:"id"`
+	UserID    string    `json:"user_id"`
+	UserAgent string    `json:"user_agent"`
+	IP        string    `json:"ip"`
+	CreatedAt time.Time `json:"created_at"`
+	ExpiresAt time.Time `json:"expires_at"`
+	Current   bool      `json:"current,omitempty"`
+}
+
+type Store struct {
+	rdb *redis.Client
+}
+
+func NewStore(rdb *redis.Client) *Store {
+	return &Store{rdb: rdb}
+}
+
+func NewID() string {
+	b := make([]byte, 16)
+	rand.Read(b)
+	return hex.EncodeToString(b)
+}
+
+func sessionKey(userID, id string) string {
+	return "session:" + userID + ":" + id
+}
+
+func userSessionsKey(userID string) string {
+	return "sessions:" + userID
+}
+
+func (s *Store) Create(ctx context.Context, sess *Session, ttl time.Duration) error {
+	if sess.ID == "" {
+		sess.ID = NewID()
+	}
+	sess.CreatedAt = time.Now()
+	sess.ExpiresAt = sess.CreatedAt.Add(ttl)
+
+	payload, err := json.Marshal(sess)
+	if err != nil {
+		return err
+	}
+
+	pipe := s.rdb.TxPipeline()
+	pipe.Set(ctx, sessionKey(sess.UserID, sess.ID), payload, ttl)
+	pipe.SAdd(ctx, userSessionsKey(sess.UserID), sess.ID)
+	_, err = pipe.Exec(ctx)
+	return err
+}
+
+// Extend pushes the session's expiry out to ttl from now.
+func (s *Store) Extend(ctx context.Context, userID, id string, ttl time.Duration) error {
+	sess, err := s.Get(ctx, userID, id)
+	if err != nil {
+		return err
+	}
+	sess.ExpiresAt = time.Now().Add(ttl)
+
+	payload, err := json.Marshal(sess)
+	if err != nil {
+		return err
+	}
+	return s.rdb.Set(ctx, sessionKey(userID, id), payload, ttl).Err()
+}
+
+func (s *Store) Get(ctx context.Context, userID, id string) (*Session, error) {
+	payload, err := s.rdb.Get(ctx, sessionKey(userID, id)).Bytes()
+	if err != nil {
+		return nil, err
+	}
+
+	var sess Session
+	if err := json.Unmarshal(payload, &sess); err != nil {
+		return nil, err
+	}
+	return &sess, nil
+}
+
+func (s *Store) Exists(ctx context.Context, userID, id string) (bool, error) {
+	n, err := s.rdb.Exists(ctx, sessionKey(userID, id)).Result()
+	return n == 1, err
+}
+
+// List returns the user's active sessions and prunes expired ones from the
+// index.
+func (s *Store) List(ctx context.Context, userID string) ([]Session, error) {
+	ids, err := s.rdb.SMembers(ctx, userSessionsKey(userID)).Result()
+	if err != nil {
+		return nil, err
+	}
+
+	sessions := []Session{}
+	for _, id := range ids {
+		sess, err := s.Get(ctx, userID, id)
+		if err == redis.Nil {
+			s.rdb.SRem(ctx, userSessionsKey(userID), id)
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+		sessions = append(sessions, *sess)
+	}
+	return sessions, nil
+}
+
+func (s *Store) Revoke(ctx context.Context, userID, id string) error {
+	pipe := s.rdb.TxPipeline()
+	pipe.Del(ctx, sessionKey(userID, id))
+	pipe.SRem(ctx, userSessionsKey(userID), id)
+	_, err := pipe.Exec(ctx)
+	return err
+}
+
+func (s *Store) RevokeAll(ctx context.Context, userID string) error {
+	ids, err := s.rdb.SMembers(ctx, userSessionsKey(userID)).Result()
+	if err != nil {
+		return err
+	}
+
+	pipe := s.rdb.TxPipeline()
+	for _, id := range ids {
+		pipe.Del(ctx, sessionKey(userID, id))
+	}
+	pipe.Del(ctx, userSessionsKey(userID))
+	_, err = pipe.Exec(ctx)
+	return err
+}
@@ -0,0 +1,70 @@
+package sms
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"os"
+	"strings"
+	"sync"
+	"time"
+	"unicode"
+
+	"rickshaw-app/internal/config"
+)
+
+// Sender delivers a text message to a phone number. Production gateways
+// implement this; the log and file senders are for local development. Only
+// the file sender keeps codes readable, so it has to be chosen explicitly
+// with SMS_DRIVER=file.
+type Sender interface {
+	Send(ctx context.Context, phone, message string) error
+}
+
+func NewSender(cfg *config.Config) Sender {
+	switch cfg.SMSDriver {
+	case "file":
+		return &FileSender{Path: cfg.SMSFilePath}
+	default:
+		return LogSender{}
+	}
+}
+
+// LogSender logs that a message was sent with its digits masked, so the
+// default driver never writes one-time codes to the logs.
+type LogSender struct{}
+
+func (LogSender) Send(ctx context.Context, phone, message string) error {
+	log.Printf("sms to %s: %s", phone, maskDigits(message))
+	return nil
+}
+
+func maskDigits(message string) string {
+	return strings.Map(func(r rune) rune {
+		if unicode.IsDigit(r) {
+			return '*'
+		}
+		return r
+	}, message)
+}
+
+// FileSender appends each message to a file so tests and local tooling can
+// read the codes back.
+type FileSender struct {
+	Path string
+	mu   sync.Mutex
+}
+
+func (s *FileSender) Send(ctx context.Context, phone, message string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	f, err := os.OpenFile(s.Path, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0o600)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	_, err = fmt.Fprintf(f, "%s\t%s\t%s\n", time.Now().Format(time.RFC3339), phone, message)
+	return err
+}
@@ -0,0 +1,256 @@
+package surge
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"math"
+	"strconv"
+	"strings"
+	"time"
+
+	"rickshaw-app/internal/config"
+	"rickshaw-app/internal/geo"
+	"rickshaw-app/internal/models"
+	"rickshaw-app/internal/ridestate"
+
+	"github.com/redis/go-redis/v9"
+	"gorm.io/gorm"
+)
+
+const (
+	cellsKey     = "surge:cells"
+	overridesKey = "surge:overrides"
+	disabledKey  = "surge:disabled"
+	lockKey      = "surge:lock"
+
+	// demandWindow is how far back requested rides count as open demand.
+	demandWindow = 10 * time.Minute
+)
+
+// Cell is the surge state of one grid square. ID is "<row>:<col>" in a grid
+// of cfg.SurgeCellSizeDeg degrees.
+type Cell struct {
+	ID         string    `json:"id"`
+	Demand     int       `json:"demand"`
+	Supply     int       `json:"supply"`
+	Multiplier float64   `json:"multiplier"`
+	Override   *float64  `json:"override,omitempty"`
+	UpdatedAt  time.Time `json:"updated_at"`
+}
+
+type Engine struct {
+	db            *gorm.DB
+	rdb           *redis.Client
+	locations     *geo.Index
+	interval      time.Duration
+	maxMultiplier float64
+	cellSize      float64
+}
+
+func NewEngine(db *gorm.DB, rdb *redis.Client, cfg *config.Config) *Engine {
+	return &Engine{
+		db:            db,
+		rdb:           rdb,
+		locations:     geo.NewIndex(rdb),
+		interval:      cfg.SurgeInterval,
+		maxMultiplier: cfg.SurgeMaxMultiplier,
+		cellSize:      cfg.SurgeCellSizeDeg,
+	}
+}
+
+func (e *Engine) CellID(lat, lng float64) string {
+	row := int(math.Floor(lat / e.cellSize))
+	col := int(math.Floor(lng / e.cellSize))
+	return strconv.Itoa(row) + ":" + strconv.Itoa(col)
+}
+
+// Run recomputes surge every interval until ctx is cancelled. With several
+// API instances only one does the work per interval.
+func (e *Engine) Run(ctx context.Context) {
+	ticker := time.NewTicker(e.interval)
+	defer ticker.Stop()
+
+	for {
+		ok, err := e.rdb.SetNX(ctx, lockKey, 1, e.interval/2).Result()
+		if err == nil && ok {
+			if err := e.Recompute(ctx); err != nil {
+				log.Printf("surge: recompute failed: %v", err)
+			}
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// Recompute counts open requests and available drivers per cell and stores
+// the resulting multipliers.
+func (e *Engine) Recompute(ctx context.Context) error {
+	cells := map[string]*Cell{}
+	cell := func(id string) *Cell {
+		if c, ok := cells[id]; ok {
+			return c
+		}
+		c := &Cell{ID: id}
+		cells[id] = c
+		return c
+	}
+
+	var pickups []struct {
+		PickupLat float64
+		PickupLng float64
+	}
+	if err := e.db.Model(&models.Ride{}).
+		Select("pickup_lat, pickup_lng").
+		Where("status = ? AND created_at > ?", string(ridestate.Requested), time.Now().Add(-demandWindow)).
+		Scan(&pickups).Error; err != nil {
+		return err
+	}
+	for _, p := range pickups {
+		cell(e.CellID(p.PickupLat, p.PickupLng)).Demand++
+	}
+
+	drivers, err := e.locations.All(ctx)
+	if err != nil {
+		return err
+	}
+	for _, d := range drivers {
+		cell(e.CellID(d.Lat, d.Lng)).Supply++
+	}
+
+	now := time.Now()
+	values := make(map[string]interface{}, len(cells))
+	for id, c := range cells {
+		c.Multiplier = e.multiplier(c.Demand, c.Supply)
+		c.UpdatedAt = now
+		payload, err := json.Marshal(c)
+		if err != nil {
+			return err
+		}
+		values[id] = payload
+	}
+
+	pipe := e.rdb.TxPipeline()
+	pipe.Del(ctx, cellsKey)
+	if len(values) > 0 {
+		pipe.HSet(ctx, cellsKey, values)
+	}
+	pipe.Expire(ctx, cellsKey, 3*e.interval)
+	_, err = pipe.Exec(ctx)
+	return err
+}
+
+// multiplier grows by 0.25 for every extra open request per free driver,
+// rounded to 0.1 and capped at maxMultiplier.
+func (e *Engine) multiplier(demand, supply int) float64 {
+	ratio := float64(demand) / math.Max(float64(supply), 1)
+	if ratio <= 1 {
+		return 1
+	}
+	m := math.Round((1+(ratio-1)*0.25)*10) / 10
+	return math.Min(m, e.maxMultiplier)
+}
+
+// MultiplierAt returns the surge multiplier to charge for a pickup at the
+// given point. Any error falls back to no surge.
+func (e *Engine) MultiplierAt(ctx context.Context, lat, lng float64) float64 {
+	disabled, err := e.rdb.Exists(ctx, disabledKey).Result()
+	if err != nil || disabled == 1 {
+		return 1
+	}
+
+	id := e.CellID(lat, lng)
+
+	if v, err := e.rdb.HGet(ctx, overridesKey, id).Float64(); err == nil {
+		return v
+	}
+
+	payload, err := e.rdb.HGet(ctx, cellsKey, id).Bytes()
+	if err != nil {
+		return 1
+	}
+	var c Cell
+	if err := json.Unmarshal(payload, &c); err != nil {
+		return 1
+	}
+	return c.Multiplier
+}
+
+// Cells returns every cell that has computed surge or an override.
+func (e *Engine) Cells(ctx context.Context) ([]Cell, error) {
+	computed, err := e.rdb.HGetAll(ctx, cellsKey).Result()
+	if err != nil {
+		return nil, err
+	}
+	overrides, err := e.rdb.HGetAll(ctx, overridesKey).Result()
+	if err != nil {
+		return nil, err
+	}
+
+	byID := map[string]*Cell{}
+	for id, payload := range computed {
+		var c Cell
+		if err := json.Unmarshal([]byte(payload), &c); err != nil {
+			continue
+		}
+		byID[id] = &c
+	}
+	for id, raw := range overrides {
+		v, err := strconv.ParseFloat(raw, 64)
+		if err != nil {
+			continue
+		}
+		c, ok := byID[id]
+		if !ok {
+			c = &Cell{ID: id, Multiplier: 1}
+			byID[id] = c
+		}
+		c.Override = &v
+	}
+
+	cells := make([]Cell, 0, len(byID))
+	for _, c := range byID {
+		cells = append(cells, *c)
+	}
+	return cells, nil
+}
+
+func (e *Engine) Enabled(ctx context.Context) (bool, error) {
+	disabled, err := e.rdb.Exists(ctx, disabledKey).Result()
+	return disabled == 0, err
+}
+
+func (e *Engine) SetEnabled(ctx context.Context, enabled bool) error {
+	if enabled {
+		return e.rdb.Del(ctx, disabledKey).Err()
+	}
+	return e.rdb.Set(ctx, disabledKey, 1, 0).Err()
+}
+
+func (e *Engine) SetOverride(ctx context.Context, cellID string, multiplier float64) error {
+	if !validCellID(cellID) {
+		return fmt.Errorf("invalid cell id %q", cellID)
+	}
+	return e.rdb.HSet(ctx, overridesKey, cellID, multiplier).Err()
+}
+
+func (e *Engine) ClearOverride(ctx context.Context, cellID string) error {
+	return e.rdb.HDel(ctx, overridesKey, cellID).Err()
+}
+
+func validCellID(id string) bool {
+	row, col, ok := strings.Cut(id, ":")
+	if !ok {
+		return false
+	}
+	if _, err := strconv.Atoi(row); err != nil {
+		return false
+	}
+	_, err := strconv.Atoi(col)
+	return err == nil
+}
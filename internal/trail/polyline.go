@@ -0,0 +1,35 @@
+package trail
+
+import (
+	"math"
+	"strings"
+
+	"rickshaw-app/internal/models"
+)
+
+// Encode returns the trail in Google's encoded polyline format with five
+// decimal places of precision.
+func Encode(points []models.RideTrailPoint) string {
+	var b strings.Builder
+	var prevLat, prevLng int64
+	for _, p := range points {
+		lat := int64(math.Round(p.Lat * 1e5))
+		lng := int64(math.Round(p.Lng * 1e5))
+		encodeValue(&b, lat-prevLat)
+		encodeValue(&b, lng-prevLng)
+		prevLat, prevLng = lat, lng
+	}
+	return b.String()
+}
+
+func encodeValue(b *strings.Builder, v int64) {
+	u := v << 1
+	if v < 0 {
+		u = ^u
+	}
+	for u >= 0x20 {
+		b.WriteByte(byte((0x20 | (u & 0x1f)) + 63))
+		u >>= 5
+	}
+	b.WriteByte(byte(u + 63))
+}
@@ -0,0 +1,32 @@
+package trail
+
+import (
+	"testing"
+
+	"rickshaw-app/internal/models"
+)
+
+func TestEncode(t *testing.T) {
+	tests := []struct {
+		name   string
+		points [][2]float64
+		want   string
+	}{
+		// The example from Google's polyline algorithm documentation.
+		{"google example", [][2]float64{{38.5, -120.2}, {40.7, -120.95}, {43.252, -126.453}}, "_p~iF~ps|U_ulLnnqC_mqNvxq`@"},
+		{"empty", nil, ""},
+		{"origin", [][2]float64{{0, 0}}, "??"},
+		{"repeated point", [][2]float64{{23.8103, 90.4125}, {23.8103, 90.4125}}, "kmipCcuyfP??"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			points := make([]models.RideTrailPoint, len(tt.points))
+			for i, p := range tt.points {
+				points[i] = models.RideTrailPoint{Lat: p[0], Lng: p[1]}
+			}
+			if got := Encode(points); got != tt.want {
+				t.Errorf("Encode() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,172 @@
+package trail
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"time"
+
+	"rickshaw-app/internal/geo"
+	"rickshaw-app/internal/models"
+
+	"github.com/redis/go-redis/v9"
+	"gorm.io/gorm"
+)
+
+const (
+	// batchSize is how many points are buffered in Redis before they are
+	// written to Postgres.
+	batchSize = 20
+	// minMoveKm drops GPS jitter while the rickshaw is standing still.
+	minMoveKm = 0.005
+	// maxSpeedKmh drops jumps no rickshaw could have made.
+	maxSpeedKmh = 80.0
+
+	keyTTL = 24 * time.Hour
+)
+
+type Point struct {
+	Lat      float64   `json:"lat"`
+	Lng      float64   `json:"lng"`
+	At       time.Time `json:"at"`
+	Accuracy *float64  `json:"accuracy,omitempty"`
+	Speed    *float64  `json:"speed,omitempty"`
+	Heading  *float64  `json:"heading,omitempty"`
+}
+
+// Recorder keeps the breadcrumb trail of rides in progress. New points go
+// to a Redis buffer and are flushed to Postgres in batches.
+type Recorder struct {
+	db  *gorm.DB
+	rdb *redis.Client
+}
+
+func NewRecorder(db *gorm.DB, rdb *redis.Client) *Recorder {
+	return &Recorder{db: db, rdb: rdb}
+}
+
+func bufferKey(rideID string) string {
+	return "trail:" + rideID + ":buffer"
+}
+
+func lastKey(rideID string) string {
+	return "trail:" + rideID + ":last"
+}
+
+// Add appends p to the ride's trail unless it is out of order, too close to
+// the previous point or an impossible jump. It reports whether the point was
+// kept.
+func (r *Recorder) Add(ctx context.Context, rideID string, p Point) (bool, error) {
+	last, err := r.last(ctx, rideID)
+	if err != nil {
+		return false, err
+	}
+	if last != nil && !accept(*last, p) {
+		return false, nil
+	}
+
+	payload, err := json.Marshal(p)
+	if err != nil {
+		return false, err
+	}
+
+	pipe := r.rdb.TxPipeline()
+	length := pipe.RPush(ctx, bufferKey(rideID), payload)
+	pipe.Expire(ctx, bufferKey(rideID), keyTTL)
+	pipe.Set(ctx, lastKey(rideID), payload, keyTTL)
+	if _, err := pipe.Exec(ctx); err != nil {
+		return false, err
+	}
+
+	if length.Val() >= batchSize {
+		return true, r.Flush(ctx, rideID)
+	}
+	return true, nil
+}
+
+// Flush writes the buffered points of a ride to Postgres.
+func (r *Recorder) Flush(ctx context.Context, rideID string) error {
+	pipe := r.rdb.TxPipeline()
+	buffered := pipe.LRange(ctx, bufferKey(rideID), 0, -1)
+	pipe.Del(ctx, bufferKey(rideID))
+	if _, err := pipe.Exec(ctx); err != nil {
+		return err
+	}
+
+	rows := make([]models.RideTrailPoint, 0, len(buffered.Val()))
+	for _, raw := range buffered.Val() {
+		var p Point
+		if err := json.Unmarshal([]byte(raw), &p); err != nil {
+			continue
+		}
+		rows = append(rows, models.RideTrailPoint{
+			RideID:     rideID,
+			Lat:        p.Lat,
+			Lng:        p.Lng,
+			RecordedAt: p.At,
+			Accuracy:   p.Accuracy,
+			Speed:      p.Speed,
+			Heading:    p.Heading,
+		})
+	}
+	if len(rows) == 0 {
+		return nil
+	}
+	return r.db.CreateInBatches(&rows, 100).Error
+}
+
+// Finish flushes the trail of a ride that has ended and forgets its last
+// position.
+func (r *Recorder) Finish(ctx context.Context, rideID string) error {
+	if err := r.Flush(ctx, rideID); err != nil {
+		return err
+	}
+	return r.rdb.Del(ctx, lastKey(rideID)).Err()
+}
+
+// Points returns the ride's trail in order, including points still
+// buffered in Redis.
+func (r *Recorder) Points(ctx context.Context, rideID string) ([]models.RideTrailPoint, error) {
+	if err := r.Flush(ctx, rideID); err != nil {
+		return nil, err
+	}
+	var points []models.RideTrailPoint
+	err := r.db.Where("ride_id = ?", rideID).Order("recorded_at, id").Find(&points).Error
+	return points, err
+}
+
+func (r *Recorder) last(ctx context.Context, rideID string) (*Point, error) {
+	raw, err := r.rdb.Get(ctx, lastKey(rideID)).Bytes()
+	if errors.Is(err, redis.Nil) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	var p Point
+	if err := json.Unmarshal(raw, &p); err != nil {
+		return nil, nil
+	}
+	return &p, nil
+}
+
+func accept(last, p Point) bool {
+	if !p.At.After(last.At) {
+		return false
+	}
+	d := geo.Distance(last.Lat, last.Lng, p.Lat, p.Lng)
+	if d < minMoveKm {
+		return false
+	}
+	hours := p.At.Sub(last.At).Hours()
+	return d/hours <= maxSpeedKmh
+}
+
+// Distance returns the length of the trail in km.
+func Distance(points []models.RideTrailPoint) float64 {
+	var total float64
+	for i := 1; i < len(points); i++ {
+		total += geo.Distance(points[i-1].Lat, points[i-1].Lng, points[i].Lat, points[i].Lng)
+	}
+	return total
+}
@@ -0,0 +1,62 @@
+package trail
+
+import (
+	"math"
+	"testing"
+	"time"
+
+	"rickshaw-app/internal/models"
+)
+
+func TestAccept(t *testing.T) {
+	start := time.Date(2026, 3, 1, 12, 0, 0, 0, time.UTC)
+	last := Point{Lat: 23.8103, Lng: 90.4125, At: start}
+
+	// One degree of latitude is about 111.2 km.
+	north := func(km float64, after time.Duration) Point {
+		return Point{Lat: last.Lat + km/111.195, Lng: last.Lng, At: start.Add(after)}
+	}
+
+	tests := []struct {
+		name string
+		p    Point
+		want bool
+	}{
+		{"normal move", north(0.1, 10*time.Second), true},
+		{"same timestamp", north(0.1, 0), false},
+		{"out of order", north(0.1, -10*time.Second), false},
+		{"standing still", north(0, 10*time.Second), false},
+		{"jitter below minMoveKm", north(minMoveKm/2, 10*time.Second), false},
+		{"just over minMoveKm", north(minMoveKm*1.1, 10*time.Second), true},
+		{"fast but possible", north(0.2, 10*time.Second), true},               // 72 km/h
+		{"impossible jump", north(1, 10*time.Second), false},                  // 360 km/h
+		{"long gap makes a far point plausible", north(1, time.Minute), true}, // 60 km/h
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := accept(last, tt.p); got != tt.want {
+				t.Errorf("accept() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestDistance(t *testing.T) {
+	if got := Distance(nil); got != 0 {
+		t.Errorf("Distance(nil) = %v, want 0", got)
+	}
+	if got := Distance([]models.RideTrailPoint{{Lat: 23.8, Lng: 90.4}}); got != 0 {
+		t.Errorf("Distance of one point = %v, want 0", got)
+	}
+
+	// Three legs of 0.01 degrees of latitude, about 1.11 km each.
+	points := []models.RideTrailPoint{
+		{Lat: 23.80, Lng: 90.4},
+		{Lat: 23.81, Lng: 90.4},
+		{Lat: 23.82, Lng: 90.4},
+		{Lat: 23.81, Lng: 90.4},
+	}
+	if got := Distance(points); math.Abs(got-3.336) > 0.01 {
+		t.Errorf("Distance() = %.3f km, want about 3.336", got)
+	}
+}
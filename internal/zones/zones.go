@@ -0,0 +1,177 @@
+package zones
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math"
+
+	"rickshaw-app/internal/models"
+
+	"gorm.io/gorm"
+)
+
+var ErrOutsideServiceArea = errors.New("location is outside the service area")
+
+// polygon is a list of linear rings in GeoJSON [lng, lat] order. The first
+// ring is the outer boundary; the rest are holes.
+type polygon [][][2]float64
+
+type Service struct {
+	db *gorm.DB
+}
+
+func NewService(db *gorm.DB) *Service {
+	return &Service{db: db}
+}
+
+// Locate returns the enabled zone containing the point. When no zones have
+// been configured at all the whole map is in service and Locate returns
+// nil with no error.
+func (s *Service) Locate(lat, lng float64) (*models.ServiceZone, error) {
+	var all []models.ServiceZone
+	if err := s.db.Order("key").Find(&all).Error; err != nil {
+		return nil, err
+	}
+	if len(all) == 0 {
+		return nil, nil
+	}
+
+	for i := range all {
+		zone := &all[i]
+		if !zone.Enabled {
+			continue
+		}
+		inside, err := Contains(zone.Geometry, lat, lng)
+		if err != nil {
+			return nil, fmt.Errorf("zone %s: %w", zone.Key, err)
+		}
+		if inside {
+			return zone, nil
+		}
+	}
+	return nil, ErrOutsideServiceArea
+}
+
+func (s *Service) Enabled() ([]models.ServiceZone, error) {
+	var zones []models.ServiceZone
+	err := s.db.Where("enabled = ?", true).Order("key").Find(&zones).Error
+	return zones, err
+}
+
+// Validate checks that g is a well-formed Polygon or MultiPolygon.
+func Validate(g models.Geometry) error {
+	polygons, err := parse(g)
+	if err != nil {
+		return err
+	}
+	for _, p := range polygons {
+		if len(p) == 0 {
+			return errors.New("polygon has no rings")
+		}
+		for _, ring := range p {
+			if len(ring) < 4 || ring[0] != ring[len(ring)-1] {
+				return errors.New("each ring must be closed and have at least four positions")
+			}
+			for _, pos := range ring {
+				if pos[0] < -180 || pos[0] > 180 || pos[1] < -90 || pos[1] > 90 {
+					return errors.New("coordinates out of range")
+				}
+			}
+		}
+	}
+	return nil
+}
+
+// Contains reports whether the point lies in g. Points on a boundary,
+// whether the outer ring or a hole's, count as inside, so a pickup on the
+// road that marks a zone's edge is served.
+func Contains(g models.Geometry, lat, lng float64) (bool, error) {
+	polygons, err := parse(g)
+	if err != nil {
+		return false, err
+	}
+	for _, p := range polygons {
+		if len(p) == 0 {
+			continue
+		}
+		if onRing(p[0], lat, lng) {
+			return true, nil
+		}
+		if !inRing(p[0], lat, lng) {
+			continue
+		}
+		inHole := false
+		for _, hole := range p[1:] {
+			if inRing(hole, lat, lng) && !onRing(hole, lat, lng) {
+				inHole = true
+				break
+			}
+		}
+		if !inHole {
+			return true, nil
+		}
+	}
+	return false, nil
+}
+
+func parse(g models.Geometry) ([]polygon, error) {
+	switch g.Type {
+	case "Polygon":
+		var p polygon
+		if err := json.Unmarshal(g.Coordinates, &p); err != nil {
+			return nil, fmt.Errorf("invalid polygon coordinates: %w", err)
+		}
+		return []polygon{p}, nil
+	case "MultiPolygon":
+		var ps []polygon
+		if err := json.Unmarshal(g.Coordinates, &ps); err != nil {
+			return nil, fmt.Errorf("invalid multipolygon coordinates: %w", err)
+		}
+		return ps, nil
+	default:
+		return nil, fmt.Errorf("unsupported geometry type %q", g.Type)
+	}
+}
+
+// boundaryTolerance is how close, in degrees, a point must be to a ring's
+// edge to count as on it; about a centimetre.
+const boundaryTolerance = 1e-7
+
+// onRing reports whether the point lies on one of the ring's edges.
+func onRing(ring [][2]float64, lat, lng float64) bool {
+	for i := 1; i < len(ring); i++ {
+		x1, y1 := ring[i-1][0], ring[i-1][1]
+		x2, y2 := ring[i][0], ring[i][1]
+		if lng < math.Min(x1, x2)-boundaryTolerance || lng > math.Max(x1, x2)+boundaryTolerance ||
+			lat < math.Min(y1, y2)-boundaryTolerance || lat > math.Max(y1, y2)+boundaryTolerance {
+			continue
+		}
+		length := math.Hypot(x2-x1, y2-y1)
+		if length == 0 {
+			if math.Hypot(lng-x1, lat-y1) <= boundaryTolerance {
+				return true
+			}
+			continue
+		}
+		// Distance from the point to the edge's line.
+		if math.Abs((x2-x1)*(lat-y1)-(y2-y1)*(lng-x1))/length <= boundaryTolerance {
+			return true
+		}
+	}
+	return false
+}
+
+// inRing is the even-odd ray casting test. Points on an edge may land
+// either side; Contains checks those with onRing first.
+func inRing(ring [][2]float64, lat, lng float64) bool {
+	inside := false
+	for i, j := 0, len(ring)-1; i < len(ring); j, i = i, i+1 {
+		xi, yi := ring[i][0], ring[i][1]
+		xj, yj := ring[j][0], ring[j][1]
+		if (yi > lat) != (yj > lat) && lng < (xj-xi)*(lat-yi)/(yj-yi)+xi {
+			inside = !inside
+		}
+	}
+	return inside
+}
@@ -0,0 +1,107 @@
+package zones
+
+import (
+	"encoding/json"
+	"testing"
+
+	"rickshaw-app/internal/models"
+)
+
+func geometry(typ, coordinates string) models.Geometry {
+	return models.Geometry{Type: typ, Coordinates: json.RawMessage(coordinates)}
+}
+
+// square is a 10 by 10 degree polygon with a 2 by 2 hole in the middle.
+var square = geometry("Polygon", `[
+	[[0, 0], [10, 0], [10, 10], [0, 10], [0, 0]],
+	[[4, 4], [6, 4], [6, 6], [4, 6], [4, 4]]
+]`)
+
+// islands is two unit squares, one around the origin and one east of it.
+var islands = geometry("MultiPolygon", `[
+	[[[-1, -1], [1, -1], [1, 1], [-1, 1], [-1, -1]]],
+	[[[20, 20], [22, 20], [22, 22], [20, 22], [20, 20]]]
+]`)
+
+func TestContains(t *testing.T) {
+	tests := []struct {
+		name     string
+		g        models.Geometry
+		lat, lng float64
+		want     bool
+	}{
+		{"inside", square, 2, 2, true},
+		{"outside", square, 12, 2, false},
+		{"west of the polygon", square, 5, -1, false},
+		{"in the hole", square, 5, 5, false},
+		{"between hole and edge", square, 5, 8, true},
+		{"on the west edge", square, 5, 0, true},
+		{"on the east edge", square, 5, 10, true},
+		{"on the south edge", square, 0, 5, true},
+		{"on the north edge", square, 10, 5, true},
+		{"on a corner", square, 10, 10, true},
+		{"on the hole's edge", square, 5, 4, true},
+		{"just outside the east edge", square, 5, 10.001, false},
+		{"origin in a polygon around it", islands, 0, 0, true},
+		{"origin outside a polygon", square, 0.5, -0.5, false},
+		{"first of a multipolygon", islands, 0.5, 0.5, true},
+		{"second of a multipolygon", islands, 21, 21, true},
+		{"between multipolygon parts", islands, 10, 10, false},
+		{"origin against a distant polygon", geometry("Polygon", `[[[90.3, 23.7], [90.5, 23.7], [90.5, 23.9], [90.3, 23.9], [90.3, 23.7]]]`), 0, 0, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := Contains(tt.g, tt.lat, tt.lng)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got != tt.want {
+				t.Errorf("Contains(%v, %v) = %v, want %v", tt.lat, tt.lng, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestContainsInvalid(t *testing.T) {
+	for _, g := range []models.Geometry{
+		geometry("Point", `[0, 0]`),
+		geometry("Polygon", `{"not": "coordinates"}`),
+		geometry("MultiPolygon", `[[[0, 0]]]`),
+	} {
+		if _, err := Contains(g, 0, 0); err == nil {
+			t.Errorf("Contains(%s %s) did not fail", g.Type, g.Coordinates)
+		}
+	}
+}
+
+func TestValidate(t *testing.T) {
+	tests := []struct {
+		name    string
+		g       models.Geometry
+		wantErr bool
+	}{
+		{"polygon with a hole", square, false},
+		{"multipolygon", islands, false},
+		{"polygon around the origin", geometry("Polygon", `[[[-1, -1], [1, -1], [1, 1], [-1, -1]]]`), false},
+		{"unclosed ring", geometry("Polygon", `[[[0, 0], [10, 0], [10, 10], [0, 10]]]`), true},
+		{"unclosed hole", geometry("Polygon", `[
+			[[0, 0], [10, 0], [10, 10], [0, 10], [0, 0]],
+			[[4, 4], [6, 4], [6, 6], [4, 6]]
+		]`), true},
+		{"too few positions", geometry("Polygon", `[[[0, 0], [10, 0], [0, 0]]]`), true},
+		{"no rings", geometry("Polygon", `[]`), true},
+		{"empty polygon in a multipolygon", geometry("MultiPolygon", `[[]]`), true},
+		{"longitude out of range", geometry("Polygon", `[[[0, 0], [190, 0], [190, 10], [0, 0]]]`), true},
+		{"latitude out of range", geometry("Polygon", `[[[0, 0], [10, 0], [10, 95], [0, 0]]]`), true},
+		{"unsupported type", geometry("LineString", `[[0, 0], [1, 1]]`), true},
+		{"malformed coordinates", geometry("Polygon", `"nope"`), true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := Validate(tt.g)
+			if (err != nil) != tt.wantErr {
+				t.Errorf("Validate() error = %v, wantErr %v", err, tt.wantErr)
+			}
+		})
+	}
+}
@@ -13,6 +13,7 @@ import (
 	"rickshaw-app/internal/config"
 	"rickshaw-app/internal/database"
 	"rickshaw-app/internal/redis"
+	"rickshaw-app/internal/surge"
 )
 
 func main() {
@@ -25,7 +26,11 @@ func main() {
 
 	rdb := redis.Connect(cfg.RedisURL)
 
-	router := api.NewRouter(db, rdb, cfg)
+	workers, stopWorkers := context.WithCancel(context.Background())
+	defer stopWorkers()
+	go surge.NewEngine(db, rdb, cfg).Run(workers)
+
+	router := api.NewRouter(workers, db, rdb, cfg)
 
 	srv := &http.Server{
 		Addr:         cfg.ServerAddr,
@@ -47,6 +52,7 @@ func main() {
 	<-quit
 
 	log.Println("Shutting down server...")
+	stopWorkers()
 	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
 	defer cancel()
 
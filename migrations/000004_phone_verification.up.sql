@@ -0,0 +1,5 @@
+ALTER TABLE users ADD COLUMN phone_verified BOOLEAN NOT NULL DEFAULT FALSE;
+
+-- Accounts created before verification existed keep working; only new
+-- signups have to verify.
+UPDATE users SET phone_verified = TRUE;
@@ -0,0 +1,4 @@
+ALTER TABLE rides DROP COLUMN IF EXISTS fare_breakdown;
+ALTER TABLE rides DROP COLUMN IF EXISTS vehicle_class;
+DROP TABLE IF EXISTS tariff_multipliers;
+DROP TABLE IF EXISTS tariffs;
@@ -0,0 +1,33 @@
+CREATE TABLE tariffs (
+    id UUID PRIMARY KEY DEFAULT gen_random_uuid(),
+    name VARCHAR(100) NOT NULL,
+    vehicle_class VARCHAR(32) NOT NULL DEFAULT 'standard',
+    zone VARCHAR(64) NOT NULL DEFAULT '',
+    base_fare DOUBLE PRECISION NOT NULL,
+    per_km DOUBLE PRECISION NOT NULL,
+    per_minute DOUBLE PRECISION NOT NULL DEFAULT 0,
+    minimum_fare DOUBLE PRECISION NOT NULL DEFAULT 0,
+    booking_fee DOUBLE PRECISION NOT NULL DEFAULT 0,
+    effective_from TIMESTAMP NOT NULL,
+    created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP
+);
+
+CREATE INDEX idx_tariffs_lookup ON tariffs(vehicle_class, zone, effective_from DESC);
+
+CREATE TABLE tariff_multipliers (
+    id UUID PRIMARY KEY DEFAULT gen_random_uuid(),
+    tariff_id UUID NOT NULL REFERENCES tariffs(id) ON DELETE CASCADE,
+    name VARCHAR(50) NOT NULL,
+    start_hour INTEGER NOT NULL CHECK (start_hour >= 0 AND start_hour < 24),
+    end_hour INTEGER NOT NULL CHECK (end_hour >= 0 AND end_hour <= 24),
+    multiplier DOUBLE PRECISION NOT NULL CHECK (multiplier > 0)
+);
+
+CREATE INDEX idx_tariff_multipliers_tariff_id ON tariff_multipliers(tariff_id);
+
+ALTER TABLE rides ADD COLUMN vehicle_class VARCHAR(32) NOT NULL DEFAULT 'standard';
+ALTER TABLE rides ADD COLUMN fare_breakdown JSONB;
+
+-- Matches the fare the API charged before tariffs were configurable.
+INSERT INTO tariffs (name, vehicle_class, base_fare, per_km, effective_from)
+VALUES ('Standard', 'standard', 20, 30, '2000-01-01');
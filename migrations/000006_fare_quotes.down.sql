@@ -0,0 +1,2 @@
+ALTER TABLE rides DROP COLUMN IF EXISTS fare_quote_id;
+DROP TABLE IF EXISTS fare_quotes;
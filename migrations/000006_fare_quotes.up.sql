@@ -0,0 +1,22 @@
+CREATE TABLE fare_quotes (
+    id UUID PRIMARY KEY DEFAULT gen_random_uuid(),
+    rider_id UUID NOT NULL,
+    pickup_lat DOUBLE PRECISION NOT NULL,
+    pickup_lng DOUBLE PRECISION NOT NULL,
+    pickup_address TEXT,
+    dropoff_lat DOUBLE PRECISION NOT NULL,
+    dropoff_lng DOUBLE PRECISION NOT NULL,
+    dropoff_address TEXT,
+    vehicle_class VARCHAR(32) NOT NULL DEFAULT 'standard',
+    fare DOUBLE PRECISION NOT NULL,
+    fare_breakdown JSONB NOT NULL,
+    distance DOUBLE PRECISION NOT NULL,
+    duration INTEGER NOT NULL,
+    ride_id UUID,
+    expires_at TIMESTAMP NOT NULL,
+    created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP
+);
+
+CREATE INDEX idx_fare_quotes_rider_id ON fare_quotes(rider_id);
+
+ALTER TABLE rides ADD COLUMN fare_quote_id UUID;
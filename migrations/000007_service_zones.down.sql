@@ -0,0 +1,3 @@
+ALTER TABLE fare_quotes DROP COLUMN IF EXISTS zone;
+ALTER TABLE rides DROP COLUMN IF EXISTS zone;
+DROP TABLE IF EXISTS service_zones;
@@ -0,0 +1,14 @@
+CREATE TABLE service_zones (
+    id UUID PRIMARY KEY DEFAULT gen_random_uuid(),
+    key VARCHAR(64) NOT NULL UNIQUE,
+    name VARCHAR(255) NOT NULL,
+    geometry JSONB NOT NULL,
+    enabled BOOLEAN NOT NULL DEFAULT TRUE,
+    created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+    updated_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP
+);
+
+ALTER TABLE rides ADD COLUMN zone VARCHAR(64) NOT NULL DEFAULT '';
+ALTER TABLE fare_quotes ADD COLUMN zone VARCHAR(64) NOT NULL DEFAULT '';
+
+CREATE INDEX idx_rides_zone ON rides(zone);
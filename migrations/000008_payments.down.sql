@@ -0,0 +1,4 @@
+ALTER TABLE rides DROP COLUMN IF EXISTS payment_status;
+ALTER TABLE rides DROP COLUMN IF EXISTS payment_method;
+DROP TABLE IF EXISTS ledger_entries;
+DROP TABLE IF EXISTS payments;
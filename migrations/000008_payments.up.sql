@@ -0,0 +1,38 @@
+CREATE TABLE payments (
+    id UUID PRIMARY KEY DEFAULT gen_random_uuid(),
+    ride_id UUID NOT NULL UNIQUE,
+    rider_id UUID NOT NULL,
+    method VARCHAR(20) NOT NULL CHECK (method IN ('cash', 'card')),
+    provider VARCHAR(32) NOT NULL,
+    provider_token TEXT,
+    provider_ref TEXT,
+    amount BIGINT NOT NULL,
+    currency VARCHAR(3) NOT NULL,
+    status VARCHAR(20) NOT NULL DEFAULT 'pending' CHECK (status IN ('pending', 'processing', 'succeeded', 'failed', 'voided')),
+    failure_reason TEXT,
+    created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+    updated_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP
+);
+
+CREATE INDEX idx_payments_rider_id ON payments(rider_id);
+CREATE INDEX idx_payments_status ON payments(status);
+
+-- Every transaction_id groups entries whose amounts sum to zero.
+CREATE TABLE ledger_entries (
+    id UUID PRIMARY KEY DEFAULT gen_random_uuid(),
+    transaction_id UUID NOT NULL,
+    account VARCHAR(100) NOT NULL,
+    amount BIGINT NOT NULL,
+    currency VARCHAR(3) NOT NULL,
+    ride_id UUID,
+    payment_id UUID,
+    description TEXT,
+    created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP
+);
+
+CREATE INDEX idx_ledger_entries_transaction_id ON ledger_entries(transaction_id);
+CREATE INDEX idx_ledger_entries_account ON ledger_entries(account);
+CREATE INDEX idx_ledger_entries_ride_id ON ledger_entries(ride_id);
+
+ALTER TABLE rides ADD COLUMN payment_method VARCHAR(20) NOT NULL DEFAULT 'cash';
+ALTER TABLE rides ADD COLUMN payment_status VARCHAR(20) NOT NULL DEFAULT 'pending';
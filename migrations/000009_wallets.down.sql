@@ -0,0 +1,7 @@
+-- Wallet payments already recorded stay as history; NOT VALID keeps them
+-- from blocking the rollback while new rows are held to cash and card.
+ALTER TABLE payments DROP CONSTRAINT payments_method_check;
+ALTER TABLE payments ADD CONSTRAINT payments_method_check CHECK (method IN ('cash', 'card')) NOT VALID;
+
+DROP TABLE IF EXISTS wallet_transactions;
+DROP TABLE IF EXISTS wallets;
@@ -0,0 +1,28 @@
+CREATE TABLE wallets (
+    id UUID PRIMARY KEY DEFAULT gen_random_uuid(),
+    user_id UUID NOT NULL UNIQUE,
+    balance BIGINT NOT NULL DEFAULT 0 CHECK (balance >= 0),
+    held BIGINT NOT NULL DEFAULT 0 CHECK (held >= 0),
+    currency VARCHAR(3) NOT NULL,
+    created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+    updated_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+    CHECK (held <= balance)
+);
+
+CREATE TABLE wallet_transactions (
+    id UUID PRIMARY KEY DEFAULT gen_random_uuid(),
+    wallet_id UUID NOT NULL REFERENCES wallets(id),
+    type VARCHAR(20) NOT NULL CHECK (type IN ('topup', 'hold', 'capture', 'release')),
+    amount BIGINT NOT NULL,
+    balance BIGINT NOT NULL,
+    held BIGINT NOT NULL,
+    ride_id UUID,
+    reference TEXT,
+    idempotency_key VARCHAR(200) NOT NULL UNIQUE,
+    created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP
+);
+
+CREATE INDEX idx_wallet_transactions_wallet_id ON wallet_transactions(wallet_id, created_at);
+
+ALTER TABLE payments DROP CONSTRAINT payments_method_check;
+ALTER TABLE payments ADD CONSTRAINT payments_method_check CHECK (method IN ('cash', 'card', 'wallet'));
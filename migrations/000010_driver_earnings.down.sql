@@ -0,0 +1,2 @@
+DROP TABLE IF EXISTS driver_earnings;
+DROP TABLE IF EXISTS payouts;
@@ -0,0 +1,38 @@
+CREATE TABLE payouts (
+    id UUID PRIMARY KEY DEFAULT gen_random_uuid(),
+    driver_id UUID NOT NULL,
+    period_start TIMESTAMP NOT NULL,
+    period_end TIMESTAMP NOT NULL,
+    rides INTEGER NOT NULL,
+    gross BIGINT NOT NULL,
+    commission BIGINT NOT NULL,
+    tips BIGINT NOT NULL,
+    net BIGINT NOT NULL,
+    cash_collected BIGINT NOT NULL,
+    amount BIGINT NOT NULL,
+    currency VARCHAR(3) NOT NULL,
+    status VARCHAR(20) NOT NULL DEFAULT 'pending' CHECK (status IN ('pending', 'paid')),
+    paid_at TIMESTAMP,
+    created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP
+);
+
+CREATE INDEX idx_payouts_driver_id ON payouts(driver_id, period_start);
+
+CREATE TABLE driver_earnings (
+    id UUID PRIMARY KEY DEFAULT gen_random_uuid(),
+    ride_id UUID NOT NULL UNIQUE,
+    driver_id UUID NOT NULL,
+    fare BIGINT NOT NULL,
+    commission_rate DOUBLE PRECISION NOT NULL,
+    commission BIGINT NOT NULL,
+    tip BIGINT NOT NULL DEFAULT 0,
+    net BIGINT NOT NULL,
+    cash_collected BIGINT NOT NULL DEFAULT 0,
+    currency VARCHAR(3) NOT NULL,
+    payout_id UUID REFERENCES payouts(id),
+    earned_at TIMESTAMP NOT NULL,
+    created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP
+);
+
+CREATE INDEX idx_driver_earnings_driver_id ON driver_earnings(driver_id, earned_at);
+CREATE INDEX idx_driver_earnings_payout_id ON driver_earnings(payout_id);
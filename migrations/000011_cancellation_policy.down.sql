@@ -0,0 +1,12 @@
+DROP INDEX IF EXISTS idx_drivers_cancellation_flagged;
+ALTER TABLE drivers DROP COLUMN IF EXISTS cancellation_flagged;
+ALTER TABLE drivers DROP COLUMN IF EXISTS cancellation_rate;
+
+ALTER TABLE ride_histories DROP COLUMN IF EXISTS reason;
+ALTER TABLE ride_histories DROP COLUMN IF EXISTS actor_id;
+ALTER TABLE ride_histories DROP COLUMN IF EXISTS actor;
+
+ALTER TABLE rides DROP COLUMN IF EXISTS cancellation_fee;
+ALTER TABLE rides DROP COLUMN IF EXISTS cancelled_by;
+ALTER TABLE rides DROP COLUMN IF EXISTS cancel_reason;
+ALTER TABLE rides DROP COLUMN IF EXISTS accepted_at;
@@ -0,0 +1,22 @@
+ALTER TABLE rides ADD COLUMN accepted_at TIMESTAMP;
+ALTER TABLE rides ADD COLUMN cancel_reason VARCHAR(50) NOT NULL DEFAULT '';
+ALTER TABLE rides ADD COLUMN cancelled_by VARCHAR(20) NOT NULL DEFAULT '';
+ALTER TABLE rides ADD COLUMN cancellation_fee DOUBLE PRECISION NOT NULL DEFAULT 0;
+
+UPDATE rides r SET accepted_at = h.created_at
+FROM (
+    SELECT ride_id, MIN(created_at) AS created_at
+    FROM ride_histories
+    WHERE status = 'accepted'
+    GROUP BY ride_id
+) h
+WHERE h.ride_id = r.id;
+
+ALTER TABLE ride_histories ADD COLUMN actor VARCHAR(20) NOT NULL DEFAULT '';
+ALTER TABLE ride_histories ADD COLUMN actor_id VARCHAR(64) NOT NULL DEFAULT '';
+ALTER TABLE ride_histories ADD COLUMN reason VARCHAR(50) NOT NULL DEFAULT '';
+
+ALTER TABLE drivers ADD COLUMN cancellation_rate DOUBLE PRECISION NOT NULL DEFAULT 0;
+ALTER TABLE drivers ADD COLUMN cancellation_flagged BOOLEAN NOT NULL DEFAULT false;
+
+CREATE INDEX idx_drivers_cancellation_flagged ON drivers(cancellation_flagged) WHERE cancellation_flagged;
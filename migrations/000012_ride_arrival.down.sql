@@ -0,0 +1,9 @@
+ALTER TABLE rides DROP COLUMN IF EXISTS wait_charge;
+ALTER TABLE rides DROP COLUMN IF EXISTS wait_minutes;
+ALTER TABLE rides DROP COLUMN IF EXISTS started_at;
+ALTER TABLE rides DROP COLUMN IF EXISTS arrived_at;
+
+UPDATE rides SET status = 'accepted' WHERE status = 'arrived';
+ALTER TABLE rides DROP CONSTRAINT rides_status_check;
+ALTER TABLE rides ADD CONSTRAINT rides_status_check
+    CHECK (status IN ('requested', 'accepted', 'started', 'completed', 'cancelled'));
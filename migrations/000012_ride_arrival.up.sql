@@ -0,0 +1,8 @@
+ALTER TABLE rides DROP CONSTRAINT rides_status_check;
+ALTER TABLE rides ADD CONSTRAINT rides_status_check
+    CHECK (status IN ('requested', 'accepted', 'arrived', 'started', 'completed', 'cancelled'));
+
+ALTER TABLE rides ADD COLUMN arrived_at TIMESTAMP;
+ALTER TABLE rides ADD COLUMN started_at TIMESTAMP;
+ALTER TABLE rides ADD COLUMN wait_minutes INTEGER NOT NULL DEFAULT 0;
+ALTER TABLE rides ADD COLUMN wait_charge DOUBLE PRECISION NOT NULL DEFAULT 0;
@@ -0,0 +1,2 @@
+ALTER TABLE rides DROP COLUMN IF EXISTS start_pin_attempts;
+ALTER TABLE rides DROP COLUMN IF EXISTS start_pin;
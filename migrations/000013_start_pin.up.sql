@@ -0,0 +1,2 @@
+ALTER TABLE rides ADD COLUMN start_pin VARCHAR(4) NOT NULL DEFAULT '';
+ALTER TABLE rides ADD COLUMN start_pin_attempts INTEGER NOT NULL DEFAULT 0;
@@ -0,0 +1,3 @@
+ALTER TABLE rides DROP COLUMN IF EXISTS estimated_duration;
+ALTER TABLE rides DROP COLUMN IF EXISTS estimated_distance;
+DROP TABLE IF EXISTS ride_trail_points;
@@ -0,0 +1,13 @@
+CREATE TABLE ride_trail_points (
+    id BIGSERIAL PRIMARY KEY,
+    ride_id UUID NOT NULL,
+    lat DOUBLE PRECISION NOT NULL,
+    lng DOUBLE PRECISION NOT NULL,
+    recorded_at TIMESTAMP NOT NULL,
+    created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP
+);
+
+CREATE INDEX idx_ride_trail_points_ride_id ON ride_trail_points(ride_id, recorded_at);
+
+ALTER TABLE rides ADD COLUMN estimated_distance DOUBLE PRECISION;
+ALTER TABLE rides ADD COLUMN estimated_duration INTEGER;
@@ -0,0 +1,5 @@
+ALTER TABLE drivers DROP COLUMN IF EXISTS location_updated_at;
+
+ALTER TABLE ride_trail_points DROP COLUMN IF EXISTS heading;
+ALTER TABLE ride_trail_points DROP COLUMN IF EXISTS speed;
+ALTER TABLE ride_trail_points DROP COLUMN IF EXISTS accuracy;
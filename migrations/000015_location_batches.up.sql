@@ -0,0 +1,5 @@
+ALTER TABLE ride_trail_points ADD COLUMN accuracy DOUBLE PRECISION;
+ALTER TABLE ride_trail_points ADD COLUMN speed DOUBLE PRECISION;
+ALTER TABLE ride_trail_points ADD COLUMN heading DOUBLE PRECISION;
+
+ALTER TABLE drivers ADD COLUMN location_updated_at TIMESTAMP;
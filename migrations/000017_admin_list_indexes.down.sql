@@ -0,0 +1,6 @@
+DROP INDEX IF EXISTS idx_ride_histories_created_at_id;
+DROP INDEX IF EXISTS idx_ratings_created_at_id;
+DROP INDEX IF EXISTS idx_users_created_at_id;
+DROP INDEX IF EXISTS idx_drivers_created_at_id;
+DROP INDEX IF EXISTS idx_rides_status_created_at;
+DROP INDEX IF EXISTS idx_rides_created_at_id;
@@ -0,0 +1,7 @@
+-- Keyset pagination on the admin lists orders by (sort column, id).
+CREATE INDEX idx_rides_created_at_id ON rides(created_at, id);
+CREATE INDEX idx_rides_status_created_at ON rides(status, created_at);
+CREATE INDEX idx_drivers_created_at_id ON drivers(created_at, id);
+CREATE INDEX idx_users_created_at_id ON users(created_at, id);
+CREATE INDEX idx_ratings_created_at_id ON ratings(created_at, id);
+CREATE INDEX idx_ride_histories_created_at_id ON ride_histories(created_at, id);
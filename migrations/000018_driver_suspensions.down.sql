@@ -0,0 +1,4 @@
+ALTER TABLE drivers DROP COLUMN IF EXISTS suspended_until;
+ALTER TABLE drivers DROP COLUMN IF EXISTS status_reason;
+ALTER TABLE drivers DROP CONSTRAINT IF EXISTS drivers_status_check;
+ALTER TABLE drivers DROP COLUMN IF EXISTS status;
@@ -0,0 +1,4 @@
+ALTER TABLE drivers ADD COLUMN status VARCHAR(20) NOT NULL DEFAULT 'active';
+ALTER TABLE drivers ADD CONSTRAINT drivers_status_check CHECK (status IN ('active', 'suspended', 'banned'));
+ALTER TABLE drivers ADD COLUMN status_reason TEXT NOT NULL DEFAULT '';
+ALTER TABLE drivers ADD COLUMN suspended_until TIMESTAMP;
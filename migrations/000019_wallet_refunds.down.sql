@@ -0,0 +1,5 @@
+-- Refunds already recorded stay as history; NOT VALID keeps them from
+-- blocking the rollback while new rows are held to the old types.
+ALTER TABLE wallet_transactions DROP CONSTRAINT wallet_transactions_type_check;
+ALTER TABLE wallet_transactions ADD CONSTRAINT wallet_transactions_type_check
+    CHECK (type IN ('topup', 'hold', 'capture', 'release')) NOT VALID;
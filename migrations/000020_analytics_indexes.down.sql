@@ -0,0 +1,3 @@
+DROP INDEX IF EXISTS idx_driver_earnings_earned_at;
+DROP INDEX IF EXISTS idx_rides_accepted_at;
+DROP INDEX IF EXISTS idx_rides_zone_created_at;
@@ -0,0 +1,4 @@
+-- Admin analytics aggregate over time ranges, optionally within a zone.
+CREATE INDEX idx_rides_zone_created_at ON rides(zone, created_at);
+CREATE INDEX idx_rides_accepted_at ON rides(accepted_at);
+CREATE INDEX idx_driver_earnings_earned_at ON driver_earnings(earned_at);
@@ -0,0 +1 @@
+DROP TABLE IF EXISTS fare_adjustments;
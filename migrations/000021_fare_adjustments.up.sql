@@ -0,0 +1,17 @@
+CREATE TABLE fare_adjustments (
+    id UUID PRIMARY KEY DEFAULT gen_random_uuid(),
+    ride_id UUID NOT NULL REFERENCES rides(id),
+    from_fare DOUBLE PRECISION NOT NULL,
+    to_fare DOUBLE PRECISION NOT NULL,
+    amount BIGINT NOT NULL,
+    note TEXT NOT NULL,
+    actor VARCHAR(100) NOT NULL,
+    status VARCHAR(20) NOT NULL CHECK (status IN ('pending', 'succeeded', 'failed')),
+    error TEXT NOT NULL DEFAULT '',
+    created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+    updated_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP
+);
+
+CREATE INDEX idx_fare_adjustments_ride_id ON fare_adjustments(ride_id);
+
+CREATE UNIQUE INDEX idx_fare_adjustments_pending ON fare_adjustments(ride_id) WHERE status = 'pending';
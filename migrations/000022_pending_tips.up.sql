@@ -0,0 +1 @@
+ALTER TABLE driver_earnings ADD COLUMN pending_tip BIGINT NOT NULL DEFAULT 0;
@@ -0,0 +1 @@
+ALTER TABLE rides ADD COLUMN pickup_eta TIMESTAMP;
@@ -0,0 +1,9 @@
+UPDATE ride_histories SET status = CASE event
+        WHEN 'offer' THEN 'offered'
+        WHEN 'decline_offer' THEN 'offer_declined'
+        WHEN 'expire_offer' THEN 'offer_expired'
+        WHEN 'unassign' THEN 'unassigned'
+    END
+WHERE event IN ('offer', 'decline_offer', 'expire_offer', 'unassign');
+
+ALTER TABLE ride_histories DROP COLUMN IF EXISTS event;
@@ -0,0 +1,13 @@
+ALTER TABLE ride_histories ADD COLUMN event VARCHAR(30) NOT NULL DEFAULT '';
+
+-- The dispatcher used to record its offers as if they were statuses. They
+-- all happened while the ride was requested.
+UPDATE ride_histories SET
+    event = CASE status
+        WHEN 'offered' THEN 'offer'
+        WHEN 'offer_declined' THEN 'decline_offer'
+        WHEN 'offer_expired' THEN 'expire_offer'
+        WHEN 'unassigned' THEN 'unassign'
+    END,
+    status = 'requested'
+WHERE status IN ('offered', 'offer_declined', 'offer_expired', 'unassigned');